@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/clouway/go-epay/pkg/client"
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server/env"
+	"github.com/clouway/go-epay/pkg/server/sqlite"
+)
+
+const envUsage = `Usage: goepay env <command> [arguments]
+
+Manages the environments stored in the SQLite database of SQLITE_DB_PATH. The
+secrets are encrypted with the key of EPAY_SECRETS_KEY or EPAY_SECRETS_KEY_FILE
+(32 bytes encoded as base64 or hex).
+
+Commands:
+  list                 lists the environments
+  get <name>           prints the environment as JSON
+  set <name> [flags]   creates or updates the environment
+  delete <name>        deletes the environment
+  import <file>        imports the environments of a JSON file ("-" for stdin)
+  export [file]        exports the environments as JSON (secrets are not encrypted)
+`
+
+// errUsage is returned when the env command is called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// runEnvCommand runs the env subcommand with the provided arguments.
+func runEnvCommand(ctx context.Context, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	key, err := secretsKey()
+	if err != nil {
+		return err
+	}
+	store, err := sqlite.NewEnvironmentStore(sqliteDBPath(), key)
+	if err != nil {
+		return fmt.Errorf("could not open environments due: %v", err)
+	}
+	defer store.Close()
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		return listEnvironments(ctx, store, stdout)
+	case "get":
+		return getEnvironment(ctx, store, args, stdout)
+	case "set":
+		return setEnvironment(ctx, store, args)
+	case "delete":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return store.Delete(ctx, args[0])
+	case "import":
+		return importEnvironments(ctx, store, args)
+	case "export":
+		return exportEnvironments(ctx, store, args, stdout)
+	}
+	return errUsage
+}
+
+func listEnvironments(ctx context.Context, store *sqlite.EnvironmentStore, stdout io.Writer) error {
+	tenants, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tMERCHANT\tBILLING\tHOSTS")
+	for _, t := range tenants {
+		billing := t.BillingSystem
+		if billing == "" {
+			var systems []string
+			for _, r := range t.BillingRoutes {
+				systems = append(systems, r.BillingSystem)
+			}
+			billing = strings.Join(systems, ",")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Name, t.MerchantID, billing, strings.Join(t.Hosts, ","))
+	}
+	return w.Flush()
+}
+
+func getEnvironment(ctx context.Context, store *sqlite.EnvironmentStore, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	showSecrets := fs.Bool("show-secrets", false, "print the secrets instead of masking them")
+	if len(args) == 0 {
+		return errUsage
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	t, err := store.GetTenant(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !*showSecrets {
+		maskSecrets(t)
+	}
+	return writeJSON(stdout, t)
+}
+
+func setEnvironment(ctx context.Context, store *sqlite.EnvironmentStore, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errUsage
+	}
+	name := args[0]
+
+	t, err := store.GetTenant(ctx, name)
+	if errors.Is(err, epay.ErrEnvironmentNotFound) {
+		t = &env.Tenant{Name: name}
+	} else if err != nil {
+		return err
+	}
+
+	var hosts, metadata, metadataFiles, routes, networks stringList
+	var secretFile, jwtKeyFile, rulesFile string
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	fs.StringVar(&t.MerchantID, "merchant-id", t.MerchantID, "ePay merchant ID")
+	fs.StringVar(&t.EpaySecret, "epay-secret", t.EpaySecret, "ePay secret, -epay-secret-file keeps it out of the shell history")
+	fs.StringVar(&secretFile, "epay-secret-file", "", "file with the ePay secret (\"-\" for stdin)")
+	fs.StringVar(&t.BillingSystem, "billing-system", t.BillingSystem, fmt.Sprintf("billing system: %s", billingSystems()))
+	fs.Var(&routes, "route", "route as system, system=contract-code or system=<IDN regexp>, replaces the current routes (repeatable)")
+	fs.StringVar(&t.BillingURL, "billing-url", t.BillingURL, "TelcoNG billing URL")
+	fs.StringVar(&jwtKeyFile, "billing-jwt-key-file", "", "file with the TelcoNG JWT key")
+	fs.StringVar(&t.AmountPolicy, "amount-policy", t.AmountPolicy, "amount policy: exact, partial, over or any")
+	fs.StringVar(&t.Currency, "currency", t.Currency, "currency of the amounts sent to ePay")
+	fs.StringVar(&t.ChecksumAlgorithm, "checksum-algorithm", t.ChecksumAlgorithm, "checksum algorithm: hmac-sha1 or hmac-sha256")
+	fs.StringVar(&t.ChecksumPayload, "checksum-payload", t.ChecksumPayload, "payload covered by the checksum: query or encoded")
+	fs.BoolVar(&t.SignResponses, "sign-responses", t.SignResponses, "sign the responses to ePay")
+	fs.Var(&networks, "allow", "network as CIDR or IP from which ePay requests are accepted, replaces the current networks (repeatable)")
+	fs.StringVar(&rulesFile, "rules-file", "", "file with JSON list of the subscriber rules, replaces the current rules")
+	fs.Var(&hosts, "host", "host of the environment, replaces the current hosts (repeatable)")
+	fs.Var(&metadata, "meta", "metadata as key=value, an empty value removes the key (repeatable)")
+	fs.Var(&metadataFiles, "meta-file", "metadata as key=file, e.g for secrets like apiKey (\"-\" for stdin, repeatable)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	if secretFile != "" {
+		if t.EpaySecret, err = readSecret(secretFile); err != nil {
+			return fmt.Errorf("could not read ePay secret due: %v", err)
+		}
+	}
+	if jwtKeyFile != "" {
+		b, err := ioutil.ReadFile(jwtKeyFile)
+		if err != nil {
+			return fmt.Errorf("could not read JWT key due: %v", err)
+		}
+		t.BillingJWTKey = string(b)
+	}
+	if len(hosts) > 0 {
+		t.Hosts = hosts
+	}
+	if rulesFile != "" {
+		b, err := ioutil.ReadFile(rulesFile)
+		if err != nil {
+			return fmt.Errorf("could not read subscriber rules due: %v", err)
+		}
+		t.SubscriberRules = nil
+		if err := json.Unmarshal(b, &t.SubscriberRules); err != nil {
+			return fmt.Errorf("could not parse subscriber rules due: %v", err)
+		}
+	}
+	if len(networks) > 0 {
+		t.AllowedNetworks = networks
+	}
+	if len(routes) > 0 {
+		t.BillingRoutes = nil
+		for _, r := range routes {
+			t.BillingRoutes = append(t.BillingRoutes, parseRoute(r))
+		}
+	}
+	for _, kv := range metadata {
+		i := strings.IndexByte(kv, '=')
+		if i <= 0 {
+			return fmt.Errorf("invalid metadata '%s', expected key=value", kv)
+		}
+		if t.Metadata == nil {
+			t.Metadata = make(map[string]string)
+		}
+		if k, v := kv[:i], kv[i+1:]; v != "" {
+			t.Metadata[k] = v
+		} else {
+			delete(t.Metadata, k)
+		}
+	}
+
+	for _, kv := range metadataFiles {
+		i := strings.IndexByte(kv, '=')
+		if i <= 0 {
+			return fmt.Errorf("invalid metadata file '%s', expected key=file", kv)
+		}
+		v, err := readSecret(kv[i+1:])
+		if err != nil {
+			return fmt.Errorf("could not read metadata '%s' due: %v", kv[:i], err)
+		}
+		if t.Metadata == nil {
+			t.Metadata = make(map[string]string)
+		}
+		t.Metadata[kv[:i]] = v
+	}
+
+	return store.Put(ctx, t)
+}
+
+// readSecret reads the secret of the file or of stdin when it's "-". The
+// trailing new line of the file is not part of the secret.
+func readSecret(file string) (string, error) {
+	var b []byte
+	var err error
+	if file == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func importEnvironments(ctx context.Context, store *sqlite.EnvironmentStore, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	var b []byte
+	var err error
+	if args[0] == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(args[0])
+	}
+	if err != nil {
+		return fmt.Errorf("could not read environments due: %v", err)
+	}
+
+	tenants, err := env.ParseTenants(b)
+	if err != nil {
+		return fmt.Errorf("invalid environments: %v", err)
+	}
+	for i := range tenants.Environments {
+		if err := store.Put(ctx, &tenants.Environments[i]); err != nil {
+			return err
+		}
+	}
+	if tenants.Default != "" {
+		return store.SetDefault(ctx, tenants.Default)
+	}
+	return nil
+}
+
+func exportEnvironments(ctx context.Context, store *sqlite.EnvironmentStore, args []string, stdout io.Writer) error {
+	tenants, err := store.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	defaultName, err := store.Default(ctx)
+	if err != nil {
+		return err
+	}
+
+	export := env.Tenants{Default: defaultName, Environments: make([]env.Tenant, 0, len(tenants))}
+	for _, t := range tenants {
+		// the environment named "default" is used when no default is set
+		if defaultName == "" && t.Name == "default" {
+			export.Default = t.Name
+		}
+		export.Environments = append(export.Environments, *t)
+	}
+
+	if len(args) == 0 {
+		return writeJSON(stdout, export)
+	}
+	f, err := os.OpenFile(args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(f, export); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseRoute parses a route of the form system, system=contract-code
+// or system=<IDN regexp>.
+func parseRoute(s string) epay.BillingRoute {
+	system, match := s, ""
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		system, match = s[:i], s[i+1:]
+	}
+
+	r := epay.BillingRoute{BillingSystem: system}
+	if match == "contract-code" {
+		r.ContractCode = true
+	} else {
+		r.Pattern = match
+	}
+	return r
+}
+
+// billingSystems lists the names of the registered billing systems.
+func billingSystems() string {
+	var names []string
+	for _, name := range client.Backends() {
+		names = append(names, string(name))
+	}
+	return strings.Join(names, ", ")
+}
+
+// maskSecrets replaces the secrets of the tenant with a mask.
+func maskSecrets(t *env.Tenant) {
+	mask := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return "********"
+	}
+
+	t.EpaySecret = mask(t.EpaySecret)
+	t.BillingJWTKey = mask(t.BillingJWTKey)
+	for _, k := range sqlite.SecretMetadataKeys {
+		if _, ok := t.Metadata[k]; ok {
+			t.Metadata[k] = mask(t.Metadata[k])
+		}
+	}
+}
+
+// secretsKey loads the key used for encryption of the secrets from
+// EPAY_SECRETS_KEY or from the file of EPAY_SECRETS_KEY_FILE.
+func secretsKey() ([]byte, error) {
+	value := os.Getenv("EPAY_SECRETS_KEY")
+	if file := os.Getenv("EPAY_SECRETS_KEY_FILE"); value == "" && file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read secrets key due: %v", err)
+		}
+		value = string(b)
+	}
+	if value == "" {
+		return nil, fmt.Errorf("EPAY_SECRETS_KEY or EPAY_SECRETS_KEY_FILE is required")
+	}
+	return sqlite.ParseSecretsKey(value)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// stringList is a flag which could be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
@@ -2,16 +2,25 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/andyfusniak/stackdriver-gae-logrus-plugin"
 	lmiddleware "github.com/andyfusniak/stackdriver-gae-logrus-plugin/middleware"
 	"github.com/clouway/go-epay/pkg/client"
+	"github.com/clouway/go-epay/pkg/client/token"
+	"github.com/clouway/go-epay/pkg/client/transport"
 	"github.com/clouway/go-epay/pkg/epay"
 	"github.com/clouway/go-epay/pkg/server/api"
 	"github.com/clouway/go-epay/pkg/server/db"
 	"github.com/clouway/go-epay/pkg/server/env"
+	"github.com/clouway/go-epay/pkg/server/httputil"
 	"github.com/clouway/go-epay/pkg/server/middleware"
 	"github.com/clouway/go-epay/pkg/server/sqlite"
 
@@ -21,12 +30,35 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// envFileCheckInterval is the interval on which the environments file is checked for changes.
+const envFileCheckInterval = 5 * time.Second
+
 func main() {
 	ctx := context.Background()
+
+	if len(os.Args) > 1 && os.Args[1] == "env" {
+		if err := runEnvCommand(ctx, os.Args[2:], os.Stdout); err != nil {
+			if err == errUsage {
+				fmt.Fprint(os.Stderr, envUsage)
+			} else {
+				fmt.Fprintf(os.Stderr, "goepay env: %v\n", err)
+			}
+			os.Exit(2)
+		}
+		return
+	}
+
 	projectID := os.Getenv("GOOGLE_CLOUD_PROJECT")
 
 	var envStore epay.EnvironmentStore
 	var cf epay.ClientFactory
+	var replayStore middleware.ReplayStore
+
+	// The IPs of the clients are resolved through the proxies in front of the
+	// service. No proxies are trusted by default, as any container or host in
+	// the private networks could set the client IP headers otherwise.
+	var trustedProxies []string
+	ipHeaders := middleware.DefaultIPHeaders
 
 	if projectID != "" {
 		// GAE Mode: Use Datastore for configuration
@@ -44,6 +76,11 @@ func main() {
 
 		envStore = db.NewEnvironmentStore(dClient)
 		poStore := db.NewPaymentOrderStore(dClient)
+		replayStore = db.NewReplayStore(dClient)
+
+		// the client IP is set by the front end of GAE
+		trustedProxies = []string{"0.0.0.0/0", "::/0"}
+		ipHeaders = []string{middleware.HeaderAppEngineIP}
 		cf = client.NewClientFactory(poStore)
 	} else {
 		// Docker Mode: Use environment variables for configuration
@@ -52,7 +89,36 @@ func main() {
 		// JSON formatter for Docker logs
 		log.SetFormatter(&log.JSONFormatter{})
 
-		envStore = env.NewEnvironmentStore()
+		// Multiple tenants could be configured in a file or in the SQLite database,
+		// otherwise a single environment is configured by the environment variables.
+		envFile := os.Getenv("EPAY_ENVIRONMENTS_FILE")
+		multiTenant := envFile != "" || os.Getenv("EPAY_ENVIRONMENT_STORE") == "sqlite"
+		if os.Getenv("EPAY_ENVIRONMENT_STORE") == "sqlite" {
+			key, err := secretsKey()
+			if err != nil {
+				log.Fatalf("Failed to load secrets key: %v", err)
+			}
+			envStore, err = sqlite.NewEnvironmentStore(sqliteDBPath(), key)
+			if err != nil {
+				log.Fatalf("Failed to create SQLite environment store: %v", err)
+			}
+			log.Infof("Using environments from SQLite database at: %s", sqliteDBPath())
+		} else if envFile != "" {
+			fileStore, err := env.NewFileEnvironmentStore(envFile)
+			if err != nil {
+				log.Fatalf("Failed to load environments: %v", err)
+			}
+			go fileStore.Watch(ctx, envFileCheckInterval)
+			go reloadOnHangup(fileStore)
+
+			envStore = fileStore
+			log.Infof("Using environments from: %s", envFile)
+		} else {
+			envStore = env.NewEnvironmentStore()
+			if _, err := envStore.Get(ctx, ""); err != nil {
+				log.Fatalf("Failed to load environment: %v", err)
+			}
+		}
 
 		// Get billing system from environment
 		billingSystem := client.BillingSystem(os.Getenv("BILLING_SYSTEM"))
@@ -60,14 +126,12 @@ func main() {
 			billingSystem = client.BillingSystemTelcoNG // Default to TelcoNG
 		}
 
-		// Create SQLite store for PaymentOrders (used by UCRM)
-		dbPath := os.Getenv("SQLITE_DB_PATH")
-		if dbPath == "" {
-			dbPath = "/app/data/payment_orders.db"
-		}
+		// Create SQLite store for PaymentOrders (used by UCRM and Splynx)
+		dbPath := sqliteDBPath()
 
 		var poStore epay.PaymentOrderStore
-		if billingSystem == client.BillingSystemUCRM {
+		// each of the multiple tenants and each of the routes could use them too
+		if billingSystem != client.BillingSystemTelcoNG || multiTenant || os.Getenv("BILLING_ROUTES") != "" {
 			var err error
 			poStore, err = sqlite.NewPaymentOrderStore(dbPath)
 			if err != nil {
@@ -76,13 +140,46 @@ func main() {
 			log.Infof("Using SQLite database at: %s", dbPath)
 		}
 
-		cf = client.NewClientFactoryWithBillingSystem(poStore, billingSystem)
+		replayStore = newReplayStore(dbPath)
+
+		opts := client.Options{PaymentOrderStore: poStore, TokenCache: newTokenCache(dbPath)}
+		cf = client.NewClientFactoryWithOptions(opts, []epay.BillingRoute{{BillingSystem: string(billingSystem)}})
 		log.Infof("Billing system configured: %s", billingSystem)
 	}
 
 	log.SetOutput(os.Stdout)
 	log.SetLevel(log.DebugLevel)
 
+	// Legacy XTYPE=QBN/QBC TCP channel served by the same billing clients
+	if tcpAddr := os.Getenv("EPAY_TCP_ADDR"); tcpAddr != "" {
+		l, err := net.Listen("tcp", tcpAddr)
+		if err != nil {
+			log.Fatalf("Failed to listen on %s: %v", tcpAddr, err)
+		}
+
+		tcpServer := epay.NewServer()
+		gateway := epay.NewGateway(cf, envStore, os.Getenv("EPAY_TCP_ENVIRONMENT"))
+		go func() {
+			if err := tcpServer.Serve(ctx, l, gateway); err != epay.ErrServerClosed {
+				log.Fatalf("ePay TCP server failed: %v", err)
+			}
+		}()
+		log.Printf("Listening for ePay TCP requests on %s", tcpAddr)
+	}
+
+	if v := os.Getenv("EPAY_TRUSTED_PROXIES"); v != "" {
+		trustedProxies = strings.Split(v, ",")
+	} else if projectID == "" {
+		log.Warn("EPAY_TRUSTED_PROXIES is not set, the client IP headers are ignored and the IP of the connection is used, e.g the IP of Cloudflare Tunnel")
+	}
+	if v := os.Getenv("EPAY_CLIENT_IP_HEADERS"); v != "" {
+		ipHeaders = strings.Split(v, ",")
+	}
+	proxies, err := middleware.NewTrustedProxies(trustedProxies, ipHeaders)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
 	r := mux.NewRouter()
 
 	// Health check endpoint for Docker
@@ -91,16 +188,32 @@ func main() {
 		w.Write([]byte("OK"))
 	}).Methods("GET")
 
-	skipChecks := middleware.Skip("IDN", map[string]interface{}{
-		"1111111111": true,
-	})
+	// Metrics of the calls to the billing systems
+	r.HandleFunc("/metrics/billing", func(w http.ResponseWriter, r *http.Request) {
+		httputil.RespondWithJSON(r.Context(), w, transport.Metrics())
+	}).Methods("GET")
+
 	epayAPI := middleware.EpayAPIMiddleware(envStore)
 
-	r.Handle("/v1/pay/init", skipChecks(epayAPI(api.CheckBill(cf)))).Queries("TYPE", "CHECK")
-	r.Handle("/v1/pay/init", epayAPI(api.CreatePaymentOrder(cf))).Queries("TYPE", "BILLING")
-	r.Handle("/v1/pay/confirm", epayAPI(api.ConfirmPaymentOrder(cf))).Queries("TYPE", "BILLING")
+	// replayed requests are answered as duplicates without calling the billing systems
+	replayConfig := middleware.ReplayConfig{TTL: durationEnv("EPAY_REPLAY_TTL"), Window: durationEnv("EPAY_REPLAY_WINDOW")}
+	replayedOrder := middleware.ReplayGuard(replayStore, replayConfig, api.StatusNoDuties)
+	replayedPayment := middleware.ReplayGuard(replayStore, replayConfig, api.StatusAlreadyPaid)
+
+	// blocked subscribers, test IDNs and maintenance windows are configured per environment
+	checkRules := middleware.SubscriberRules(epay.RuleCheck)
+	billingRules := middleware.SubscriberRules(epay.RuleBilling)
+	confirmRules := middleware.SubscriberRules(epay.RuleConfirm)
+
+	// the TYPE could be sent only in the ENCODED payload, so the requests are
+	// dispatched by it after they are decoded
+	r.Handle("/v1/pay/init", epayAPI(api.Init(
+		checkRules(api.CheckBill(cf)),
+		billingRules(replayedOrder(api.CreatePaymentOrder(cf))),
+	)))
+	r.Handle("/v1/pay/confirm", epayAPI(confirmRules(replayedPayment(api.ConfirmPaymentOrder(cf)))))
 
-	http.Handle("/", lmiddleware.XCloudTraceContext(r))
+	http.Handle("/", lmiddleware.XCloudTraceContext(middleware.RealIP(proxies)(r)))
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -113,3 +226,68 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// sqliteDBPath returns the path of the SQLite database.
+func sqliteDBPath() string {
+	if dbPath := os.Getenv("SQLITE_DB_PATH"); dbPath != "" {
+		return dbPath
+	}
+	return "/app/data/payment_orders.db"
+}
+
+// newTokenCache creates the cache of the OAuth2 tokens of the billing systems. The
+// tokens are kept in the SQLite database when the secrets key is configured, so
+// they are encrypted at rest, and they are kept in the process otherwise.
+func newTokenCache(dbPath string) *token.Cache {
+	key, err := secretsKey()
+	if err != nil {
+		return token.NewCache(nil, token.DefaultEarlyRefresh)
+	}
+
+	store, err := sqlite.NewTokenStore(dbPath, key)
+	if err != nil {
+		log.Errorf("Failed to create SQLite token store, tokens are kept in the process: %v", err)
+		return token.NewCache(nil, token.DefaultEarlyRefresh)
+	}
+	log.Infof("Using SQLite database for tokens at: %s", dbPath)
+	return token.NewCache(store, token.DefaultEarlyRefresh)
+}
+
+// newReplayStore creates the store of the handled ePay requests. The requests are
+// kept in the SQLite database, or in the process when it's not available.
+func newReplayStore(dbPath string) middleware.ReplayStore {
+	store, err := sqlite.NewReplayStore(dbPath)
+	if err != nil {
+		log.Errorf("Failed to create SQLite replay store, requests are kept in the process: %v", err)
+		return middleware.NewMemoryReplayStore()
+	}
+	return store
+}
+
+// durationEnv parses the duration of the environment variable. Zero is
+// returned when it's not set or it's not valid.
+func durationEnv(name string) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Errorf("Invalid duration of %s '%s', using the default: %v", name, v, err)
+		return 0
+	}
+	return d
+}
+
+// reloadOnHangup reloads the environments from the file on every SIGHUP.
+func reloadOnHangup(store *env.FileEnvironmentStore) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	for range hup {
+		if err := store.Reload(); err != nil {
+			log.Errorf("Failed to reload environments: %v", err)
+			continue
+		}
+		log.Info("Environments were reloaded")
+	}
+}
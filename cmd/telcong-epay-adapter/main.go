@@ -10,8 +10,8 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/clouway/go-epay/pkg/client/telcong"
 	"github.com/clouway/go-epay/pkg/epay"
@@ -20,14 +20,12 @@ import (
 )
 
 var (
-	listenAddr     = flag.String("listenAddr", ":5555", "the tcp listenAddr of the epay-adapter server")
-	billingKeyFile = flag.String("billing-key-file", "app.key", "the path to the billing API keyfile")
-	billingURL     = flag.String("billing-url", "https://cloud.telcong.com", "the url of the billing server")
-)
-
-const (
-	// EPAY payment source
-	EPAY epay.PaymentSource = "EPAY"
+	listenAddr      = flag.String("listenAddr", ":5555", "the tcp listenAddr of the epay-adapter server")
+	billingKeyFile  = flag.String("billing-key-file", "app.key", "the path to the billing API keyfile")
+	billingURL      = flag.String("billing-url", "https://cloud.telcong.com", "the url of the billing server")
+	readTimeout     = flag.Duration("read-timeout", 30*time.Second, "the maximum duration for reading of a single request")
+	writeTimeout    = flag.Duration("write-timeout", 30*time.Second, "the maximum duration for writing of a single response")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "the maximum duration to wait for in-flight requests during shutdown")
 )
 
 func main() {
@@ -52,10 +50,17 @@ func main() {
 	client := telcong.NewClient(oauth2client, telcongURL)
 
 	server := epay.NewServer()
-	go server.Serve(l, &telcongEpayGateway{client})
+	server.ReadTimeout = *readTimeout
+	server.WriteTimeout = *writeTimeout
 
-	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
+	go func() {
+		if err := server.Serve(context.Background(), l, epay.NewClientGateway(client)); err != epay.ErrServerClosed {
+			log.Fatalf("unable to serve due: %v", err)
+		}
+	}()
+
+	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	log.Printf("Listening on %s", *listenAddr)
 	log.Printf("Billing URL: %s", *billingURL)
@@ -66,7 +71,12 @@ func main() {
 	go func() {
 		sig := <-sigs
 		log.Printf("got: %v\n", sig)
-		server.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("could not gracefully shutdown due: %v", err)
+		}
 		done <- true
 	}()
 	<-done
@@ -74,53 +84,6 @@ func main() {
 	log.Println("ePay adapter terminated successfully")
 }
 
-type telcongEpayGateway struct {
-	client epay.Client
-}
-
-// GetCurrentBill returns the current bill of the provided customer.
-func (t *telcongEpayGateway) GetCurrentBill(customerID, transactionID string) (*epay.BillResponse, error) {
-	res, err := t.client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: customerID, TransactionID: transactionID, PaymentSource: EPAY})
-	if err != nil {
-		if err == epay.ErrPaymentOrderAlreadyExists {
-			return nil, fmt.Errorf("bill with transactionId '%s' was already processed", transactionID)
-		}
-
-		if err == epay.ErrSubscriberNotFound {
-			return &epay.BillResponse{Successful: false, UnknownSubscriber: true}, nil
-		}
-
-		return nil, err
-	}
-	// Convert currency to be applicable with the epay adapter as it
-	// receives only value in coins.
-	amount, _ := strconv.ParseFloat(res.Amount.Value, 64)
-	inCoins := int(amount * 100)
-
-	return &epay.BillResponse{Successful: true, Amount: inCoins}, nil
-}
-
-// PayBill pays bill using the provided amount
-func (t *telcongEpayGateway) PayBill(customerID, transactionID string, Amount int) (*epay.PaymentResponse, error) {
-	paymentOrder, err := t.client.GetPaymentOrder(context.Background(), transactionID)
-
-	if err != nil {
-		if err == epay.ErrPaymentOrderNotFound {
-			return &epay.PaymentResponse{Successful: false}, nil
-		}
-		return nil, fmt.Errorf("could not retrieve payment order due: %v", err)
-	}
-
-	_, err = t.client.PayPaymentOrder(context.Background(), paymentOrder.ID)
-	if err != nil {
-		if err == epay.ErrPaymentOrderAlreadyPaid {
-			return &epay.PaymentResponse{Successful: false, AlreadyPaid: true}, nil
-		}
-		return nil, err
-	}
-	return &epay.PaymentResponse{Successful: true}, nil
-}
-
 func loadConf(file string) (*jwt.Config, error) {
 	f, err := os.Open(file)
 	if err != nil {
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+
+	"github.com/clouway/go-epay/pkg/client/rest"
+	"github.com/clouway/go-epay/pkg/client/splynx"
+	"github.com/clouway/go-epay/pkg/client/telcong"
+	"github.com/clouway/go-epay/pkg/client/token"
+	"github.com/clouway/go-epay/pkg/client/ucrm"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+func init() {
+	Register(BillingSystemTelcoNG, telcongBackend{})
+	Register(BillingSystemUCRM, ucrmBackend{})
+	Register(BillingSystemSplynx, splynxBackend{})
+	Register(BillingSystemREST, restBackend{})
+}
+
+// telcongBackend creates clients of TelcoNG.
+type telcongBackend struct{}
+
+func (telcongBackend) Configured(env epay.Environment) bool {
+	return env.BillingURL != "" || env.BillingJWTKey != ""
+}
+
+func (telcongBackend) Config(env epay.Environment) (interface{}, error) {
+	return telcong.ConfigFromEnvironment(env)
+}
+
+func (telcongBackend) NewClient(ctx context.Context, config interface{}, opts Options) (epay.Client, error) {
+	conf := config.(*telcong.Config)
+
+	// the token requests and the authorized requests are made by the transport
+	// of the provided client. Tokens are requested out of the context of the
+	// request as they are shared between the requests by the cache, but the
+	// requests are waiting for them only within their context.
+	tokenCtx := context.Background()
+	if opts.HTTPClient != nil {
+		tokenCtx = context.WithValue(tokenCtx, oauth2.HTTPClient, opts.HTTPClient)
+	}
+	ts := conf.JWT.TokenSource(tokenCtx)
+	if opts.TokenCache != nil {
+		ts = opts.TokenCache.TokenSource(conf.TokenKey(), ts)
+	} else {
+		ts = oauth2.ReuseTokenSource(nil, ts)
+	}
+
+	httpClient := &http.Client{Transport: &token.Transport{Source: ts}}
+	if opts.HTTPClient != nil {
+		httpClient.Transport = &token.Transport{Source: ts, Base: opts.HTTPClient.Transport}
+		httpClient.Timeout = opts.HTTPClient.Timeout
+	}
+	return telcong.NewClient(httpClient, conf.BillingURL), nil
+}
+
+// ucrmBackend creates clients of UCRM. The payment orders are kept in
+// the PaymentOrderStore as UCRM is not tracking them.
+type ucrmBackend struct{}
+
+func (ucrmBackend) Configured(env epay.Environment) bool {
+	_, ok := env.Metadata["billingUrl"]
+	return ok
+}
+
+func (ucrmBackend) Config(env epay.Environment) (interface{}, error) {
+	return ucrm.ConfigFromEnvironment(env)
+}
+
+func (ucrmBackend) NewClient(ctx context.Context, config interface{}, opts Options) (epay.Client, error) {
+	if opts.PaymentOrderStore == nil {
+		return nil, fmt.Errorf("payment order store is required by ucrm")
+	}
+	conf := *config.(*ucrm.Config)
+	conf.HTTPClient = opts.HTTPClient
+	return ucrm.NewClientFromConfig(conf, opts.PaymentOrderStore), nil
+}
+
+// splynxBackend creates clients of Splynx. The payment orders are kept in
+// the PaymentOrderStore as Splynx is not tracking them.
+type splynxBackend struct{}
+
+func (splynxBackend) Configured(env epay.Environment) bool {
+	_, ok := env.Metadata["splynxUrl"]
+	return ok
+}
+
+func (splynxBackend) Config(env epay.Environment) (interface{}, error) {
+	return splynx.ConfigFromEnvironment(env)
+}
+
+func (splynxBackend) NewClient(ctx context.Context, config interface{}, opts Options) (epay.Client, error) {
+	if opts.PaymentOrderStore == nil {
+		return nil, fmt.Errorf("payment order store is required by splynx")
+	}
+	conf := *config.(*splynx.Config)
+	conf.HTTPClient = opts.HTTPClient
+	return splynx.NewClient(conf, opts.PaymentOrderStore), nil
+}
+
+// restBackend creates clients which call the HTTP endpoints configured in the
+// environment. The payment orders are kept in the PaymentOrderStore.
+type restBackend struct{}
+
+func (restBackend) Configured(env epay.Environment) bool {
+	_, ok := env.Metadata["restUrl"]
+	return ok
+}
+
+func (restBackend) Config(env epay.Environment) (interface{}, error) {
+	return rest.ConfigFromEnvironment(env)
+}
+
+func (restBackend) NewClient(ctx context.Context, config interface{}, opts Options) (epay.Client, error) {
+	if opts.PaymentOrderStore == nil {
+		return nil, fmt.Errorf("payment order store is required by rest")
+	}
+	conf := *config.(*rest.Config)
+	conf.HTTPClient = opts.HTTPClient
+	return rest.NewClient(conf, opts.PaymentOrderStore), nil
+}
@@ -2,12 +2,11 @@ package client
 
 import (
 	"context"
-	"net/url"
+	"fmt"
 
-	"github.com/clouway/go-epay/pkg/client/telcong"
-	"github.com/clouway/go-epay/pkg/client/ucrm"
+	"github.com/clouway/go-epay/pkg/client/token"
+	"github.com/clouway/go-epay/pkg/client/transport"
 	"github.com/clouway/go-epay/pkg/epay"
-	"golang.org/x/oauth2/google"
 )
 
 // BillingSystem represents the billing system type
@@ -18,87 +17,194 @@ const (
 	BillingSystemTelcoNG BillingSystem = "telcong"
 	// BillingSystemUCRM represents the UCRM billing system
 	BillingSystemUCRM BillingSystem = "ucrm"
+	// BillingSystemSplynx represents the Splynx billing system
+	BillingSystemSplynx BillingSystem = "splynx"
+	// BillingSystemREST represents billing systems integrated by configured HTTP endpoints
+	BillingSystemREST BillingSystem = "rest"
 )
 
+// AutoRoutes are the routes used when neither the factory nor the environment
+// selects the billing system. TelcoNG serves the contract codes, UCRM serves the
+// other subscribers when it's configured and TelcoNG is used otherwise.
+var AutoRoutes = []epay.BillingRoute{
+	{BillingSystem: string(BillingSystemTelcoNG), ContractCode: true},
+	{BillingSystem: string(BillingSystemUCRM)},
+	{BillingSystem: string(BillingSystemTelcoNG)},
+}
+
 // NewClientFactory creates a new Factory for Client creation.
 // This is the legacy constructor for GAE deployments that uses automatic
 // billing system detection based on IDN format.
 func NewClientFactory(poStore epay.PaymentOrderStore) epay.ClientFactory {
-	return &clientFactory{poStore: poStore}
+	return NewClientFactoryWithRoutes(poStore, AutoRoutes)
 }
 
 // NewClientFactoryWithBillingSystem creates a new Factory that uses the specified
 // billing system for all requests. This is used for Docker deployments where the
 // billing system is configured via environment variables.
 func NewClientFactoryWithBillingSystem(poStore epay.PaymentOrderStore, billingSystem BillingSystem) epay.ClientFactory {
+	return NewClientFactoryWithRoutes(poStore, []epay.BillingRoute{{BillingSystem: string(billingSystem)}})
+}
+
+// NewClientFactoryWithRoutes creates a new Factory that selects the billing system
+// by the provided routes when the environment is not having own routing.
+func NewClientFactoryWithRoutes(poStore epay.PaymentOrderStore, routes []epay.BillingRoute) epay.ClientFactory {
+	return NewClientFactoryWithOptions(Options{PaymentOrderStore: poStore}, routes)
+}
+
+// NewClientFactoryWithOptions creates a new Factory that provides the options to
+// the backends and selects the billing system by the provided routes. The tokens
+// are cached in the process when the options are not having a TokenCache.
+func NewClientFactoryWithOptions(opts Options, routes []epay.BillingRoute) epay.ClientFactory {
+	if opts.TokenCache == nil {
+		opts.TokenCache = token.NewCache(nil, token.DefaultEarlyRefresh)
+	}
 	return &clientFactory{
-		poStore:       poStore,
-		billingSystem: billingSystem,
+		opts:   opts,
+		routes: routes,
 	}
 }
 
 type clientFactory struct {
-	poStore       epay.PaymentOrderStore
-	billingSystem BillingSystem
+	opts   Options
+	routes []epay.BillingRoute
 }
 
-func (c *clientFactory) Create(ctx context.Context, env epay.Environment, idn string) epay.Client {
-	// Determine which billing system to use
-	useTelcoNG := c.shouldUseTelcoNG(env, idn)
+func (c *clientFactory) Create(ctx context.Context, env epay.Environment, idn string) (epay.Client, error) {
+	name, err := Route(env, idn, c.routes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", epay.ErrInvalidConfig, err)
+	}
+
+	backend, err := lookupBackend(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", epay.ErrInvalidConfig, err)
+	}
+	config, err := backend.Config(env)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", epay.ErrInvalidConfig, err)
+	}
+	tconf, err := transport.ConfigFromEnvironment(env)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", epay.ErrInvalidConfig, err)
+	}
 
-	if useTelcoNG {
-		return c.createTelcoNGClient(ctx, env)
+	opts := c.opts
+	opts.HTTPClient = transport.NewClient(string(name), tconf)
+	client, err := backend.NewClient(ctx, config, opts)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", epay.ErrInvalidConfig, err)
 	}
-	return c.createUCRMClient(env)
+	return client, nil
 }
 
-// shouldUseTelcoNG determines if TelcoNG should be used based on configuration
-func (c *clientFactory) shouldUseTelcoNG(env epay.Environment, idn string) bool {
-	// If billing system is explicitly set (Docker mode), use it
-	if c.billingSystem != "" {
-		return c.billingSystem == BillingSystemTelcoNG
+// Route selects the billing system which serves the subscriber with the provided
+// IDN. The billing system of the environment takes precedence, then the routes of
+// the environment are used and the provided routes are used when it's not having
+// any. The first matching route which billing system is configured in the
+// environment is selected, so the routes form a fallback chain. The first matching
+// route is selected when none of the billing systems is configured.
+func Route(env epay.Environment, idn string, routes []epay.BillingRoute) (BillingSystem, error) {
+	if env.BillingSystem != "" {
+		return BillingSystem(env.BillingSystem), nil
+	}
+	if len(env.BillingRoutes) > 0 {
+		routes = env.BillingRoutes
 	}
 
-	// Legacy GAE mode: auto-detect based on IDN format and available config
-	if IsTelcoNGContractCode(idn) && env.BillingJWTKey != "" && env.BillingURL != "" {
-		return true
+	var matched *epay.BillingRoute
+	for i := range routes {
+		r := &routes[i]
+		ok, err := matchRoute(r, idn)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+
+		backend, err := lookupBackend(BillingSystem(r.BillingSystem))
+		if err != nil {
+			return "", err
+		}
+		if backend.Configured(env) {
+			return BillingSystem(r.BillingSystem), nil
+		}
+		if matched == nil {
+			matched = r
+		}
 	}
 
-	// If UCRM metadata is configured, use UCRM
-	if _, ok := env.Metadata["billingUrl"]; ok {
-		return false
+	if matched == nil {
+		return "", fmt.Errorf("no billing system is routed for subscriber '%s'", idn)
 	}
+	return BillingSystem(matched.BillingSystem), nil
+}
 
-	// Default to TelcoNG
-	return true
+func matchRoute(r *epay.BillingRoute, idn string) (bool, error) {
+	if r.ContractCode && !IsTelcoNGContractCode(idn) {
+		return false, nil
+	}
+	return r.MatchesPattern(idn)
 }
 
-// createTelcoNGClient creates a TelcoNG billing client
-func (c *clientFactory) createTelcoNGClient(ctx context.Context, env epay.Environment) epay.Client {
-	billingURL, _ := url.Parse(env.BillingURL)
-	conf, _ := google.JWTConfigFromJSON([]byte(env.BillingJWTKey))
-	oauth2client := conf.Client(ctx)
-	return telcong.NewClient(oauth2client, billingURL)
+// ValidateEnvironment validates the environment and the configuration of its
+// billing systems. The billing system and the routes of the environment must
+// be configured. When the environment is not having any, the configuration of
+// each billing system which is present in the environment is validated.
+func ValidateEnvironment(env epay.Environment) error {
+	if err := env.Validate(); err != nil {
+		return err
+	}
+	if _, err := transport.ConfigFromEnvironment(env); err != nil {
+		return err
+	}
+
+	if env.BillingSystem != "" {
+		return validateBackend(env, BillingSystem(env.BillingSystem))
+	}
+
+	if len(env.BillingRoutes) > 0 {
+		for i := range env.BillingRoutes {
+			r := &env.BillingRoutes[i]
+			if err := validateBackend(env, BillingSystem(r.BillingSystem)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	configured := false
+	for _, name := range Backends() {
+		backend, _ := lookupBackend(name)
+		if !backend.Configured(env) {
+			continue
+		}
+		if err := validateBackend(env, name); err != nil {
+			return err
+		}
+		configured = true
+	}
+	if !configured {
+		return fmt.Errorf("no billing system is configured")
+	}
+	return nil
 }
 
-// createUCRMClient creates a UCRM billing client
-func (c *clientFactory) createUCRMClient(env epay.Environment) epay.Client {
-	billingURLStr := env.Metadata["billingUrl"]
-	billingURL, _ := url.Parse(billingURLStr)
-	apiKey := env.Metadata["apiKey"]
-	methodID := env.Metadata["methodId"]
-	providerName := env.Metadata["providerName"]
-	providerPaymentID := env.Metadata["providerPaymentId"]
-	providerPaymentTime := env.Metadata["providerPaymentTime"]
-	organizationID := env.Metadata["organizationId"]
-
-	return ucrm.NewClient(billingURL, apiKey, c.poStore, ucrm.PaymentProvider{
-		MethodID:       methodID,
-		Name:           providerName,
-		PaymentID:      providerPaymentID,
-		PaymentTime:    providerPaymentTime,
-		OrganizationID: organizationID,
-	})
+// validateBackend validates the configuration of the billing system in the
+// environment. TelcoNG books the amount of the order on its own, so it's used
+// only with the exact amount policy, otherwise ePay would be answered with
+// success for payments which amount differs from the booked one.
+func validateBackend(env epay.Environment, name BillingSystem) error {
+	backend, err := lookupBackend(name)
+	if err != nil {
+		return err
+	}
+	if name == BillingSystemTelcoNG && env.AmountPolicy != "" && env.AmountPolicy != epay.AmountPolicyExact {
+		return fmt.Errorf("amount policy '%s' is not supported by billing system '%s'", env.AmountPolicy, name)
+	}
+	_, err = backend.Config(env)
+	return err
 }
 
 // IsTelcoNGContractCode validates the provided code using the checksum algorithm.
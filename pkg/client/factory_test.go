@@ -1,7 +1,13 @@
 package client
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
+
+	"github.com/clouway/go-epay/pkg/client/order"
+	"github.com/clouway/go-epay/pkg/epay"
 )
 
 func TestValidate(t *testing.T) {
@@ -55,3 +61,178 @@ func TestValidate(t *testing.T) {
 		}
 	})
 }
+
+func TestCreateClientOfInvalidConfig(t *testing.T) {
+	cases := []struct {
+		name string
+		env  epay.Environment
+	}{
+		{"missing telcong url", epay.Environment{BillingSystem: "telcong", BillingJWTKey: testJWTKey}},
+		{"invalid telcong key", epay.Environment{BillingSystem: "telcong", BillingURL: "https://billing.example.com", BillingJWTKey: "::key::"}},
+		{"missing ucrm api key", epay.Environment{BillingSystem: "ucrm", Metadata: map[string]string{"billingUrl": "https://ucrm.example.com", "methodId": "1"}}},
+		{"invalid ucrm url", epay.Environment{BillingSystem: "ucrm", Metadata: map[string]string{"billingUrl": "::url::", "apiKey": "k", "methodId": "1"}}},
+	}
+
+	cf := NewClientFactory(order.NewFakeStore())
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, err := cf.Create(context.Background(), c.env, "1234")
+			if !errors.Is(err, epay.ErrInvalidConfig) {
+				t.Errorf("expected: %v", epay.ErrInvalidConfig)
+				t.Errorf("     got: %v", err)
+			}
+			if client != nil {
+				t.Errorf("expected no client, but got: %v", client)
+			}
+		})
+	}
+}
+
+func TestValidateEnvironment(t *testing.T) {
+	ucrmConfig := map[string]string{"billingUrl": "https://ucrm.example.com", "apiKey": "k", "methodId": "1"}
+
+	valid := []epay.Environment{
+		{EpaySecret: "s", BillingSystem: "telcong", BillingURL: "https://billing.example.com", BillingJWTKey: testJWTKey},
+		{EpaySecret: "s", BillingSystem: "ucrm", Metadata: ucrmConfig, Currency: "eur"},
+		{EpaySecret: "s", Metadata: ucrmConfig},
+		{EpaySecret: "s", Metadata: ucrmConfig, AllowedNetworks: []string{"91.196.124.0/24", "10.0.0.1", "2001:db8::/32"}},
+		// environments used only by the TCP gateway are not having epay secret
+		{BillingSystem: "ucrm", Metadata: ucrmConfig},
+		{EpaySecret: "s", BillingSystem: "telcong", BillingURL: "https://billing.example.com", BillingJWTKey: testJWTKey, AmountPolicy: "exact"},
+		{EpaySecret: "s", BillingSystem: "ucrm", Metadata: ucrmConfig, AmountPolicy: "partial"},
+	}
+	for _, env := range valid {
+		if err := ValidateEnvironment(env); err != nil {
+			t.Errorf("unexpected error for %+v: %v", env, err)
+		}
+	}
+
+	invalid := []epay.Environment{
+		{EpaySecret: "s", BillingSystem: "other"},
+		{EpaySecret: "s"},
+		{EpaySecret: "s", Metadata: ucrmConfig, AmountPolicy: "some"},
+		{EpaySecret: "s", Metadata: ucrmConfig, Currency: "USD"},
+		{EpaySecret: "s", Metadata: ucrmConfig, BillingURL: "https://billing.example.com"},
+		{EpaySecret: "s", Metadata: ucrmConfig, AllowedNetworks: []string{"91.196.124.0/33"}},
+		{EpaySecret: "s", Metadata: ucrmConfig, ChecksumAlgorithm: "md5"},
+		// TelcoNG books the ordered amount, so only exact amounts are accepted
+		{EpaySecret: "s", BillingSystem: "telcong", BillingURL: "https://billing.example.com", BillingJWTKey: testJWTKey, AmountPolicy: "partial"},
+		{EpaySecret: "s", BillingURL: "https://billing.example.com", BillingJWTKey: testJWTKey, AmountPolicy: "any"},
+		{EpaySecret: "s", Metadata: ucrmConfig, BillingURL: "https://billing.example.com", BillingJWTKey: testJWTKey, AmountPolicy: "over",
+			BillingRoutes: []epay.BillingRoute{{BillingSystem: "telcong", ContractCode: true}, {BillingSystem: "ucrm"}}},
+	}
+	for _, env := range invalid {
+		if err := ValidateEnvironment(env); err == nil {
+			t.Errorf("expected %+v to be rejected", env)
+		}
+	}
+}
+
+const testJWTKey = `{"type": "service_account", "client_email": "epay@example.com", "private_key": "::key::"}`
+
+func TestRouteSubscribers(t *testing.T) {
+	telcongConfig := epay.Environment{BillingURL: "https://billing.example.com", BillingJWTKey: testJWTKey}
+	ucrmConfig := map[string]string{"billingUrl": "https://ucrm.example.com", "apiKey": "k", "methodId": "1"}
+	both := telcongConfig
+	both.Metadata = ucrmConfig
+
+	cases := []struct {
+		name   string
+		env    epay.Environment
+		idn    string
+		routes []epay.BillingRoute
+		want   BillingSystem
+	}{
+		{"billing system of environment", epay.Environment{BillingSystem: "ucrm"}, "8215923", AutoRoutes, BillingSystemUCRM},
+		{"contract code", both, "8215923", AutoRoutes, BillingSystemTelcoNG},
+		{"not a contract code", both, "8215924", AutoRoutes, BillingSystemUCRM},
+		{"contract code without telcong", epay.Environment{Metadata: ucrmConfig}, "8215923", AutoRoutes, BillingSystemUCRM},
+		{"nothing configured", epay.Environment{}, "8215923", AutoRoutes, BillingSystemTelcoNG},
+		{"single billing system", both, "8215923", []epay.BillingRoute{{BillingSystem: "ucrm"}}, BillingSystemUCRM},
+		{
+			"routes of environment",
+			epay.Environment{BillingURL: both.BillingURL, BillingJWTKey: testJWTKey, Metadata: ucrmConfig, BillingRoutes: []epay.BillingRoute{{BillingSystem: "ucrm", Pattern: "^U[0-9]+$"}, {BillingSystem: "telcong"}}},
+			"U123", AutoRoutes, BillingSystemUCRM,
+		},
+		{
+			"fallback of routes",
+			epay.Environment{BillingURL: both.BillingURL, BillingJWTKey: testJWTKey, BillingRoutes: []epay.BillingRoute{{BillingSystem: "ucrm", Pattern: "^U[0-9]+$"}, {BillingSystem: "telcong"}}},
+			"U123", AutoRoutes, BillingSystemTelcoNG,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Route(c.env, c.idn, c.routes)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != c.want {
+				t.Errorf("expected: %v", c.want)
+				t.Errorf("     got: %v", got)
+			}
+		})
+	}
+}
+
+func TestRouteSubscribersOfInvalidRoutes(t *testing.T) {
+	cases := [][]epay.BillingRoute{
+		{{BillingSystem: "ucrm", Pattern: "("}},
+		{{BillingSystem: "unknown"}},
+		{{BillingSystem: "ucrm", Pattern: "^U"}},
+	}
+
+	for _, routes := range cases {
+		if got, err := Route(epay.Environment{}, "1234", routes); err == nil {
+			t.Errorf("expected routes %v to fail, but got: %v", routes, got)
+		}
+	}
+}
+
+func TestCreateClientOfRegisteredBackend(t *testing.T) {
+	registerFakeBackend.Do(func() { Register("fake", fakeBackend{}) })
+
+	env := epay.Environment{EpaySecret: "s", BillingSystem: "fake", Metadata: map[string]string{"fake": "::config::"}}
+	if err := ValidateEnvironment(env); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	client, err := NewClientFactory(nil).Create(context.Background(), env, "1234")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (&fakeClient{config: "::config::"}); client.(*fakeClient).config != want.config {
+		t.Errorf("expected: %v", want)
+		t.Errorf("     got: %v", client)
+	}
+
+	env.Metadata = nil
+	if err := ValidateEnvironment(env); err == nil {
+		t.Error("expected environment without configuration to be rejected")
+	}
+}
+
+var registerFakeBackend sync.Once
+
+type fakeBackend struct{}
+
+func (fakeBackend) Configured(env epay.Environment) bool {
+	_, ok := env.Metadata["fake"]
+	return ok
+}
+
+func (fakeBackend) Config(env epay.Environment) (interface{}, error) {
+	if env.Metadata["fake"] == "" {
+		return nil, errors.New("fake config is required")
+	}
+	return env.Metadata["fake"], nil
+}
+
+func (fakeBackend) NewClient(ctx context.Context, config interface{}, opts Options) (epay.Client, error) {
+	return &fakeClient{config: config.(string)}, nil
+}
+
+type fakeClient struct {
+	epay.Client
+	config string
+}
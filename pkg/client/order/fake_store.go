@@ -0,0 +1,90 @@
+package order
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// FakeStore is an in-memory implementation of epay.PaymentOrderStore for testing.
+type FakeStore struct {
+	mu sync.Mutex
+	m  map[string]*epay.PaymentOrderRecord
+}
+
+// NewFakeStore creates a new fake store.
+func NewFakeStore() epay.PaymentOrderStore {
+	return &FakeStore{
+		m: make(map[string]*epay.PaymentOrderRecord),
+	}
+}
+
+func (s *FakeStore) Create(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.m[po.TransactionID]; ok {
+		return epay.ErrPaymentOrderAlreadyExists
+	}
+	c := *po
+	s.m[po.TransactionID] = &c
+	return nil
+}
+
+func (s *FakeStore) Put(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *po
+	s.m[po.TransactionID] = &c
+	return nil
+}
+
+func (s *FakeStore) Get(ctx context.Context, transactionID string) (*epay.PaymentOrderRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	po, ok := s.m[transactionID]
+	if !ok {
+		return nil, epay.ErrPaymentOrderNotFound
+	}
+	c := *po
+	return &c, nil
+}
+
+func (s *FakeStore) Claim(ctx context.Context, transactionID string, lease time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	po, ok := s.m[transactionID]
+	if !ok {
+		return epay.ErrPaymentOrderNotFound
+	}
+	if !po.ProcessedOn.IsZero() {
+		return epay.ErrPaymentOrderAlreadyPaid
+	}
+	now := time.Now()
+	if !po.ClaimedAt.IsZero() && po.ClaimedAt.Add(lease).After(now) {
+		return epay.ErrPaymentOrderInProgress
+	}
+	po.ClaimedAt = now
+	return nil
+}
+
+func (s *FakeStore) Release(ctx context.Context, transactionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	po, ok := s.m[transactionID]
+	if !ok {
+		return epay.ErrPaymentOrderNotFound
+	}
+	po.ClaimedAt = time.Time{}
+	return nil
+}
+
+func (s *FakeStore) Delete(ctx context.Context, transactionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if po, ok := s.m[transactionID]; ok && po.ClaimedAt.IsZero() && po.ProcessedOn.IsZero() {
+		delete(s.m, transactionID)
+	}
+	return nil
+}
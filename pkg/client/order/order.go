@@ -0,0 +1,231 @@
+// Package order provides the handling of the payment orders which is common for
+// the billing systems that are not keeping payment orders on their own. The
+// orders are kept in epay.PaymentOrderStore and only their payments are booked
+// in the billing system.
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// DefaultClaimLease is the time for which the orders are claimed while their
+// payments are booked.
+const DefaultClaimLease = 2 * time.Minute
+
+// errUncertain is the error of bookings which could be completed by the billing
+// system even if they failed, e.g when the response was not received.
+var errUncertain = errors.New("booking could be completed")
+
+// Uncertain marks the error of a booking which could be completed by the billing
+// system even if it failed, e.g when the response was not received. The claim of
+// such orders is kept, so they are recovered by Find after the claim expires
+// instead of being booked twice.
+func Uncertain(err error) error {
+	return &uncertainError{err: err}
+}
+
+type uncertainError struct {
+	err error
+}
+
+func (e *uncertainError) Error() string {
+	return e.err.Error()
+}
+
+func (e *uncertainError) Unwrap() error {
+	return e.err
+}
+
+func (e *uncertainError) Is(target error) bool {
+	return target == errUncertain
+}
+
+// Orders keeps the payment orders in the store and books their payments in the
+// billing system through Book.
+type Orders struct {
+	// Store keeps the payment orders
+	Store epay.PaymentOrderStore
+
+	// ClaimLease is the time for which the orders are claimed while their
+	// payments are booked, DefaultClaimLease is used when it's zero.
+	ClaimLease time.Duration
+
+	// Find finds the payment which was booked for the order by a previous
+	// confirmation that failed to update the order afterwards. It returns the
+	// paid amount in the currency of the order or nil when no such payment
+	// exists. The billing systems which could not find the payments are
+	// leaving it nil.
+	Find func(ctx context.Context, po *epay.PaymentOrderRecord) (*epay.Money, error)
+
+	// Book books the payment of the claimed order with its PaidAmount. The
+	// errors of bookings which could be completed anyway must be marked as
+	// Uncertain. epay.ErrPaymentOrderAlreadyPaid is returned when the billing
+	// system reports that the order was already paid.
+	Book func(ctx context.Context, po *epay.PaymentOrderRecord) error
+}
+
+// NewRecord creates the record of a new payment order for the duties of the subscriber.
+func NewRecord(createReq epay.CreatePaymentOrderRequest, duties *epay.SubscriberDuties) (*epay.PaymentOrderRecord, error) {
+	amount, err := duties.DutyAmount.Money()
+	if err != nil {
+		return nil, err
+	}
+
+	return &epay.PaymentOrderRecord{
+		CustomerName:  duties.CustomerName,
+		ClientID:      duties.CustomerRef,
+		TransactionID: createReq.TransactionID,
+		SubscriberID:  createReq.SubscriberID,
+		Amount:        amount,
+		CreatedAt:     time.Now(),
+		InvoiceIDs:    duties.DocumentIDs,
+	}, nil
+}
+
+// Create saves the new payment order with the provided items. The existing
+// orders are not overwritten and epay.ErrPaymentOrderAlreadyExists is returned.
+func (o *Orders) Create(ctx context.Context, po *epay.PaymentOrderRecord, items []epay.Item) (*epay.PaymentOrder, error) {
+	if err := o.Store.Create(ctx, po); err != nil {
+		if errors.Is(err, epay.ErrPaymentOrderAlreadyExists) {
+			return nil, err
+		}
+		log.WithContext(ctx).Printf("got error: %v", err)
+		return nil, epay.ErrUnknown
+	}
+
+	return &epay.PaymentOrder{
+		ID:            po.TransactionID,
+		CustomerName:  po.CustomerName,
+		TransactionID: po.TransactionID,
+		Amount:        po.Amount.Amount(),
+		Created:       po.CreatedAt,
+		Items:         items,
+	}, nil
+}
+
+// Delete deletes the payment order which could not be created in the billing
+// system, so it could be created again by a retry of ePay.
+func (o *Orders) Delete(ctx context.Context, orderID string) {
+	if err := o.Store.Delete(ctx, orderID); err != nil {
+		log.WithContext(ctx).Printf("could not delete payment order '%s' due: %v", orderID, err)
+	}
+}
+
+// Get gets the payment order with the provided key.
+func (o *Orders) Get(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
+	po, err := o.Store.Get(ctx, orderKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &epay.PaymentOrder{
+		ID:            po.TransactionID,
+		CustomerName:  po.CustomerName,
+		TransactionID: po.TransactionID,
+		Amount:        po.Amount.Amount(),
+		Created:       po.CreatedAt,
+	}, nil
+}
+
+// Pay books the payment of the order. The order is claimed in the store before
+// the payment is booked, so concurrent confirmations of the same transaction
+// could not book duplicate payments. The payments which were booked by previous
+// confirmations are recovered by Find and epay.ErrPaymentOrderAlreadyPaid is
+// returned for them.
+func (o *Orders) Pay(ctx context.Context, payReq epay.PayPaymentOrderRequest) (*epay.PayPaymentOrderResponse, error) {
+	contextLogger := log.WithContext(ctx)
+
+	orderID := payReq.OrderID
+	po, err := o.Store.Get(ctx, orderID)
+	if err != nil {
+		return nil, epay.ErrPaymentOrderNotFound
+	}
+
+	if !po.ProcessedOn.IsZero() {
+		return nil, epay.ErrPaymentOrderAlreadyPaid
+	}
+
+	lease := o.ClaimLease
+	if lease == 0 {
+		lease = DefaultClaimLease
+	}
+	if err := o.Store.Claim(ctx, orderID, lease); err != nil {
+		return nil, err
+	}
+
+	// The actually paid amount is booked and recorded, so mismatches
+	// accepted by the amount policy could be tracked later.
+	po.PaidAmount = po.Amount
+	if payReq.Amount.Value != "" {
+		// ePay could be paid in another currency during the euro changeover
+		paid, err := payReq.Amount.Money()
+		if err == nil {
+			paid, err = paid.Convert(po.Amount.Currency())
+		}
+		if err != nil {
+			o.release(ctx, orderID)
+			return nil, err
+		}
+		po.PaidAmount = paid
+	}
+
+	if o.Find != nil {
+		paid, err := o.Find(ctx, po)
+		if err != nil {
+			o.release(ctx, orderID)
+			return nil, fmt.Errorf("could not lookup existing payments due: %w", err)
+		}
+		if paid != nil {
+			contextLogger.Infof("payment order '%s' was already booked", orderID)
+			po.PaidAmount = *paid
+			return nil, o.processed(ctx, po, epay.ErrPaymentOrderAlreadyPaid)
+		}
+	}
+
+	if err := o.Book(ctx, po); err != nil {
+		switch {
+		case errors.Is(err, epay.ErrPaymentOrderAlreadyPaid):
+			contextLogger.Infof("payment order '%s' was already paid", orderID)
+			return nil, o.processed(ctx, po, err)
+		case !errors.Is(err, errUncertain):
+			o.release(ctx, orderID)
+		}
+		return nil, err
+	}
+
+	if err := o.processed(ctx, po, nil); err != nil {
+		return nil, err
+	}
+
+	return &epay.PayPaymentOrderResponse{
+		ID:            orderID,
+		TransactionID: po.TransactionID,
+		Amount:        po.PaidAmount.Amount(),
+		Created:       po.CreatedAt,
+		PaidOn:        po.ProcessedOn,
+	}, nil
+}
+
+// processed marks the order as processed and returns the provided error when
+// the order is saved.
+func (o *Orders) processed(ctx context.Context, po *epay.PaymentOrderRecord, result error) error {
+	po.ProcessedOn = time.Now()
+	if err := o.Store.Put(ctx, po); err != nil {
+		log.WithContext(ctx).Printf("got error: %v", err)
+		return epay.ErrUnknown
+	}
+	return result
+}
+
+func (o *Orders) release(ctx context.Context, orderID string) {
+	if err := o.Store.Release(ctx, orderID); err != nil {
+		log.WithContext(ctx).Printf("could not release payment order '%s' due: %v", orderID, err)
+	}
+}
@@ -0,0 +1,175 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+func TestCreatePaymentOrder(t *testing.T) {
+	orders := &Orders{Store: NewFakeStore()}
+
+	duties := &epay.SubscriberDuties{
+		CustomerName: "John Smith",
+		CustomerRef:  "708",
+		DutyAmount:   epay.Amount{Value: "20.00", Currency: "BGN"},
+		DocumentIDs:  []string{"101"},
+		Items:        []epay.Item{{Name: "Internet"}},
+	}
+	po, err := NewRecord(epay.CreatePaymentOrderRequest{SubscriberID: "123", TransactionID: "TID1"}, duties)
+	if err != nil {
+		t.Fatalf("unable to create record due: %v", err)
+	}
+
+	got, err := orders.Create(context.Background(), po, duties.Items)
+	if err != nil {
+		t.Fatalf("unable to create payment order due: %v", err)
+	}
+	if got.ID != "TID1" || got.Amount != duties.DutyAmount || len(got.Items) != 1 {
+		t.Errorf("unexpected payment order: %+v", got)
+	}
+
+	again := *po
+	again.Amount = epay.NewMoney(100, "BGN")
+	if _, err := orders.Create(context.Background(), &again, nil); !errors.Is(err, epay.ErrPaymentOrderAlreadyExists) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyExists)
+		t.Errorf("     got: %v", err)
+	}
+
+	saved, _ := orders.Get(context.Background(), "TID1")
+	if saved.Amount != duties.DutyAmount {
+		t.Errorf("expected existing order to be kept, but got: %+v", saved)
+	}
+}
+
+func TestPayBooksPaidAmount(t *testing.T) {
+	var booked epay.Money
+	store := NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(3912, "BGN")})
+
+	orders := &Orders{Store: store, Book: func(ctx context.Context, po *epay.PaymentOrderRecord) error {
+		booked = po.PaidAmount
+		return nil
+	}}
+
+	// paid in euro during the changeover
+	resp, err := orders.Pay(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1", Amount: epay.Amount{Value: "20.00", Currency: "EUR"}})
+	if err != nil {
+		t.Fatalf("unable to pay payment order due: %v", err)
+	}
+
+	if want := epay.NewMoney(3912, "BGN"); booked != want || resp.Amount != want.Amount() {
+		t.Errorf("expected booked amount: %v", want)
+		t.Errorf("                   got: %v (%v)", booked, resp.Amount)
+	}
+	po, _ := store.Get(context.Background(), "TID1")
+	if po.ProcessedOn.IsZero() || po.PaidAmount != booked {
+		t.Errorf("expected payment order to be processed, but got: %+v", po)
+	}
+}
+
+func TestPayAlreadyProcessedPaymentOrder(t *testing.T) {
+	store := NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(2000, "BGN"), ProcessedOn: time.Now()})
+
+	orders := &Orders{Store: store, Book: func(ctx context.Context, po *epay.PaymentOrderRecord) error {
+		t.Error("payment should not be booked twice")
+		return nil
+	}}
+	if _, err := orders.Pay(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyPaid)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestPayPaymentOrderWhichIsBeingPaid(t *testing.T) {
+	store := NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(2000, "BGN")})
+	store.Claim(context.Background(), "TID1", time.Minute)
+
+	orders := &Orders{Store: store, Book: func(ctx context.Context, po *epay.PaymentOrderRecord) error {
+		t.Error("payment should not be booked while it's claimed")
+		return nil
+	}}
+	if _, err := orders.Pay(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, epay.ErrPaymentOrderInProgress) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderInProgress)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestPayRecoversBookedPayment(t *testing.T) {
+	store := NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(2000, "BGN")})
+
+	orders := &Orders{
+		Store: store,
+		Find: func(ctx context.Context, po *epay.PaymentOrderRecord) (*epay.Money, error) {
+			paid := epay.NewMoney(1500, "BGN")
+			return &paid, nil
+		},
+		Book: func(ctx context.Context, po *epay.PaymentOrderRecord) error {
+			t.Error("payment should not be booked twice")
+			return nil
+		},
+	}
+	if _, err := orders.Pay(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyPaid)
+		t.Errorf("     got: %v", err)
+	}
+
+	po, _ := store.Get(context.Background(), "TID1")
+	if po.ProcessedOn.IsZero() || po.PaidAmount != epay.NewMoney(1500, "BGN") {
+		t.Errorf("expected recovered payment order to be processed, but got: %+v", po)
+	}
+}
+
+func TestPayWhenBillingReportsAlreadyPaid(t *testing.T) {
+	store := NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(2000, "BGN")})
+
+	orders := &Orders{Store: store, Book: func(ctx context.Context, po *epay.PaymentOrderRecord) error {
+		return epay.ErrPaymentOrderAlreadyPaid
+	}}
+	if _, err := orders.Pay(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyPaid)
+		t.Errorf("     got: %v", err)
+	}
+
+	po, _ := store.Get(context.Background(), "TID1")
+	if po.ProcessedOn.IsZero() {
+		t.Error("expected paid payment order to be processed")
+	}
+}
+
+func TestPayReleasesOnlyFailedBookings(t *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		claimed bool
+	}{
+		{"rejected", epay.ErrBillingRejected, false},
+		{"invalid amount", epay.ErrCurrencyMismatch, false},
+		{"uncertain", Uncertain(errors.New("connection reset")), true},
+	}
+
+	for _, c := range cases {
+		store := NewFakeStore()
+		store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(2000, "BGN")})
+
+		orders := &Orders{Store: store, Book: func(ctx context.Context, po *epay.PaymentOrderRecord) error {
+			return c.err
+		}}
+		if _, err := orders.Pay(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, c.err) {
+			t.Errorf("%s: expected: %v", c.name, c.err)
+			t.Errorf("%s:      got: %v", c.name, err)
+		}
+
+		po, _ := store.Get(context.Background(), "TID1")
+		if claimed := !po.ClaimedAt.IsZero(); claimed != c.claimed || !po.ProcessedOn.IsZero() {
+			t.Errorf("%s: expected order to be claimed: %v, but got: %+v", c.name, c.claimed, po)
+		}
+	}
+}
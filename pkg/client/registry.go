@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/clouway/go-epay/pkg/client/token"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Backend is a billing system which could serve the requests of the environments.
+type Backend interface {
+	// Configured reports whether the environment has configuration of the backend.
+	Configured(env epay.Environment) bool
+
+	// Config parses and validates the typed configuration of the backend
+	// which is kept in the environment.
+	Config(env epay.Environment) (interface{}, error)
+
+	// NewClient creates a new client by using the configuration returned by Config.
+	NewClient(ctx context.Context, config interface{}, opts Options) (epay.Client, error)
+}
+
+// Options are the dependencies provided to the backends on client creation.
+type Options struct {
+	// PaymentOrderStore keeps the payment orders of the backends which
+	// are not tracking them in the billing system
+	PaymentOrderStore epay.PaymentOrderStore
+
+	// HTTPClient is the client for the requests to the billing system. It's
+	// configured by the timeouts, retries and circuit breaking of the environment.
+	HTTPClient *http.Client
+
+	// TokenCache caches the OAuth2 tokens of the backends which are
+	// authenticated by them
+	TokenCache *token.Cache
+}
+
+var (
+	backendsMu sync.RWMutex
+	backends   = make(map[BillingSystem]Backend)
+)
+
+// Register makes a billing backend available by the provided name. If Register
+// is called twice with the same name or if backend is nil, it panics.
+func Register(name BillingSystem, backend Backend) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if backend == nil {
+		panic("client: Register backend is nil")
+	}
+	if _, dup := backends[name]; dup {
+		panic("client: Register called twice for backend " + name)
+	}
+	backends[name] = backend
+}
+
+// Backends returns the sorted names of the registered billing backends.
+func Backends() []BillingSystem {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := make([]BillingSystem, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+func lookupBackend(name BillingSystem) (Backend, error) {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	backend, ok := backends[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown billing system '%s'", name)
+	}
+	return backend, nil
+}
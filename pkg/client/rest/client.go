@@ -0,0 +1,284 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/client/order"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// backend is the name of the billing system in the errors.
+const backend = "rest"
+
+// NewClient creates a new client which calls the configured endpoints of the
+// billing system. The payment orders are kept in the provided store.
+func NewClient(conf Config, poStore epay.PaymentOrderStore) epay.Client {
+	c := &client{conf: conf}
+	c.orders = &order.Orders{Store: poStore, Book: c.book}
+	return c
+}
+
+type client struct {
+	conf   Config
+	orders *order.Orders
+}
+
+// GetSubscriberDuties gets the duties of the subscriber from the Duties
+// endpoint and maps them by the configured mapping.
+func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (*epay.SubscriberDuties, error) {
+	var body interface{}
+	resp, err := c.call(ctx, c.conf.Duties, http.MethodGet, map[string]string{"IDN": subscriberID}, &body)
+	if err != nil {
+		return nil, fmt.Errorf("could not process get subscriber duties request due: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, epay.ErrSubscriberNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, epay.ResponseError(backend, resp)
+	}
+
+	duties, err := c.mapDuties(body)
+	if err != nil {
+		return nil, fmt.Errorf("could not map duties of subscriber %s due: %w", subscriberID, err)
+	}
+	if duties.CustomerRef == "" {
+		duties.CustomerRef = subscriberID
+	}
+	return duties, nil
+}
+
+func (c *client) mapDuties(body interface{}) (*epay.SubscriberDuties, error) {
+	m := c.conf.Mapping
+
+	currency := lookupString(body, m.Currency)
+	if currency == "" {
+		currency = c.conf.Currency
+	}
+	amount, err := epay.ParseMoney(lookupString(body, m.Amount), currency)
+	if err != nil {
+		return nil, err
+	}
+	dueDate, err := lookupTime(body, m.DueDate)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]epay.Item, 0)
+	value, _ := lookup(body, m.Items)
+	list, _ := value.([]interface{})
+	for _, v := range list {
+		item, err := c.mapItem(v, currency)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return &epay.SubscriberDuties{
+		CustomerName: lookupString(body, m.CustomerName),
+		CustomerRef:  lookupString(body, m.CustomerRef),
+		DutyAmount:   amount.Amount(),
+		DueDate:      dueDate,
+		DocumentIDs:  lookupStrings(body, m.DocumentIDs),
+		Items:        items,
+	}, nil
+}
+
+// mapItem maps a single item of the duties. Items without amount are listed
+// with zero amount and items without quantity are listed as a single one.
+func (c *client) mapItem(v interface{}, currency string) (epay.Item, error) {
+	m := c.conf.Mapping.Item
+
+	amount, err := lookupMoney(v, m.Amount, currency)
+	if err != nil {
+		return epay.Item{}, err
+	}
+	price, err := lookupMoney(v, m.Price, currency)
+	if err != nil {
+		return epay.Item{}, err
+	}
+	quantity, err := lookupFloat(v, m.Quantity)
+	if err != nil {
+		return epay.Item{}, err
+	}
+	if quantity == 0 {
+		quantity = 1
+	}
+	vat, err := lookupFloat(v, m.Vat)
+	if err != nil {
+		return epay.Item{}, err
+	}
+	startDate, err := lookupTime(v, m.StartDate)
+	if err != nil {
+		return epay.Item{}, err
+	}
+	endDate, err := lookupTime(v, m.EndDate)
+	if err != nil {
+		return epay.Item{}, err
+	}
+
+	return epay.Item{
+		Name:      lookupString(v, m.Name),
+		StartDate: startDate,
+		EndDate:   endDate,
+		Amount:    amount.Amount(),
+		Vat:       vat,
+		Price:     price.String(),
+		Quantity:  int(math.Round(quantity)),
+	}, nil
+}
+
+func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePaymentOrderRequest) (*epay.PaymentOrder, error) {
+	duties, err := c.GetSubscriberDuties(ctx, createReq.SubscriberID)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := order.NewRecord(createReq, duties)
+	if err != nil {
+		return nil, err
+	}
+
+	// The order is created in the store first, so the CreateOrder endpoint
+	// is not called again for duplicated transactions.
+	created, err := c.orders.Create(ctx, po, duties.Items)
+	if err != nil {
+		return nil, err
+	}
+	if c.conf.CreateOrder != nil {
+		if err := c.createOrder(ctx, po); err != nil {
+			c.orders.Delete(ctx, po.TransactionID)
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// createOrder creates the order by the CreateOrder endpoint. The endpoint
+// responds with 409 Conflict to orders of transactions which it created
+// already, e.g when the order could not be saved after a previous request,
+// so they are accepted.
+func (c *client) createOrder(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	resp, err := c.call(ctx, *c.conf.CreateOrder, http.MethodPost, values(po, po.Amount), nil)
+	if err != nil {
+		return fmt.Errorf("could not process create order request due: %w", err)
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusConflict {
+		return epay.ResponseError(backend, resp)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
+	return c.orders.Get(ctx, orderKey)
+}
+
+// PayPaymentOrder records the payment of the order by the PayOrder endpoint.
+// The PayOrder endpoint responds with 409 Conflict to orders which were
+// already paid by previous confirmations.
+func (c *client) PayPaymentOrder(ctx context.Context, payReq epay.PayPaymentOrderRequest) (*epay.PayPaymentOrderResponse, error) {
+	return c.orders.Pay(ctx, payReq)
+}
+
+// book records the payment of the claimed order by the PayOrder endpoint.
+func (c *client) book(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	resp, err := c.call(ctx, c.conf.PayOrder, http.MethodPost, values(po, po.PaidAmount), nil)
+	if err != nil {
+		return order.Uncertain(fmt.Errorf("could not process payment request due: %w", err))
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		return epay.ErrPaymentOrderAlreadyPaid
+	}
+	if resp.StatusCode >= 300 {
+		return epay.ResponseError(backend, resp)
+	}
+	return nil
+}
+
+// values returns the values of the placeholders of the templates for the
+// provided payment order:
+//
+//	{IDN}      - the subscriber ID which was used for payment
+//	{TID}      - the transaction ID of ePay
+//	{CLIENT}   - the customer reference returned by the billing system
+//	{AMOUNT}   - the amount of the order or the paid amount
+//	{CURRENCY} - the currency of the amount
+//	{DATE}     - the time of the request in RFC 3339 format
+//	{INVOICES} - the comma separated document IDs of the order
+func values(po *epay.PaymentOrderRecord, amount epay.Money) map[string]string {
+	return map[string]string{
+		"IDN":      po.SubscriberID,
+		"TID":      po.TransactionID,
+		"CLIENT":   po.ClientID,
+		"AMOUNT":   amount.String(),
+		"CURRENCY": amount.Currency(),
+		"DATE":     time.Now().UTC().Format(time.RFC3339),
+		"INVOICES": strings.Join(po.InvoiceIDs, ","),
+	}
+}
+
+// call calls the endpoint and decodes the JSON response into v when the request
+// was successful. The method is used when the endpoint is not having own. The
+// body of unsuccessful responses is left open, so the error could be read.
+func (c *client) call(ctx context.Context, e Endpoint, method string, vals map[string]string, v interface{}) (*http.Response, error) {
+	if e.Method != "" {
+		method = e.Method
+	}
+
+	ref, err := url.Parse(expand(e.Path, vals, escapeURL))
+	if err != nil {
+		return nil, fmt.Errorf("could not create request due: %v", err)
+	}
+	u := c.conf.BaseURL.ResolveReference(ref)
+
+	var body io.Reader
+	if e.Body != "" {
+		body = strings.NewReader(expand(e.Body, vals, escapeJSON))
+	}
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request due: %v", err)
+	}
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.conf.AuthHeader != "" {
+		req.Header.Set(c.conf.AuthHeader, c.conf.AuthValue)
+	}
+
+	httpClient := c.conf.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, epay.RequestError(backend, err)
+	}
+	if resp.StatusCode >= 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if v == nil {
+		return resp, nil
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	return resp, dec.Decode(v)
+}
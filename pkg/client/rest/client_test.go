@@ -0,0 +1,302 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/client/order"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+const testEndpoints = `{
+	"duties": {"path": "/api/subscribers/{IDN}/duties"},
+	"createOrder": {"path": "/api/orders", "body": "{\"tid\": \"{TID}\", \"idn\": \"{IDN}\", \"amount\": {AMOUNT}}"},
+	"payOrder": {"method": "PUT", "path": "/api/orders/{TID}/payment", "body": "{\"amount\": {AMOUNT}, \"currency\": \"{CURRENCY}\", \"invoices\": \"{INVOICES}\"}"},
+	"mapping": {
+		"customerName": "customer.name",
+		"customerRef": "customer.id",
+		"amount": "balance.due",
+		"currency": "balance.currency",
+		"dueDate": "balance.dueDate",
+		"documentIds": "invoices",
+		"items": "lines",
+		"item": {"name": "title", "amount": "total", "price": "unitPrice", "quantity": "qty", "vat": "vat", "startDate": "from", "endDate": "to"}
+	}
+}`
+
+func TestSubscriberDuties(t *testing.T) {
+	var gotPath, gotAuth string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/subscribers/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth = r.URL.EscapedPath(), r.Header.Get("X-Api-Key")
+		w.Write([]byte(`{
+			"customer": {"id": 708, "name": "John Smith"},
+			"balance": {"due": "20.00", "currency": "EUR", "dueDate": "2020-04-15"},
+			"invoices": [101, "102"],
+			"lines": [
+				{"title": "Internet 04/2020", "total": 20, "unitPrice": "10.00", "qty": 2, "vat": 20, "from": "2020-04-01", "to": "2020-04-30T00:00:00Z"}
+			]
+		}`))
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := NewClient(testConfig(t, ts.URL), nil)
+	got, err := client.GetSubscriberDuties(context.Background(), "john/1")
+	if err != nil {
+		t.Fatalf("unable to get subscriber duties due: %v", err)
+	}
+
+	want := &epay.SubscriberDuties{
+		CustomerName: "John Smith",
+		CustomerRef:  "708",
+		DutyAmount:   epay.Amount{Value: "20.00", Currency: "EUR"},
+		DueDate:      time.Date(2020, 4, 15, 0, 0, 0, 0, time.UTC),
+		DocumentIDs:  []string{"101", "102"},
+		Items: []epay.Item{
+			{
+				Name:      "Internet 04/2020",
+				StartDate: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2020, 4, 30, 0, 0, 0, 0, time.UTC),
+				Amount:    epay.Amount{Value: "20.00", Currency: "EUR"},
+				Vat:       20,
+				Price:     "10.00",
+				Quantity:  2,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected: %+v", want)
+		t.Errorf("     got: %+v", got)
+	}
+	if gotPath != "/api/subscribers/john%2F1/duties" || gotAuth != "::key::" {
+		t.Errorf("unexpected request to %s with key: %s", gotPath, gotAuth)
+	}
+}
+
+func TestSubscriberNotFound(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	client := NewClient(testConfig(t, ts.URL), nil)
+	if _, err := client.GetSubscriberDuties(context.Background(), "john"); !errors.Is(err, epay.ErrSubscriberNotFound) {
+		t.Errorf("expected: %v", epay.ErrSubscriberNotFound)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestCreateAndPayPaymentOrder(t *testing.T) {
+	var created, paid map[string]interface{}
+	var payMethod string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/subscribers/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"customer": {"id": 708, "name": "John \"Johnny\" Smith"}, "balance": {"due": 20}, "invoices": [101, 102]}`))
+	}))
+	mux.HandleFunc("/api/orders", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decode(t, r, &created)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	mux.HandleFunc("/api/orders/TID1/payment", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payMethod = r.Method
+		decode(t, r, &paid)
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	store := order.NewFakeStore()
+	client := NewClient(testConfig(t, ts.URL), store)
+
+	po, err := client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: `jo"hn`, TransactionID: "TID1"})
+	if err != nil {
+		t.Fatalf("unable to create payment order due: %v", err)
+	}
+	if po.Amount != (epay.Amount{Value: "20.00", Currency: "BGN"}) {
+		t.Errorf("unexpected payment order: %+v", po)
+	}
+	if want := map[string]interface{}{"tid": "TID1", "idn": `jo"hn`, "amount": 20.0}; !reflect.DeepEqual(created, want) {
+		t.Errorf("expected: %v", want)
+		t.Errorf("     got: %v", created)
+	}
+
+	resp, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1", Amount: epay.Amount{Value: "15.50", Currency: "BGN"}})
+	if err != nil {
+		t.Fatalf("unable to pay payment order due: %v", err)
+	}
+	if resp.Amount.Value != "15.50" {
+		t.Errorf("expected paid amount to be 15.50, but got: %s", resp.Amount.Value)
+	}
+	if want := map[string]interface{}{"amount": 15.5, "currency": "BGN", "invoices": "101,102"}; payMethod != "PUT" || !reflect.DeepEqual(paid, want) {
+		t.Errorf("expected: PUT %v", want)
+		t.Errorf("     got: %s %v", payMethod, paid)
+	}
+
+	record, _ := store.Get(context.Background(), "TID1")
+	if record.ProcessedOn.IsZero() || record.ClientID != "708" || record.PaidAmount != epay.NewMoney(1550, "BGN") {
+		t.Errorf("unexpected payment order: %+v", record)
+	}
+}
+
+func TestCreatePaymentOrderOfDuplicatedTransaction(t *testing.T) {
+	createCalls := 0
+	createStatus := http.StatusInternalServerError
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/subscribers/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"customer": {"id": 708, "name": "John Smith"}, "balance": {"due": 20}}`))
+	}))
+	mux.HandleFunc("/api/orders", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		createCalls++
+		w.WriteHeader(createStatus)
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	store := order.NewFakeStore()
+	client := NewClient(testConfig(t, ts.URL), store)
+	createReq := epay.CreatePaymentOrderRequest{SubscriberID: "john", TransactionID: "TID1"}
+
+	// the order which could not be created is created by the retry
+	if _, err := client.CreatePaymentOrder(context.Background(), createReq); err == nil {
+		t.Fatal("expected failed order to not be created")
+	}
+	if _, err := store.Get(context.Background(), "TID1"); err == nil {
+		t.Error("expected failed order to be deleted")
+	}
+
+	createStatus = http.StatusConflict
+	if _, err := client.CreatePaymentOrder(context.Background(), createReq); err != nil {
+		t.Fatalf("unable to create payment order due: %v", err)
+	}
+
+	if _, err := client.CreatePaymentOrder(context.Background(), createReq); !errors.Is(err, epay.ErrPaymentOrderAlreadyExists) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyExists)
+		t.Errorf("     got: %v", err)
+	}
+	if createCalls != 2 {
+		t.Errorf("expected create endpoint to be called 2 times, but was called %d times", createCalls)
+	}
+}
+
+func TestPayAlreadyPaidPaymentOrder(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+	}))
+	defer ts.Close()
+
+	store := order.NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(2000, "BGN")})
+
+	client := NewClient(testConfig(t, ts.URL), store)
+	if _, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyPaid)
+		t.Errorf("     got: %v", err)
+	}
+
+	record, _ := store.Get(context.Background(), "TID1")
+	if record.ProcessedOn.IsZero() {
+		t.Errorf("expected payment order to be processed, but got: %+v", record)
+	}
+}
+
+func TestPayPaymentOrderReleasesFailedPayment(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	store := order.NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", Amount: epay.NewMoney(2000, "BGN")})
+
+	client := NewClient(testConfig(t, ts.URL), store)
+	if _, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, epay.ErrBillingUnavailable) {
+		t.Errorf("expected: %v", epay.ErrBillingUnavailable)
+		t.Errorf("     got: %v", err)
+	}
+
+	record, _ := store.Get(context.Background(), "TID1")
+	if !record.ProcessedOn.IsZero() || !record.ClaimedAt.IsZero() {
+		t.Errorf("expected payment order to be released, but got: %+v", record)
+	}
+}
+
+func TestConfigFromEnvironment(t *testing.T) {
+	env := epay.Environment{Metadata: map[string]string{
+		"restUrl":       "https://billing.example.com",
+		"restAuthValue": "Bearer ::token::",
+		"restConfig":    testEndpoints,
+	}}
+	conf, err := ConfigFromEnvironment(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.AuthHeader != "Authorization" || conf.Currency != "BGN" || conf.PayOrder.Method != "PUT" || conf.Mapping.Item.Name != "title" {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+
+	invalid := []map[string]string{
+		{"restUrl": "billing", "restConfig": testEndpoints},
+		{"restUrl": "https://billing.example.com"},
+		{"restUrl": "https://billing.example.com", "restConfig": `{"duties": {"path": "/duties"}, "payOrder": {"path": "/pay"}}`},
+		{"restUrl": "https://billing.example.com", "restConfig": `{"duties": {"path": "/duties", "method": "DELETE"}, "payOrder": {"path": "/pay"}, "mapping": {"amount": "due"}}`},
+	}
+	for _, m := range invalid {
+		if _, err := ConfigFromEnvironment(epay.Environment{Metadata: m}); err == nil {
+			t.Errorf("expected config %v to be rejected", m)
+		}
+	}
+}
+
+func TestMapItemAmountsWithoutFloats(t *testing.T) {
+	c := &client{conf: testConfig(t, "https://billing.example.com")}
+
+	var line interface{}
+	dec := json.NewDecoder(strings.NewReader(`{"title": "TV", "total": 0.3, "unitPrice": 0.1, "qty": 3}`))
+	dec.UseNumber()
+	if err := dec.Decode(&line); err != nil {
+		t.Fatalf("invalid line: %v", err)
+	}
+
+	item, err := c.mapItem(line, "BGN")
+	if err != nil {
+		t.Fatalf("unable to map item due: %v", err)
+	}
+	if item.Amount != (epay.Amount{Value: "0.30", Currency: "BGN"}) || item.Price != "0.10" {
+		t.Errorf("unexpected amounts of item: %+v", item)
+	}
+
+	for _, line := range []interface{}{
+		map[string]interface{}{"title": "TV", "total": "abc"},
+		map[string]interface{}{"title": "TV", "unitPrice": json.Number("0.105")},
+	} {
+		if _, err := c.mapItem(line, "BGN"); !errors.Is(err, epay.ErrInvalidAmount) {
+			t.Errorf("expected invalid amount of %v, but got: %v", line, err)
+		}
+	}
+}
+
+func testConfig(t *testing.T, baseURL string) Config {
+	u, _ := url.Parse(baseURL)
+	conf := Config{BaseURL: u, AuthHeader: "X-Api-Key", AuthValue: "::key::", Currency: "BGN"}
+	if err := json.Unmarshal([]byte(testEndpoints), &conf.Endpoints); err != nil {
+		t.Fatalf("invalid endpoints: %v", err)
+	}
+	return conf
+}
+
+func decode(t *testing.T, r *http.Request, v interface{}) {
+	b, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Errorf("unable to decode request '%s' due: %v", b, err)
+	}
+}
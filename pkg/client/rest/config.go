@@ -0,0 +1,156 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Config is the configuration of the client for billing systems which are
+// integrated by their HTTP endpoints.
+type Config struct {
+	// BaseURL is the base URL of the endpoints
+	BaseURL *url.URL
+
+	// AuthHeader and AuthValue are the header which is sent on every request
+	// for authentication, e.g Authorization: Bearer ...
+	AuthHeader string
+	AuthValue  string
+
+	// Currency is the currency of the amounts returned by the billing
+	// system. BGN is used when it's not set.
+	Currency string
+
+	// HTTPClient is the client used for the requests to the billing system.
+	// http.DefaultClient is used when it's not set.
+	HTTPClient *http.Client
+
+	Endpoints
+}
+
+// Endpoints are the endpoints of the billing system and the mapping of their
+// responses. Paths and bodies are templates which support the {IDN}, {TID},
+// {CLIENT}, {AMOUNT}, {CURRENCY}, {DATE} and {INVOICES} placeholders.
+type Endpoints struct {
+	// Duties is the endpoint which returns the duties of the subscriber. It
+	// responds with 404 when the subscriber is not found.
+	Duties Endpoint `json:"duties"`
+
+	// CreateOrder is an optional endpoint which is notified when a payment
+	// order is created. It's called once for a transaction and it responds
+	// with 409 when the order of the {TID} was already created.
+	CreateOrder *Endpoint `json:"createOrder,omitempty"`
+
+	// PayOrder is the endpoint which records the payment of an order. It
+	// responds with 409 when the order was already paid. The endpoint should
+	// be idempotent by {TID} as it could be called again for the same order.
+	PayOrder Endpoint `json:"payOrder"`
+
+	// Mapping maps the response of the Duties endpoint.
+	Mapping Mapping `json:"mapping"`
+}
+
+// Endpoint is a HTTP endpoint of the billing system.
+type Endpoint struct {
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Mapping contains the paths to the values of the duties response. Paths are
+// dot separated names of the fields and indexes of arrays, e.g customer.name.
+type Mapping struct {
+	CustomerName string `json:"customerName,omitempty"`
+	CustomerRef  string `json:"customerRef,omitempty"`
+	Amount       string `json:"amount"`
+	Currency     string `json:"currency,omitempty"`
+	DueDate      string `json:"dueDate,omitempty"`
+	DocumentIDs  string `json:"documentIds,omitempty"`
+
+	// Items is the path to the array of the items, which values are mapped
+	// by the paths of Item relatively to every item
+	Items string      `json:"items,omitempty"`
+	Item  ItemMapping `json:"item,omitempty"`
+}
+
+// ItemMapping contains the paths to the values of a single item.
+type ItemMapping struct {
+	Name      string `json:"name,omitempty"`
+	Amount    string `json:"amount,omitempty"`
+	Price     string `json:"price,omitempty"`
+	Quantity  string `json:"quantity,omitempty"`
+	Vat       string `json:"vat,omitempty"`
+	StartDate string `json:"startDate,omitempty"`
+	EndDate   string `json:"endDate,omitempty"`
+}
+
+// ConfigFromEnvironment parses and validates the configuration kept in the
+// metadata of the provided environment. The endpoints are kept as JSON.
+func ConfigFromEnvironment(env epay.Environment) (*Config, error) {
+	m := env.Metadata
+	if m["restUrl"] == "" {
+		return nil, fmt.Errorf("restUrl is required")
+	}
+	baseURL, err := url.Parse(m["restUrl"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid restUrl: %v", err)
+	}
+
+	c := &Config{
+		BaseURL:    baseURL,
+		AuthHeader: m["restAuthHeader"],
+		AuthValue:  m["restAuthValue"],
+		Currency:   strings.ToUpper(m["restCurrency"]),
+	}
+	if c.AuthHeader == "" && c.AuthValue != "" {
+		c.AuthHeader = "Authorization"
+	}
+	if c.Currency == "" {
+		c.Currency = epay.CurrencyBGN
+	}
+	if m["restConfig"] == "" {
+		return nil, fmt.Errorf("restConfig is required")
+	}
+	if err := json.Unmarshal([]byte(m["restConfig"]), &c.Endpoints); err != nil {
+		return nil, fmt.Errorf("invalid restConfig: %v", err)
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.BaseURL == nil || !c.BaseURL.IsAbs() || c.BaseURL.Host == "" {
+		return fmt.Errorf("restUrl must be an absolute URL")
+	}
+	if len(c.Currency) != 3 {
+		return fmt.Errorf("invalid restCurrency '%s'", c.Currency)
+	}
+
+	endpoints := map[string]*Endpoint{"duties": &c.Duties, "payOrder": &c.PayOrder, "createOrder": c.CreateOrder}
+	for name, e := range endpoints {
+		if e == nil {
+			continue
+		}
+		if e.Path == "" {
+			return fmt.Errorf("path of %s endpoint is required", name)
+		}
+		switch e.Method {
+		case "", http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch:
+		default:
+			return fmt.Errorf("unsupported method '%s' of %s endpoint", e.Method, name)
+		}
+	}
+
+	if c.Mapping.Amount == "" {
+		return fmt.Errorf("mapping of amount is required")
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// dateLayouts are the accepted layouts of the dates in the responses.
+var dateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05-0700", "2006-01-02"}
+
+// lookup returns the value at the provided path of the decoded JSON value.
+func lookup(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
+
+// lookupString returns the value at the provided path as string. Numbers
+// are returned as they were sent.
+func lookupString(v interface{}, path string) string {
+	value, ok := lookup(v, path)
+	if !ok {
+		return ""
+	}
+	switch s := value.(type) {
+	case string:
+		return s
+	case json.Number:
+		return s.String()
+	case bool:
+		return strconv.FormatBool(s)
+	}
+	return ""
+}
+
+// lookupFloat returns the number at the provided path. Numbers sent as
+// strings are accepted too.
+func lookupFloat(v interface{}, path string) (float64, error) {
+	s := lookupString(v, path)
+	if s == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number '%s' at '%s'", s, path)
+	}
+	return f, nil
+}
+
+// lookupMoney returns the amount at the provided path in the currency. The
+// decimal value is parsed as it was sent, so the amounts are not passing
+// through floats. Missing amounts are returned as zero.
+func lookupMoney(v interface{}, path, currency string) (epay.Money, error) {
+	s := lookupString(v, path)
+	if s == "" {
+		return epay.NewMoney(0, currency), nil
+	}
+	m, err := epay.ParseMoney(s, currency)
+	if err != nil {
+		return epay.Money{}, fmt.Errorf("invalid amount at '%s': %w", path, err)
+	}
+	return m, nil
+}
+
+// lookupTime returns the date at the provided path.
+func lookupTime(v interface{}, path string) (time.Time, error) {
+	s := lookupString(v, path)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date '%s' at '%s'", s, path)
+}
+
+// lookupStrings returns the values of the array at the provided path.
+func lookupStrings(v interface{}, path string) []string {
+	value, _ := lookup(v, path)
+	list, _ := value.([]interface{})
+
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		if s := lookupString(map[string]interface{}{"v": item}, "v"); s != "" {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// expand expands the placeholders of the template with the provided values.
+// Values are escaped by the escape function, so they could not change the
+// structure of the path or the body.
+func expand(tmpl string, values map[string]string, escape func(string) string) string {
+	if tmpl == "" {
+		return ""
+	}
+
+	oldnew := make([]string, 0, len(values)*2)
+	for k, v := range values {
+		oldnew = append(oldnew, "{"+k+"}", escape(v))
+	}
+	return strings.NewReplacer(oldnew...).Replace(tmpl)
+}
+
+// escapeJSON escapes the value to be placed within a JSON string.
+func escapeJSON(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// escapeURL escapes the value to be placed within a path segment or
+// a query parameter.
+func escapeURL(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
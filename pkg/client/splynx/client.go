@@ -0,0 +1,440 @@
+package splynx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/client/order"
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/number"
+)
+
+const (
+	invoicesPageSize = 100
+
+	// invoiceStatusNotPaid is the status of the invoices which are not paid
+	invoiceStatusNotPaid = "not_paid"
+
+	// backend is the name of the billing system in the errors
+	backend = "splynx"
+)
+
+// NewClient creates a new client of Splynx which keeps the payment orders
+// in the provided store.
+func NewClient(conf Config, poStore epay.PaymentOrderStore) epay.Client {
+	c := &client{conf: conf}
+	c.orders = &order.Orders{Store: poStore, Find: c.findPaidAmount, Book: c.book}
+	return c
+}
+
+type client struct {
+	conf   Config
+	orders *order.Orders
+}
+
+// GetSubscriberDuties gets the unpaid invoices of the customer.
+func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (*epay.SubscriberDuties, error) {
+	cust, err := c.findCustomer(ctx, subscriberID)
+	if err != nil {
+		return nil, err
+	}
+
+	customerID := strconv.Itoa(cust.ID)
+	invoices, err := c.getUnpaidInvoices(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	dutyAmount := epay.NewMoney(0, c.conf.Currency)
+	var dueDate time.Time
+	documentIDs := make([]string, 0)
+	items := make([]epay.Item, 0)
+	for _, inv := range invoices {
+		unpaid, err := inv.unpaidAmount(c.conf.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("could not get amount of invoice %d due: %w", inv.ID, err)
+		}
+		if dutyAmount, err = dutyAmount.Add(unpaid); err != nil {
+			return nil, fmt.Errorf("could not sum invoices of customer %s due: %w", customerID, err)
+		}
+		documentIDs = append(documentIDs, strconv.Itoa(inv.ID))
+		// the earliest due date is the one which has to be met
+		if !inv.DateTill.IsZero() && (dueDate.IsZero() || inv.DateTill.Before(dueDate)) {
+			dueDate = inv.DateTill.Time
+		}
+
+		for _, item := range inv.Items {
+			item, err := newItem(inv, item, c.conf.Currency)
+			if err != nil {
+				return nil, fmt.Errorf("could not get item of invoice %d due: %w", inv.ID, err)
+			}
+			items = append(items, item)
+		}
+	}
+
+	return &epay.SubscriberDuties{
+		CustomerName: cust.Name,
+		CustomerRef:  customerID,
+		DutyAmount:   dutyAmount.Amount(),
+		DueDate:      dueDate,
+		DocumentIDs:  documentIDs,
+		Items:        items,
+	}, nil
+}
+
+// findCustomer finds the customer by login. Numeric IDNs which are not used as
+// login are looked up as ID of the customer, which is used as contract number.
+func (c *client) findCustomer(ctx context.Context, subscriberID string) (*customer, error) {
+	contextLogger := log.WithContext(ctx)
+
+	params := url.Values{}
+	params.Add("main_attributes[login]", subscriberID)
+	req, err := c.newRequest(ctx, "GET", "/api/2.0/admin/customers/customer", params)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request due: %v", err)
+	}
+
+	var customers []customer
+	resp, err := c.do(req, &customers)
+	if err != nil {
+		return nil, fmt.Errorf("could not process customer search request due: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, epay.ResponseError(backend, resp)
+	}
+	if len(customers) > 0 {
+		return &customers[0], nil
+	}
+
+	id, err := strconv.Atoi(subscriberID)
+	if err != nil || id <= 0 {
+		return nil, epay.ErrSubscriberNotFound
+	}
+	contextLogger.Debugf("searching by customer ID: %d", id)
+
+	req, err = c.newRequest(ctx, "GET", "/api/2.0/admin/customers/customer/"+strconv.Itoa(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create request due: %v", err)
+	}
+	cust := &customer{}
+	resp, err = c.do(req, cust)
+	if err != nil {
+		return nil, fmt.Errorf("could not process get customer request due: %w", err)
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, epay.ErrSubscriberNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, epay.ResponseError(backend, resp)
+	}
+	return cust, nil
+}
+
+// getUnpaidInvoices gets all unpaid invoices of the customer by retrieving
+// them page by page.
+func (c *client) getUnpaidInvoices(ctx context.Context, customerID string) ([]invoice, error) {
+	var invoices []invoice
+	for offset := 0; ; offset += invoicesPageSize {
+		params := url.Values{}
+		params.Add("main_attributes[customer_id]", customerID)
+		params.Add("main_attributes[status]", invoiceStatusNotPaid)
+		params.Add("limit", strconv.Itoa(invoicesPageSize))
+		params.Add("offset", strconv.Itoa(offset))
+
+		req, err := c.newRequest(ctx, "GET", "/api/2.0/admin/finance/invoices", params)
+		if err != nil {
+			return nil, fmt.Errorf("could not create request due: %v", err)
+		}
+		var page []invoice
+		resp, err := c.do(req, &page)
+		if err != nil {
+			return nil, fmt.Errorf("could not process get subscriber duties request due: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, epay.ResponseError(backend, resp)
+		}
+
+		invoices = append(invoices, page...)
+		if len(page) < invoicesPageSize {
+			return invoices, nil
+		}
+	}
+}
+
+// newItem maps the item of a Splynx invoice to epay.Item. The amount of the
+// item includes its tax. The period of the invoice is used when the item is
+// not having own period.
+func newItem(inv invoice, item invoiceItem, currency string) (epay.Item, error) {
+	price, ok := new(big.Rat).SetString(item.Price.String())
+	if !ok {
+		return epay.Item{}, fmt.Errorf("invalid price '%s'", item.Price)
+	}
+	tax := new(big.Rat)
+	if item.Tax != "" {
+		if _, ok := tax.SetString(item.Tax.String()); !ok {
+			return epay.Item{}, fmt.Errorf("invalid tax '%s'", item.Tax)
+		}
+	}
+	quantity := big.NewRat(1, 1)
+	if item.Quantity != "" {
+		if _, ok := quantity.SetString(item.Quantity.String()); !ok {
+			return epay.Item{}, fmt.Errorf("invalid quantity '%s'", item.Quantity)
+		}
+	}
+
+	// price * quantity * (100 + tax) / 100 is calculated exactly and it's
+	// rounded only once
+	total := new(big.Rat).Mul(price, quantity)
+	total.Mul(total, new(big.Rat).Add(big.NewRat(100, 1), tax))
+	total.Quo(total, big.NewRat(100, 1))
+	amount, err := epay.RatMoney(total, currency)
+	if err != nil {
+		return epay.Item{}, err
+	}
+	unitPrice, err := epay.RatMoney(price, currency)
+	if err != nil {
+		return epay.Item{}, err
+	}
+	vat, _ := tax.Float64()
+	count, _ := quantity.Float64()
+
+	startDate, endDate := item.PeriodFrom.Time, item.PeriodTo.Time
+	if startDate.IsZero() {
+		startDate = inv.DateCreated.Time
+	}
+	if endDate.IsZero() {
+		endDate = inv.DateTill.Time
+	}
+
+	return epay.Item{
+		Name:      item.Description,
+		StartDate: startDate,
+		EndDate:   endDate,
+		Amount:    amount.Amount(),
+		Vat:       number.Round(vat, 2),
+		Price:     unitPrice.String(),
+		Quantity:  int(math.Round(count)),
+	}, nil
+}
+
+func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePaymentOrderRequest) (*epay.PaymentOrder, error) {
+	duties, err := c.GetSubscriberDuties(ctx, createReq.SubscriberID)
+	if err != nil {
+		return nil, err
+	}
+
+	po, err := order.NewRecord(createReq, duties)
+	if err != nil {
+		return nil, err
+	}
+	return c.orders.Create(ctx, po, duties.Items)
+}
+
+func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
+	return c.orders.Get(ctx, orderKey)
+}
+
+// PayPaymentOrder adds the payment of the order to Splynx. The transaction of
+// ePay is used as receipt number of the payment, so payments which were added
+// by previous confirmations are recognized.
+func (c *client) PayPaymentOrder(ctx context.Context, payReq epay.PayPaymentOrderRequest) (*epay.PayPaymentOrderResponse, error) {
+	return c.orders.Pay(ctx, payReq)
+}
+
+// book adds the payment of the claimed order to Splynx.
+func (c *client) book(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	customerID, _ := strconv.Atoi(po.ClientID)
+	paymentReq := &paymentRequest{
+		CustomerID:    customerID,
+		PaymentType:   c.conf.PaymentTypeID,
+		ReceiptNumber: receiptNumber(po),
+		Date:          jsonDate{time.Now()},
+		Amount:        json.Number(po.PaidAmount.String()),
+		Comment:       fmt.Sprintf("ePay transaction %s for %s", po.TransactionID, po.SubscriberID),
+	}
+	// payments could be linked to a single invoice only
+	if len(po.InvoiceIDs) == 1 {
+		paymentReq.InvoiceID, _ = strconv.Atoi(po.InvoiceIDs[0])
+	}
+
+	req, err := c.newRequest(ctx, "POST", "/api/2.0/admin/finance/payments", paymentReq)
+	if err != nil {
+		return fmt.Errorf("could not create request due: %v", err)
+	}
+
+	r := &payment{}
+	resp, err := c.do(req, r)
+	if err != nil {
+		return order.Uncertain(fmt.Errorf("could not process payment request due: %w", err))
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return epay.ResponseError(backend, resp)
+	}
+	return nil
+}
+
+// findPaidAmount finds the amount of the payment which was added for the order.
+func (c *client) findPaidAmount(ctx context.Context, po *epay.PaymentOrderRecord) (*epay.Money, error) {
+	existing, err := c.findPayment(ctx, po)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	log.WithContext(ctx).Debugf("payment order '%s' was added as payment %d", po.TransactionID, existing.ID)
+
+	paid, err := epay.RoundMoney(existing.Amount.String(), po.Amount.Currency())
+	if err != nil {
+		// the payment is added anyway, so the order is considered paid in full
+		paid = po.PaidAmount
+	}
+	return &paid, nil
+}
+
+// receiptNumber returns the receipt number of the payment of the provided order.
+func receiptNumber(po *epay.PaymentOrderRecord) string {
+	return "EPAY-" + po.TransactionID
+}
+
+// findPayment finds the payment added for the provided payment order using its
+// receipt number. It returns nil if no such payment exists.
+func (c *client) findPayment(ctx context.Context, po *epay.PaymentOrderRecord) (*payment, error) {
+	params := url.Values{}
+	params.Add("main_attributes[customer_id]", po.ClientID)
+	params.Add("main_attributes[receipt_number]", receiptNumber(po))
+
+	req, err := c.newRequest(ctx, "GET", "/api/2.0/admin/finance/payments", params)
+	if err != nil {
+		return nil, err
+	}
+
+	var payments []payment
+	resp, err := c.do(req, &payments)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, epay.ResponseError(backend, resp)
+	}
+
+	for i := range payments {
+		if payments[i].ReceiptNumber == receiptNumber(po) {
+			return &payments[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
+	rel := &url.URL{Path: path}
+	u := c.conf.BillingURL.ResolveReference(rel)
+
+	var buf io.ReadWriter
+	if body != nil {
+		switch v := body.(type) {
+		case url.Values:
+			u.RawQuery = v.Encode()
+		default:
+			buf = new(bytes.Buffer)
+			err := json.NewEncoder(buf).Encode(body)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	req, err := http.NewRequest(method, u.String(), buf)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if buf != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(c.conf.APIKey, c.conf.APISecret)
+
+	return req, nil
+}
+
+func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
+	httpClient := c.conf.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, epay.RequestError(backend, err)
+	}
+	if resp.StatusCode >= 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	err = json.NewDecoder(resp.Body).Decode(v)
+	return resp, err
+}
+
+type customer struct {
+	ID    int    `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name"`
+}
+
+type invoice struct {
+	ID          int           `json:"id"`
+	CustomerID  int           `json:"customer_id"`
+	Number      string        `json:"number"`
+	DateCreated jsonDate      `json:"date_created"`
+	DateTill    jsonDate      `json:"date_till"`
+	Total       json.Number   `json:"total"`
+	Due         json.Number   `json:"due"`
+	Status      string        `json:"status"`
+	Items       []invoiceItem `json:"items"`
+}
+
+type invoiceItem struct {
+	Description string      `json:"description"`
+	Quantity    json.Number `json:"quantity"`
+	Price       json.Number `json:"price"`
+	Tax         json.Number `json:"tax"`
+	PeriodFrom  jsonDate    `json:"period_from"`
+	PeriodTo    jsonDate    `json:"period_to"`
+}
+
+// unpaidAmount returns the amount of the invoice which remains to be paid.
+// The total is used when the due amount is not provided. Splynx reports the
+// amounts with up to four decimals, so they are rounded to the currency units
+// as UCRM amounts are.
+func (inv invoice) unpaidAmount(currency string) (epay.Money, error) {
+	if inv.Due != "" {
+		return epay.RoundMoney(inv.Due.String(), currency)
+	}
+	return epay.RoundMoney(inv.Total.String(), currency)
+}
+
+type paymentRequest struct {
+	CustomerID    int         `json:"customer_id"`
+	InvoiceID     int         `json:"invoice_id,omitempty"`
+	PaymentType   int         `json:"payment_type"`
+	ReceiptNumber string      `json:"receipt_number"`
+	Date          jsonDate    `json:"date"`
+	Amount        json.Number `json:"amount"`
+	Comment       string      `json:"comment,omitempty"`
+}
+
+type payment struct {
+	ID            int         `json:"id"`
+	Amount        json.Number `json:"amount"`
+	ReceiptNumber string      `json:"receipt_number"`
+}
@@ -0,0 +1,333 @@
+package splynx
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/client/order"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+func TestSubscriberNotFound(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/admin/customers/customer", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	mux.HandleFunc("/api/2.0/admin/customers/customer/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := NewClient(testConfig(ts.URL), nil)
+	for _, idn := range []string{"::login::", "12345"} {
+		if _, err := client.GetSubscriberDuties(context.Background(), idn); !errors.Is(err, epay.ErrSubscriberNotFound) {
+			t.Errorf("expected subscriber '%s' not to be found, but got: %v", idn, err)
+		}
+	}
+}
+
+func TestSubscriberDuties(t *testing.T) {
+	var search url.Values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/admin/customers/customer", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "key" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`[{"id": 708, "login": "john", "name": "John Smith"}]`))
+	}))
+	mux.HandleFunc("/api/2.0/admin/finance/invoices", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		search = r.URL.Query()
+		content := `[
+			{
+				"id": 101,
+				"customer_id": 708,
+				"number": "INV-101",
+				"date_created": "2020-04-01",
+				"date_till": "2020-04-15",
+				"total": "24.00",
+				"due": "14.00",
+				"status": "not_paid",
+				"items": [
+					{"description": "Internet 04/2020", "quantity": "2", "price": "10.00", "tax": "20", "period_from": "2020-04-01", "period_to": "2020-04-30"}
+				]
+			},
+			{
+				"id": 102,
+				"customer_id": 708,
+				"date_created": "2020-03-01",
+				"date_till": "2020-03-15",
+				"total": "6.00",
+				"status": "not_paid",
+				"items": [
+					{"description": "TV 03/2020", "quantity": "1", "price": "5.00", "tax": "20", "period_from": "0000-00-00", "period_to": "0000-00-00"}
+				]
+			}
+		]`
+		w.Write([]byte(content))
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := NewClient(testConfig(ts.URL), nil)
+	got, err := client.GetSubscriberDuties(context.Background(), "john")
+	if err != nil {
+		t.Fatalf("unable to get subscriber duties due: %v", err)
+	}
+
+	want := &epay.SubscriberDuties{
+		CustomerName: "John Smith",
+		CustomerRef:  "708",
+		DutyAmount:   epay.Amount{Value: "20.00", Currency: "BGN"},
+		DueDate:      time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC),
+		DocumentIDs:  []string{"101", "102"},
+		Items: []epay.Item{
+			{
+				Name:      "Internet 04/2020",
+				StartDate: time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2020, 4, 30, 0, 0, 0, 0, time.UTC),
+				Amount:    epay.Amount{Value: "24.00", Currency: "BGN"},
+				Vat:       20,
+				Price:     "10.00",
+				Quantity:  2,
+			},
+			{
+				Name:      "TV 03/2020",
+				StartDate: time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:   time.Date(2020, 3, 15, 0, 0, 0, 0, time.UTC),
+				Amount:    epay.Amount{Value: "6.00", Currency: "BGN"},
+				Vat:       20,
+				Price:     "5.00",
+				Quantity:  1,
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected: %+v", want)
+		t.Errorf("     got: %+v", got)
+	}
+	if search.Get("main_attributes[customer_id]") != "708" || search.Get("main_attributes[status]") != "not_paid" {
+		t.Errorf("unexpected invoices search: %v", search)
+	}
+}
+
+func TestItemAmountsAreExact(t *testing.T) {
+	cases := []struct {
+		item   invoiceItem
+		amount string
+		price  string
+	}{
+		{invoiceItem{Quantity: "1", Price: "1.005", Tax: "0"}, "1.01", "1.01"},
+		{invoiceItem{Quantity: "3", Price: "0.1", Tax: "20"}, "0.36", "0.10"},
+		{invoiceItem{Quantity: "3", Price: "8.335", Tax: "20"}, "30.01", "8.34"},
+		{invoiceItem{Price: "19.99"}, "19.99", "19.99"},
+	}
+
+	for _, c := range cases {
+		got, err := newItem(invoice{}, c.item, "BGN")
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %v", c.item, err)
+			continue
+		}
+		if got.Amount.Value != c.amount || got.Price != c.price {
+			t.Errorf("expected amount %s and price %s of %+v, but got: %s and %s", c.amount, c.price, c.item, got.Amount.Value, got.Price)
+		}
+	}
+}
+
+func TestUnpaidAmountIsRounded(t *testing.T) {
+	cases := []struct {
+		inv  invoice
+		want string
+	}{
+		{invoice{Total: "24.0000", Due: "12.3450"}, "12.35"},
+		{invoice{Total: "24.0049"}, "24.00"},
+		{invoice{Total: "6.00", Due: "0.0000"}, "0.00"},
+	}
+
+	for _, c := range cases {
+		got, err := c.inv.unpaidAmount("BGN")
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %v", c.inv, err)
+			continue
+		}
+		if got.Amount().Value != c.want {
+			t.Errorf("expected unpaid amount %s of %+v, but got: %s", c.want, c.inv, got.Amount().Value)
+		}
+	}
+
+	if _, err := (invoice{Total: "n/a"}).unpaidAmount("BGN"); !errors.Is(err, epay.ErrInvalidAmount) {
+		t.Errorf("expected invalid amount error, but got: %v", err)
+	}
+}
+
+func TestSubscriberDutiesByCustomerID(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/admin/customers/customer", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	mux.HandleFunc("/api/2.0/admin/customers/customer/708", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id": 708, "login": "john", "name": "John Smith"}`))
+	}))
+	mux.HandleFunc("/api/2.0/admin/finance/invoices", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	client := NewClient(testConfig(ts.URL), nil)
+	got, err := client.GetSubscriberDuties(context.Background(), "708")
+	if err != nil {
+		t.Fatalf("unable to get subscriber duties due: %v", err)
+	}
+	if got.CustomerRef != "708" || got.CustomerName != "John Smith" || got.DutyAmount != (epay.Amount{Value: "0.00", Currency: "BGN"}) {
+		t.Errorf("unexpected duties: %+v", got)
+	}
+}
+
+func TestCreatePaymentOrder(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/admin/customers/customer", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 708, "login": "john", "name": "John Smith"}]`))
+	}))
+	mux.HandleFunc("/api/2.0/admin/finance/invoices", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"id": 101, "total": "20.00", "status": "not_paid"}]`))
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	store := order.NewFakeStore()
+	client := NewClient(testConfig(ts.URL), store)
+	po, err := client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: "john", TransactionID: "TID1"})
+	if err != nil {
+		t.Fatalf("unable to create payment order due: %v", err)
+	}
+	if po.Amount.Value != "20.00" || po.CustomerName != "John Smith" {
+		t.Errorf("unexpected payment order: %+v", po)
+	}
+
+	record, err := store.Get(context.Background(), "TID1")
+	if err != nil {
+		t.Fatalf("expected payment order to be stored, but got: %v", err)
+	}
+	if record.ClientID != "708" || record.Amount != epay.NewMoney(2000, "BGN") || !reflect.DeepEqual(record.InvoiceIDs, []string{"101"}) {
+		t.Errorf("unexpected stored payment order: %+v", record)
+	}
+}
+
+func TestPayPaymentOrder(t *testing.T) {
+	var got paymentRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/admin/finance/payments", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			jsonReply(w, []payment{})
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("unable to decode payment request due: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		jsonReply(w, &payment{ID: 1})
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	store := order.NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", SubscriberID: "john", ClientID: "708", Amount: epay.NewMoney(2000, "BGN"), InvoiceIDs: []string{"101"}})
+
+	client := NewClient(testConfig(ts.URL), store)
+	resp, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1", Amount: epay.Amount{Value: "15.50", Currency: "BGN"}})
+	if err != nil {
+		t.Fatalf("unable to pay payment order due: %v", err)
+	}
+
+	if got.Amount != "15.50" || got.CustomerID != 708 || got.InvoiceID != 101 || got.PaymentType != 3 || got.ReceiptNumber != "EPAY-TID1" {
+		t.Errorf("unexpected payment request: %+v", got)
+	}
+	if resp.Amount.Value != "15.50" {
+		t.Errorf("expected paid amount to be 15.50, but got: %s", resp.Amount.Value)
+	}
+
+	po, _ := store.Get(context.Background(), "TID1")
+	if po.ProcessedOn.IsZero() || po.PaidAmount != epay.NewMoney(1550, "BGN") {
+		t.Errorf("expected payment order to be processed, but got: %+v", po)
+	}
+}
+
+func TestPayPaymentOrderRecoversAddedPayment(t *testing.T) {
+	posted := false
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/2.0/admin/finance/payments", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			jsonReply(w, []payment{{ID: 7, Amount: "20.00", ReceiptNumber: "EPAY-TID1"}})
+			return
+		}
+		posted = true
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	store := order.NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", ClientID: "708", Amount: epay.NewMoney(2000, "BGN")})
+
+	client := NewClient(testConfig(ts.URL), store)
+	if _, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyPaid)
+		t.Errorf("     got: %v", err)
+	}
+	if posted {
+		t.Error("expected payment not to be added twice")
+	}
+
+	po, _ := store.Get(context.Background(), "TID1")
+	if po.ProcessedOn.IsZero() {
+		t.Errorf("expected payment order to be processed, but got: %+v", po)
+	}
+}
+
+func TestConfigFromEnvironment(t *testing.T) {
+	env := epay.Environment{Metadata: map[string]string{
+		"splynxUrl":           "https://splynx.example.com",
+		"splynxApiKey":        "::key::",
+		"splynxApiSecret":     "::secret::",
+		"splynxPaymentTypeId": "3",
+	}}
+	conf, err := ConfigFromEnvironment(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conf.PaymentTypeID != 3 || conf.Currency != "BGN" || conf.BillingURL.Host != "splynx.example.com" {
+		t.Errorf("unexpected config: %+v", conf)
+	}
+
+	env.Metadata["splynxPaymentTypeId"] = "cash"
+	if _, err := ConfigFromEnvironment(env); err == nil {
+		t.Error("expected invalid payment type to be rejected")
+	}
+}
+
+func testConfig(baseURL string) Config {
+	u, _ := url.Parse(baseURL)
+	return Config{BillingURL: u, APIKey: "key", APISecret: "secret", PaymentTypeID: 3, Currency: "BGN"}
+}
+
+func jsonReply(w http.ResponseWriter, v interface{}) {
+	jsonVal, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonVal)
+}
@@ -0,0 +1,88 @@
+package splynx
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Config is the configuration of the client for Splynx.
+type Config struct {
+	// BillingURL is the base URL of the Splynx API
+	BillingURL *url.URL
+
+	// APIKey and APISecret are the credentials of the API key which is
+	// used for basic authentication to the Splynx API
+	APIKey    string
+	APISecret string
+
+	// PaymentTypeID is the ID of the payment method of the payments added
+	// to Splynx
+	PaymentTypeID int
+
+	// Currency is the currency of the amounts kept in Splynx. BGN is used
+	// when it's not set.
+	Currency string
+
+	// HTTPClient is the client used for the requests to the billing system.
+	// http.DefaultClient is used when it's not set.
+	HTTPClient *http.Client
+}
+
+// ConfigFromEnvironment parses and validates the Splynx configuration kept in
+// the metadata of the provided environment.
+func ConfigFromEnvironment(env epay.Environment) (*Config, error) {
+	m := env.Metadata
+	if m["splynxUrl"] == "" {
+		return nil, fmt.Errorf("splynxUrl is required")
+	}
+	billingURL, err := url.Parse(m["splynxUrl"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid splynxUrl: %v", err)
+	}
+
+	paymentTypeID := 0
+	if v := m["splynxPaymentTypeId"]; v != "" {
+		if paymentTypeID, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid splynxPaymentTypeId '%s'", v)
+		}
+	}
+
+	currency := strings.ToUpper(m["splynxCurrency"])
+	if currency == "" {
+		currency = epay.CurrencyBGN
+	}
+
+	c := &Config{
+		BillingURL:    billingURL,
+		APIKey:        m["splynxApiKey"],
+		APISecret:     m["splynxApiSecret"],
+		PaymentTypeID: paymentTypeID,
+		Currency:      currency,
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.BillingURL == nil || !c.BillingURL.IsAbs() || c.BillingURL.Host == "" {
+		return fmt.Errorf("splynxUrl must be an absolute URL")
+	}
+	if c.APIKey == "" || c.APISecret == "" {
+		return fmt.Errorf("splynxApiKey and splynxApiSecret are required")
+	}
+	if c.PaymentTypeID <= 0 {
+		return fmt.Errorf("splynxPaymentTypeId is required")
+	}
+	if len(c.Currency) != 3 {
+		return fmt.Errorf("invalid splynxCurrency '%s'", c.Currency)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package splynx
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// jsonDate is a date in the format used by Splynx, e.g 2020-04-15.
+type jsonDate struct {
+	time.Time
+}
+
+func (t jsonDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.UTC().Format("2006-01-02"))
+}
+
+func (t *jsonDate) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	// dates which are not set are returned as zero dates
+	if v == "" || v == "0000-00-00" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
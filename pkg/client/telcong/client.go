@@ -8,12 +8,16 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/clouway/go-epay/pkg/epay"
 )
 
 const (
 	userAgent = "telcong-golang-epay/20180125"
+
+	// backend is the name of the billing system in the errors
+	backend = "telcong"
 )
 
 // client is an HTTP client which uses API endpoints provided by the platform
@@ -43,16 +47,16 @@ func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (
 	var duties epay.SubscriberDuties
 	resp, err := c.do(req, &duties)
 	if err != nil {
-		return nil, fmt.Errorf("could not process get subscriber duties request due: %v", err)
+		return nil, fmt.Errorf("could not process get subscriber duties request due: %w", err)
 	}
 	if resp.StatusCode == http.StatusOK {
 		return &duties, nil
 	}
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrSubscriberNotFound
+		return nil, withKind(epay.ResponseError(backend, resp), epay.ErrSubscriberNotFound)
 	}
 
-	return nil, fmt.Errorf("unknown repospone during retrieving of subscriber duties: %v", err)
+	return nil, epay.ResponseError(backend, resp)
 }
 
 // CreatePaymentOrder creates a new PaymentOrder in the target system using the provided request.
@@ -65,19 +69,19 @@ func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePa
 	var paymentOrder epay.PaymentOrder
 	resp, err := c.do(req, &paymentOrder)
 	if err != nil {
-		return nil, fmt.Errorf("could not process create order request due: %v", err)
+		return nil, fmt.Errorf("could not process create order request due: %w", err)
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrSubscriberNotFound
+		return nil, withKind(epay.ResponseError(backend, resp), epay.ErrSubscriberNotFound)
 	}
 
 	if resp.StatusCode == http.StatusBadRequest {
-		return nil, epay.ErrPaymentOrderAlreadyExists
+		return nil, withKind(epay.ResponseError(backend, resp), epay.ErrPaymentOrderAlreadyExists)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, epay.ErrUnknown
+		return nil, epay.ResponseError(backend, resp)
 	}
 
 	return &paymentOrder, nil
@@ -92,20 +96,25 @@ func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.Pa
 	var paymentOrder epay.PaymentOrder
 	resp, err := c.do(req, &paymentOrder)
 	if err != nil {
-		return nil, fmt.Errorf("cannot retrieve payment order due: %v", err)
+		return nil, fmt.Errorf("cannot retrieve payment order due: %w", err)
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrPaymentOrderNotFound
+		return nil, withKind(epay.ResponseError(backend, resp), epay.ErrPaymentOrderNotFound)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, epay.ResponseError(backend, resp)
 	}
 
 	return &paymentOrder, nil
 }
 
 // PayPaymentOrder performs payment of the the order associated with the providing
-// the ID of the order or the transactionID associated with it.
-func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.PayPaymentOrderResponse, error) {
-	req, err := c.newRequest(context.Background(), "POST", fmt.Sprintf("/v1/paymentorders/%s/pay", orderID), nil)
+// the ID of the order or the transactionID associated with it. TelcoNG books the
+// amount of the order, so the paid amount of the request is not used.
+func (c *client) PayPaymentOrder(ctx context.Context, payReq epay.PayPaymentOrderRequest) (*epay.PayPaymentOrderResponse, error) {
+	req, err := c.newRequest(ctx, "POST", fmt.Sprintf("/v1/paymentorders/%s/pay", payReq.OrderID), nil)
 	if err != nil {
 		return nil, fmt.Errorf("could not create request due: %v", err)
 	}
@@ -113,7 +122,7 @@ func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.Pay
 	var paymentResponse epay.PayPaymentOrderResponse
 	resp, err := c.do(req, &paymentResponse)
 	if err != nil {
-		return nil, fmt.Errorf("unable to process payment request due: %v", err)
+		return nil, fmt.Errorf("unable to process payment request due: %w", err)
 	}
 
 	if resp.StatusCode == http.StatusOK {
@@ -121,17 +130,31 @@ func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.Pay
 	}
 
 	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrPaymentOrderNotFound
+		return nil, withKind(epay.ResponseError(backend, resp), epay.ErrPaymentOrderNotFound)
 	}
 
-	if resp.StatusCode == http.StatusBadRequest {
-		er, derr := decodeResponse(resp.Body)
-		if derr == nil && er.Message == "Payment order is already paid." {
-			return nil, epay.ErrPaymentOrderAlreadyPaid
-		}
+	berr := epay.ResponseError(backend, resp)
+	if alreadyPaid(berr) {
+		return nil, withKind(berr, epay.ErrPaymentOrderAlreadyPaid)
 	}
+	return nil, berr
+}
 
-	return nil, epay.ErrUnknown
+// alreadyPaid reports whether the payment was rejected as the order is already
+// paid. TelcoNG responds with 409 or with 400 and a message about it.
+func alreadyPaid(e *epay.BillingError) bool {
+	if e.StatusCode == http.StatusConflict {
+		return true
+	}
+	return e.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(e.Message), "already paid")
+}
+
+// withKind sets the kind of the error for the statuses which are specific for
+// the request.
+func withKind(e *epay.BillingError, kind error) *epay.BillingError {
+	e.Err = kind
+	e.Retriable = false
+	return e
 }
 
 func (c *client) newRequest(ctx context.Context, method, path string, body interface{}) (*http.Request, error) {
@@ -162,7 +185,7 @@ func (c *client) newRequest(ctx context.Context, method, path string, body inter
 func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, epay.RequestError(backend, err)
 	}
 	if resp.StatusCode >= 300 {
 		return resp, nil
@@ -171,14 +194,3 @@ func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
 	err = json.NewDecoder(resp.Body).Decode(v)
 	return resp, err
 }
-
-func decodeResponse(r io.ReadCloser) (*errorResponse, error) {
-	defer r.Close()
-	resp := errorResponse{}
-	err := json.NewDecoder(r).Decode(&resp)
-	return &resp, err
-}
-
-type errorResponse struct {
-	Message string `json:"message"`
-}
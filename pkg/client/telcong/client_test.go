@@ -3,6 +3,7 @@ package telcong
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -43,7 +44,7 @@ func TestGetDutiesOfUnknownSubscriber(t *testing.T) {
 
 	client := NewClient(nil, baseURL)
 	_, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
-	if err != epay.ErrSubscriberNotFound {
+	if !errors.Is(err, epay.ErrSubscriberNotFound) {
 		t.Fatalf("not existing subscriber response was returned as: %v", err)
 	}
 }
@@ -109,7 +110,7 @@ func TestTryToCreateOrderForNotExistingSubscriber(t *testing.T) {
 
 	_, err := client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: "::unknown::", TransactionID: "TID2"})
 
-	if err != epay.ErrSubscriberNotFound {
+	if !errors.Is(err, epay.ErrSubscriberNotFound) {
 		t.Errorf("	expected: %v", epay.ErrSubscriberNotFound)
 		t.Errorf("	     got: %v", err)
 	}
@@ -126,7 +127,7 @@ func TestPaymentOrderAlreadyExists(t *testing.T) {
 
 	_, err := client.CreatePaymentOrder(context.Background(), epay.CreatePaymentOrderRequest{SubscriberID: "::unknown::", TransactionID: "TID2"})
 
-	if err != epay.ErrPaymentOrderAlreadyExists {
+	if !errors.Is(err, epay.ErrPaymentOrderAlreadyExists) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderAlreadyExists)
 		t.Errorf("	     got: %v", err)
 	}
@@ -142,7 +143,7 @@ func TestPayPaymentOrder(t *testing.T) {
 
 	client := NewClient(nil, baseURL)
 
-	resp, err := client.PayPaymentOrder(context.Background(), "::any order id::")
+	resp, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "::any order id::"})
 
 	if err != nil {
 		t.Fatal("error should not be returned for successful payment")
@@ -163,9 +164,9 @@ func TestPayUnknownPaymentOrder(t *testing.T) {
 
 	client := NewClient(nil, baseURL)
 
-	_, err := client.PayPaymentOrder(context.Background(), "::unknown order id::")
+	_, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "::unknown order id::"})
 
-	if err != epay.ErrPaymentOrderNotFound {
+	if !errors.Is(err, epay.ErrPaymentOrderNotFound) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderNotFound)
 		t.Errorf("	     got: %v", err)
 	}
@@ -175,16 +176,16 @@ func TestPayUnknownPaymentOrder(t *testing.T) {
 func TestPayAlreadyPaidPaymentOrder(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
-		jsonReply(w, &errorResponse{Message: "Payment order is already paid."})
+		jsonReply(w, map[string]string{"message": "Payment order is already paid."})
 	}))
 	defer ts.Close()
 	baseURL, _ := url.Parse(ts.URL)
 
 	client := NewClient(nil, baseURL)
 
-	_, err := client.PayPaymentOrder(context.Background(), "::paid order id::")
+	_, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "::paid order id::"})
 
-	if err != epay.ErrPaymentOrderAlreadyPaid {
+	if !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderAlreadyPaid)
 		t.Errorf("	     got: %v", err)
 	}
@@ -200,10 +201,10 @@ func TestPaymentFailsWithServerError(t *testing.T) {
 
 	client := NewClient(nil, baseURL)
 
-	_, err := client.PayPaymentOrder(context.Background(), "::order id::")
+	_, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "::order id::"})
 
-	if err != epay.ErrUnknown {
-		t.Errorf("	expected: %v", epay.ErrUnknown)
+	if !errors.Is(err, epay.ErrBillingUnavailable) || !epay.IsRetriable(err) {
+		t.Errorf("	expected: %v", epay.ErrBillingUnavailable)
 		t.Errorf("	     got: %v", err)
 	}
 
@@ -240,13 +241,52 @@ func TestGetUnknownPaymentOrder(t *testing.T) {
 	client := NewClient(nil, baseURL)
 
 	_, err := client.GetPaymentOrder(context.Background(), "1")
-	if err != epay.ErrPaymentOrderNotFound {
+	if !errors.Is(err, epay.ErrPaymentOrderNotFound) {
 		t.Errorf("	expected: %v", epay.ErrPaymentOrderNotFound)
 		t.Errorf("	     got: %v", err)
 	}
 
 }
 
+func TestGetPaymentOrderFailsWithServerError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "internal", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClient(nil, baseURL)
+
+	po, err := client.GetPaymentOrder(context.Background(), "1")
+	if !errors.Is(err, epay.ErrBillingUnavailable) || po != nil {
+		t.Errorf("	expected: %v", epay.ErrBillingUnavailable)
+		t.Errorf("	     got: %v, %v", po, err)
+	}
+}
+
+func TestPaymentFailsWithUnauthorizedError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		jsonReply(w, map[string]string{"code": "forbidden", "message": "Access denied."})
+	}))
+	defer ts.Close()
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClient(nil, baseURL)
+
+	_, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "::order id::"})
+
+	var berr *epay.BillingError
+	if !errors.As(err, &berr) || !errors.Is(err, epay.ErrBillingUnauthorized) || epay.IsRetriable(err) {
+		t.Fatalf("expected unauthorized error, but got: %v", err)
+	}
+	want := &epay.BillingError{Backend: "telcong", StatusCode: http.StatusForbidden, Code: "forbidden", Message: "Access denied.", Err: epay.ErrBillingUnauthorized}
+	if !reflect.DeepEqual(berr, want) {
+		t.Errorf("	expected: %+v", want)
+		t.Errorf("	     got: %+v", berr)
+	}
+}
+
 func TestGetPaymentOrderFails(t *testing.T) {
 	baseURL, _ := url.Parse("http://localhost:12002")
 
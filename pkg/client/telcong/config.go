@@ -0,0 +1,67 @@
+package telcong
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Config is the configuration of the client for TelcoNG.
+type Config struct {
+	// BillingURL is the base URL of the billing API
+	BillingURL *url.URL
+
+	// JWT is the configuration of the service account used for
+	// authentication to the billing API
+	JWT *jwt.Config
+}
+
+// ConfigFromEnvironment parses and validates the TelcoNG configuration
+// of the provided environment.
+func ConfigFromEnvironment(env epay.Environment) (*Config, error) {
+	if env.BillingURL == "" {
+		return nil, fmt.Errorf("billing URL of telcong is required")
+	}
+	billingURL, err := url.Parse(env.BillingURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid billing URL of telcong: %v", err)
+	}
+
+	if env.BillingJWTKey == "" {
+		return nil, fmt.Errorf("JWT key of telcong is required")
+	}
+	conf, err := google.JWTConfigFromJSON([]byte(env.BillingJWTKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT key of telcong: %v", err)
+	}
+
+	c := &Config{BillingURL: billingURL, JWT: conf}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TokenKey returns the key of the tokens of the service account in the token
+// cache. The key is changed when the private key of the account is changed.
+func (c *Config) TokenKey() string {
+	sum := sha256.Sum256(c.JWT.PrivateKey)
+	return "telcong:" + c.JWT.Email + ":" + hex.EncodeToString(sum[:8])
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.BillingURL == nil || !c.BillingURL.IsAbs() || c.BillingURL.Host == "" {
+		return fmt.Errorf("billing URL of telcong must be an absolute URL")
+	}
+	if c.JWT == nil || c.JWT.Email == "" {
+		return fmt.Errorf("JWT key of telcong is missing the client email")
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Package token provides a cache of the OAuth2 tokens used for authentication
+// to the billing systems, so a new token is not requested on every request.
+package token
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// DefaultEarlyRefresh is the time before the expiry of a token in which
+	// the token is refreshed.
+	DefaultEarlyRefresh = 5 * time.Minute
+
+	// DefaultRefreshTimeout is the time after which a refresh of a token is
+	// abandoned, so the next request could start a new one.
+	DefaultRefreshTimeout = 30 * time.Second
+)
+
+// Store keeps the cached tokens between the restarts of the service.
+type Store interface {
+	// Get returns the token with the provided key or nil if there is no such.
+	Get(ctx context.Context, key string) (*oauth2.Token, error)
+
+	// Put saves the token with the provided key.
+	Put(ctx context.Context, key string, token *oauth2.Token) error
+}
+
+// Cache caches the tokens by key in the process and in the optional store. A
+// token is refreshed once per key at a time, so concurrent requests are waiting
+// for the same refresh instead of requesting own tokens.
+type Cache struct {
+	store   Store
+	early   time.Duration
+	timeout time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	token   *oauth2.Token
+	loaded  bool
+	refresh *call
+}
+
+// call is a refresh of a token which is in progress.
+type call struct {
+	done  chan struct{}
+	token *oauth2.Token
+	err   error
+}
+
+// NewCache creates a new cache which refreshes the tokens early before their
+// expiry. The tokens are kept in the process only when the store is nil.
+func NewCache(store Store, early time.Duration) *Cache {
+	return &Cache{store: store, early: early, timeout: DefaultRefreshTimeout, entries: make(map[string]*entry)}
+}
+
+// TokenSource returns a token source which returns the cached token of the
+// key and uses the provided source for its refresh. The tokens could be
+// retrieved within a context by Transport.
+func (c *Cache) TokenSource(key string, src oauth2.TokenSource) oauth2.TokenSource {
+	return &cachedTokenSource{cache: c, key: key, src: src}
+}
+
+type cachedTokenSource struct {
+	cache *Cache
+	key   string
+	src   oauth2.TokenSource
+}
+
+func (s *cachedTokenSource) Token() (*oauth2.Token, error) {
+	return s.cache.token(context.Background(), s.key, s.src)
+}
+
+// TokenContext returns the token and stops waiting for its refresh when the
+// context is done. The refresh is not cancelled, so it's completed for the
+// other requests.
+func (s *cachedTokenSource) TokenContext(ctx context.Context) (*oauth2.Token, error) {
+	return s.cache.token(ctx, s.key, s.src)
+}
+
+func (c *Cache) token(ctx context.Context, key string, src oauth2.TokenSource) (*oauth2.Token, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &entry{}
+		c.entries[key] = e
+	}
+	if c.fresh(e.token) {
+		token := e.token
+		c.mu.Unlock()
+		return token, nil
+	}
+
+	r := e.refresh
+	if r == nil {
+		r = &call{done: make(chan struct{})}
+		e.refresh = r
+		go c.refresh(key, e, src, r)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.token, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("could not retrieve token due: %w", ctx.Err())
+	}
+}
+
+// refresh loads the token from the store when it's not loaded yet and gets
+// a new one from the source when it's not fresh. The refresh is abandoned
+// when it's not completed within the timeout of the cache.
+func (c *Cache) refresh(key string, e *entry, src oauth2.TokenSource, r *call) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	c.mu.Lock()
+	token, loaded := e.token, e.loaded
+	c.mu.Unlock()
+
+	if !loaded && c.store != nil {
+		stored, err := c.store.Get(ctx, key)
+		if err != nil {
+			log.Printf("could not load token of '%s' due: %v", key, err)
+		} else if stored != nil {
+			token = stored
+		}
+	}
+
+	if !c.fresh(token) {
+		refreshed, err := fetch(ctx, src)
+		switch {
+		case err == nil:
+			token = refreshed
+			if c.store != nil {
+				if err := c.store.Put(ctx, key, token); err != nil {
+					log.Printf("could not save token of '%s' due: %v", key, err)
+				}
+			}
+		case token.Valid():
+			// the token is still valid, so it's used until the next refresh
+			log.Printf("could not refresh token of '%s' due: %v", key, err)
+		default:
+			token, r.err = nil, fmt.Errorf("could not retrieve token due: %w", err)
+		}
+	}
+	r.token = token
+
+	c.mu.Lock()
+	e.token = token
+	e.loaded = true
+	e.refresh = nil
+	c.mu.Unlock()
+
+	close(r.done)
+}
+
+// fetch gets a new token from the source. The source is not accepting a
+// context, so it's left to complete on its own when the context is done.
+func fetch(ctx context.Context, src oauth2.TokenSource) (*oauth2.Token, error) {
+	type result struct {
+		token *oauth2.Token
+		err   error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		token, err := src.Token()
+		ch <- result{token, err}
+	}()
+
+	select {
+	case res := <-ch:
+		return res.token, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fresh reports whether the token is valid and it's not about to expire.
+func (c *Cache) fresh(token *oauth2.Token) bool {
+	if token == nil || token.AccessToken == "" {
+		return false
+	}
+	return token.Expiry.IsZero() || time.Until(token.Expiry) > c.early
+}
@@ -0,0 +1,174 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestConcurrentRequestsShareRefresh(t *testing.T) {
+	var calls int32
+	src := tokenSourceFunc(func() (*oauth2.Token, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return &oauth2.Token{AccessToken: "::token::", Expiry: time.Now().Add(time.Hour)}, nil
+	})
+
+	cache := NewCache(nil, DefaultEarlyRefresh)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			token, err := cache.TokenSource("isp1", src).Token()
+			if err != nil || token.AccessToken != "::token::" {
+				t.Errorf("unexpected token: %v, %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if _, err := cache.TokenSource("isp1", src).Token(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected token to be requested once, but was requested %d times", calls)
+	}
+}
+
+func TestRefreshTokenBeforeExpiry(t *testing.T) {
+	expiries := []time.Duration{time.Minute, time.Hour}
+	var calls int
+	src := tokenSourceFunc(func() (*oauth2.Token, error) {
+		token := &oauth2.Token{AccessToken: "::token::", Expiry: time.Now().Add(expiries[calls])}
+		calls++
+		return token, nil
+	})
+
+	cache := NewCache(nil, 5*time.Minute)
+	ts := cache.TokenSource("isp1", src)
+	for i := 0; i < 3; i++ {
+		if _, err := ts.Token(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the first token expires within 5 minutes, so it's refreshed on the next call
+	if calls != 2 {
+		t.Errorf("expected token to be requested 2 times, but was requested %d times", calls)
+	}
+}
+
+func TestUseValidTokenWhenRefreshFails(t *testing.T) {
+	valid := &oauth2.Token{AccessToken: "::valid token::", Expiry: time.Now().Add(time.Minute)}
+	store := &fakeStore{tokens: map[string]*oauth2.Token{"isp1": valid}}
+	src := tokenSourceFunc(func() (*oauth2.Token, error) {
+		return nil, errors.New("iam is not available")
+	})
+
+	cache := NewCache(store, 5*time.Minute)
+	token, err := cache.TokenSource("isp1", src).Token()
+	if err != nil || token.AccessToken != "::valid token::" {
+		t.Errorf("expected: %v", valid)
+		t.Errorf("     got: %v, %v", token, err)
+	}
+
+	if _, err := cache.TokenSource("isp2", src).Token(); err == nil {
+		t.Errorf("expected error when there is no valid token")
+	}
+}
+
+func TestLoadTokenFromStore(t *testing.T) {
+	stored := &oauth2.Token{AccessToken: "::stored token::", Expiry: time.Now().Add(time.Hour)}
+	store := &fakeStore{tokens: map[string]*oauth2.Token{"isp1": stored}}
+	src := tokenSourceFunc(func() (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "::new token::", Expiry: time.Now().Add(time.Hour)}, nil
+	})
+
+	cache := NewCache(store, DefaultEarlyRefresh)
+	if token, _ := cache.TokenSource("isp1", src).Token(); token.AccessToken != "::stored token::" {
+		t.Errorf("expected stored token, but got: %v", token)
+	}
+	if token, _ := cache.TokenSource("isp2", src).Token(); token.AccessToken != "::new token::" {
+		t.Errorf("expected new token, but got: %v", token)
+	}
+	if store.tokens["isp2"] == nil {
+		t.Errorf("expected new token to be saved")
+	}
+}
+
+func TestStopWaitingForRefreshWhenContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	src := tokenSourceFunc(func() (*oauth2.Token, error) {
+		<-release
+		return &oauth2.Token{AccessToken: "::token::", Expiry: time.Now().Add(time.Hour)}, nil
+	})
+
+	cache := NewCache(nil, DefaultEarlyRefresh)
+	ts := cache.TokenSource("isp1", src).(contextTokenSource)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := ts.TokenContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected: %v", context.DeadlineExceeded)
+		t.Errorf("     got: %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected request to stop waiting, but it waited %v", d)
+	}
+}
+
+func TestAbandonRefreshAfterTimeout(t *testing.T) {
+	var calls int32
+	src := tokenSourceFunc(func() (*oauth2.Token, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// the first refresh is hanging
+			time.Sleep(time.Second)
+		}
+		return &oauth2.Token{AccessToken: "::token::", Expiry: time.Now().Add(time.Hour)}, nil
+	})
+
+	cache := NewCache(nil, DefaultEarlyRefresh)
+	cache.timeout = 20 * time.Millisecond
+	ts := cache.TokenSource("isp1", src)
+
+	if _, err := ts.Token(); err == nil {
+		t.Errorf("expected hanging refresh to be abandoned")
+	}
+	if token, err := ts.Token(); err != nil || token.AccessToken != "::token::" {
+		t.Errorf("expected token of the next refresh, but got: %v, %v", token, err)
+	}
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+type fakeStore struct {
+	mu     sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+func (s *fakeStore) Get(ctx context.Context, key string) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tokens[key], nil
+}
+
+func (s *fakeStore) Put(ctx context.Context, key string, token *oauth2.Token) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens[key] = token
+	return nil
+}
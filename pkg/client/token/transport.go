@@ -0,0 +1,52 @@
+package token
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+)
+
+// contextTokenSource is a token source which tokens could be retrieved within
+// a context, e.g the token sources of Cache.
+type contextTokenSource interface {
+	TokenContext(ctx context.Context) (*oauth2.Token, error)
+}
+
+// Transport is a http.RoundTripper which authorizes the requests with the tokens
+// of the source. The tokens of the cache are retrieved within the context of the
+// requests, so cancelled requests are not waiting for the refresh of the token.
+type Transport struct {
+	// Source is the source of the tokens
+	Source oauth2.TokenSource
+
+	// Base is the transport of the authorized requests,
+	// http.DefaultTransport is used when it's nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip authorizes the request and sends it by the base transport.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var token *oauth2.Token
+	var err error
+	if src, ok := t.Source.(contextTokenSource); ok {
+		token, err = src.TokenContext(req.Context())
+	} else {
+		token, err = t.Source.Token()
+	}
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	authorized := req.Clone(req.Context())
+	token.SetAuthHeader(authorized)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(authorized)
+}
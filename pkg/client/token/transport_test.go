@@ -0,0 +1,33 @@
+package token
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestTransportAuthorizesRequests(t *testing.T) {
+	var got string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer ts.Close()
+
+	src := tokenSourceFunc(func() (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "::token::", Expiry: time.Now().Add(time.Hour)}, nil
+	})
+	client := &http.Client{Transport: &Transport{Source: NewCache(nil, DefaultEarlyRefresh).TokenSource("isp1", src)}}
+
+	resp, err := client.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if got != "Bearer ::token::" {
+		t.Errorf("expected request to be authorized, but got: '%s'", got)
+	}
+}
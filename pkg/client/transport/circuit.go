@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type state int
+
+const (
+	// closed is the state in which the calls are let through
+	closed state = iota
+	// open is the state in which the calls are short-circuited
+	open
+	// halfOpen is the state in which a single call is let through
+	// to check whether the billing system is back
+	halfOpen
+)
+
+func (s state) String() string {
+	switch s {
+	case open:
+		return "open"
+	case halfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// Stats are the metrics of the calls to a billing system.
+type Stats struct {
+	Name          string `json:"name"`
+	State         string `json:"state"`
+	Requests      int64  `json:"requests"`
+	Retries       int64  `json:"retries"`
+	Failures      int64  `json:"failures"`
+	ShortCircuits int64  `json:"shortCircuits"`
+	Opened        int64  `json:"opened"`
+}
+
+// circuit is a circuit breaker of the calls to a single billing system. It's
+// opened after a number of consecutive failures and the calls are rejected
+// until the billing system is checked by a single call.
+type circuit struct {
+	mu       sync.Mutex
+	state    state
+	failures int
+	openedAt time.Time
+	probing  bool
+	stats    Stats
+}
+
+var (
+	circuitsMu sync.Mutex
+	circuits   = make(map[string]*circuit)
+)
+
+// circuitOf returns the circuit with the provided name. The circuits are kept
+// for the lifetime of the process, so they are shared between the clients.
+func circuitOf(name string) *circuit {
+	circuitsMu.Lock()
+	defer circuitsMu.Unlock()
+
+	c, ok := circuits[name]
+	if !ok {
+		c = &circuit{stats: Stats{Name: name}}
+		circuits[name] = c
+	}
+	return c
+}
+
+// Metrics returns the metrics of the calls to the billing systems sorted by
+// their names.
+func Metrics() []Stats {
+	circuitsMu.Lock()
+	defer circuitsMu.Unlock()
+
+	metrics := make([]Stats, 0, len(circuits))
+	for _, c := range circuits {
+		c.mu.Lock()
+		stats := c.stats
+		stats.State = c.state.String()
+		c.mu.Unlock()
+		metrics = append(metrics, stats)
+	}
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	return metrics
+}
+
+// allow reports whether a call could be made. The first call after the
+// OpenTimeout of an open circuit is let through as a probe.
+func (c *circuit) allow(conf Config, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case open:
+		if now.Sub(c.openedAt) < conf.OpenTimeout {
+			c.stats.ShortCircuits++
+			return false
+		}
+		c.state = halfOpen
+	case halfOpen:
+		if c.probing {
+			c.stats.ShortCircuits++
+			return false
+		}
+	}
+	c.probing = c.state == halfOpen
+	c.stats.Requests++
+	return true
+}
+
+// done records the outcome of an allowed call.
+func (c *circuit) done(conf Config, failed bool, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.probing = false
+	if !failed {
+		if c.state != closed {
+			log.Printf("circuit of %s is closed", c.stats.Name)
+		}
+		c.state = closed
+		c.failures = 0
+		return
+	}
+
+	c.stats.Failures++
+	c.failures++
+	if c.state == halfOpen || (c.state == closed && c.failures >= conf.FailureThreshold) {
+		log.Printf("circuit of %s is open after %d failures", c.stats.Name, c.failures)
+		c.state = open
+		c.openedAt = now
+		c.stats.Opened++
+	}
+}
+
+// cancel releases an allowed call which outcome is unknown as it was
+// cancelled by the caller.
+func (c *circuit) cancel() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+}
+
+func (c *circuit) retried() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Retries++
+}
@@ -0,0 +1,105 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// DefaultConfig is the configuration used for the settings which are
+// not present in the environment.
+var DefaultConfig = Config{
+	Timeout:          15 * time.Second,
+	MaxRetries:       2,
+	RetryWait:        200 * time.Millisecond,
+	FailureThreshold: 5,
+	OpenTimeout:      30 * time.Second,
+}
+
+// Config is the configuration of the HTTP calls to a billing system.
+type Config struct {
+	// Timeout bounds the whole call to the billing system including
+	// the retries of it
+	Timeout time.Duration
+
+	// MaxRetries is the number of times a failed idempotent request is
+	// retried. Zero disables the retries.
+	MaxRetries int
+
+	// RetryWait is the base wait before the first retry. It's doubled on
+	// every next retry and a random jitter is applied.
+	RetryWait time.Duration
+
+	// FailureThreshold is the number of consecutive failed calls after which
+	// the circuit is opened and the calls are short-circuited
+	FailureThreshold int
+
+	// OpenTimeout is the time after which an open circuit lets a single
+	// call through to check whether the billing system is back
+	OpenTimeout time.Duration
+}
+
+// ConfigFromEnvironment parses the configuration kept in the metadata of the
+// environment. The durations are in the format of time.ParseDuration, e.g 10s.
+func ConfigFromEnvironment(env epay.Environment) (Config, error) {
+	m := env.Metadata
+	c := DefaultConfig
+
+	durations := map[string]*time.Duration{
+		"billingTimeout":        &c.Timeout,
+		"billingRetryWait":      &c.RetryWait,
+		"billingBreakerTimeout": &c.OpenTimeout,
+	}
+	for key, d := range durations {
+		if m[key] == "" {
+			continue
+		}
+		v, err := time.ParseDuration(m[key])
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s '%s'", key, m[key])
+		}
+		*d = v
+	}
+
+	ints := map[string]*int{
+		"billingRetries":          &c.MaxRetries,
+		"billingBreakerThreshold": &c.FailureThreshold,
+	}
+	for key, n := range ints {
+		if m[key] == "" {
+			continue
+		}
+		v, err := strconv.Atoi(m[key])
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s '%s'", key, m[key])
+		}
+		*n = v
+	}
+
+	if err := c.Validate(); err != nil {
+		return Config{}, err
+	}
+	return c, nil
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.Timeout <= 0 {
+		return fmt.Errorf("billingTimeout must be positive")
+	}
+	if c.MaxRetries < 0 || c.MaxRetries > 10 {
+		return fmt.Errorf("billingRetries must be between 0 and 10")
+	}
+	if c.RetryWait < 0 {
+		return fmt.Errorf("billingRetryWait must not be negative")
+	}
+	if c.FailureThreshold < 1 {
+		return fmt.Errorf("billingBreakerThreshold must be positive")
+	}
+	if c.OpenTimeout <= 0 {
+		return fmt.Errorf("billingBreakerTimeout must be positive")
+	}
+	return nil
+}
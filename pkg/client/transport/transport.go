@@ -0,0 +1,130 @@
+// Package transport provides the HTTP transport which is shared by the clients
+// of the billing systems. It bounds the calls by a timeout, retries the failed
+// idempotent requests and short-circuits the calls to billing systems which
+// are down.
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// NewClient creates a HTTP client for the billing system with the provided
+// name. The circuits are kept per name and host of the billing system, so
+// they are shared by the clients created for the different requests.
+func NewClient(name string, conf Config) *http.Client {
+	return &http.Client{
+		Timeout:   conf.Timeout,
+		Transport: &Transport{Name: name, Config: conf},
+	}
+}
+
+// Transport is a http.RoundTripper which retries the GET requests failed with
+// a connection error or a 5xx response and short-circuits the requests with
+// epay.ErrBillingUnavailable while the circuit of the billing system is open.
+// Other requests are never retried as they could be already processed.
+type Transport struct {
+	// Name is the name of the billing system
+	Name string
+
+	Config Config
+
+	// Base is the transport which makes the requests. http.DefaultTransport
+	// is used when it's not set.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c := circuitOf(t.Name + ":" + req.URL.Host)
+	if !c.allow(t.Config, time.Now()) {
+		return nil, fmt.Errorf("%w: circuit of %s is open", epay.ErrBillingUnavailable, c.stats.Name)
+	}
+
+	retries := 0
+	if idempotent(req) {
+		retries = t.Config.MaxRetries
+	}
+
+	ctx := req.Context()
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		resp, err = t.base().RoundTrip(req)
+		if attempt >= retries || !failed(resp, err) || ctx.Err() != nil {
+			break
+		}
+		discard(resp)
+		if werr := wait(ctx, t.backoff(attempt)); werr != nil {
+			resp, err = nil, werr
+			break
+		}
+		c.retried()
+	}
+
+	if ctx.Err() == context.Canceled {
+		// the caller is gone, so nothing is known about the billing system
+		c.cancel()
+		return resp, err
+	}
+	c.done(t.Config, failed(resp, err), time.Now())
+	return resp, err
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+// backoff returns the wait before the retry. The wait is doubled on every
+// retry and a random jitter of up to the half of it is applied, so the
+// retries of the concurrent requests are spread.
+func (t *Transport) backoff(attempt int) time.Duration {
+	d := t.Config.RetryWait << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// idempotent reports whether the request could be safely sent again.
+func idempotent(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+// failed reports whether the call failed due to the billing system.
+func failed(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+func discard(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+}
+
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,182 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+var testConfig = Config{
+	Timeout:          time.Second,
+	MaxRetries:       2,
+	RetryWait:        time.Millisecond,
+	FailureThreshold: 2,
+	OpenTimeout:      50 * time.Millisecond,
+}
+
+func TestRetryIdempotentRequests(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	resp, err := NewClient("retry", testConfig).Get(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || calls != 3 {
+		t.Errorf("expected: 200 after 3 calls")
+		t.Errorf("     got: %d after %d calls", resp.StatusCode, calls)
+	}
+	if got := statsOf("retry:" + ts.Listener.Addr().String()); got.Retries != 2 || got.Failures != 0 {
+		t.Errorf("unexpected stats: %+v", got)
+	}
+}
+
+func TestNotRetryOtherRequests(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	resp, err := NewClient("no-retry", testConfig).Post(ts.URL, "application/json", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || calls != 1 {
+		t.Errorf("expected: 503 after 1 call")
+		t.Errorf("     got: %d after %d calls", resp.StatusCode, calls)
+	}
+}
+
+func TestOpenCircuitOfFailingBillingSystem(t *testing.T) {
+	var down int32 = 1
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	conf := testConfig
+	conf.MaxRetries = 0
+	client := NewClient("breaker", conf)
+
+	for i := 0; i < conf.FailureThreshold; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	if _, err := client.Get(ts.URL); !errors.Is(err, epay.ErrBillingUnavailable) {
+		t.Errorf("expected: %v", epay.ErrBillingUnavailable)
+		t.Errorf("     got: %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected open circuit to short-circuit the call, but got %d calls", calls)
+	}
+
+	// the circuit is closed once the billing system is back
+	atomic.StoreInt32(&down, 0)
+	time.Sleep(conf.OpenTimeout)
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	got := statsOf("breaker:" + ts.Listener.Addr().String())
+	want := Stats{Name: got.Name, State: "closed", Requests: 4, Failures: 2, ShortCircuits: 1, Opened: 1}
+	if got != want {
+		t.Errorf("expected: %+v", want)
+		t.Errorf("     got: %+v", got)
+	}
+}
+
+func TestReopenCircuitOnFailedProbe(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	conf := testConfig
+	conf.MaxRetries = 0
+	conf.FailureThreshold = 1
+	client := NewClient("probe", conf)
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+		time.Sleep(conf.OpenTimeout)
+	}
+
+	if got := statsOf("probe:" + ts.Listener.Addr().String()); got.State != "open" || got.Opened != 2 {
+		t.Errorf("unexpected stats: %+v", got)
+	}
+}
+
+func TestConfigFromEnvironment(t *testing.T) {
+	conf, err := ConfigFromEnvironment(epay.Environment{Metadata: map[string]string{
+		"billingTimeout": "5s",
+		"billingRetries": "0",
+	}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := DefaultConfig
+	want.Timeout = 5 * time.Second
+	want.MaxRetries = 0
+	if conf != want {
+		t.Errorf("expected: %+v", want)
+		t.Errorf("     got: %+v", conf)
+	}
+
+	invalid := []map[string]string{
+		{"billingTimeout": "5"},
+		{"billingTimeout": "0s"},
+		{"billingRetries": "many"},
+		{"billingRetries": "-1"},
+		{"billingBreakerThreshold": "0"},
+	}
+	for _, m := range invalid {
+		if _, err := ConfigFromEnvironment(epay.Environment{Metadata: m}); err == nil {
+			t.Errorf("expected config %v to be rejected", m)
+		}
+	}
+}
+
+func statsOf(name string) Stats {
+	for _, s := range Metrics() {
+		if s.Name == name {
+			return s
+		}
+	}
+	return Stats{}
+}
@@ -6,42 +6,117 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/clouway/go-epay/pkg/client/order"
 	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/number"
+)
+
+const (
+	paymentsPageSize = 100
+	invoicesPageSize = 100
+
+	// backend is the name of the billing system in the errors
+	backend = "ucrm"
+)
+
+// InvoiceStrategy defines how payments are assigned to the invoices of the client.
+type InvoiceStrategy string
+
+const (
+	// InvoiceStrategyOldestFirst assigns the payment to the invoices recorded
+	// in the payment order starting from the oldest one. Overpayments are
+	// applied automatically to the other unpaid invoices of the client.
+	InvoiceStrategyOldestFirst InvoiceStrategy = "oldest-first"
+
+	// InvoiceStrategyRecorded assigns the payment only to the invoices recorded
+	// in the payment order. Overpayments are added as credit.
+	InvoiceStrategyRecorded InvoiceStrategy = "recorded"
+
+	// InvoiceStrategyCredit adds the payment as credit of the client
+	// without assigning it to any invoice.
+	InvoiceStrategyCredit InvoiceStrategy = "credit"
+)
+
+const (
+	// PaymentTimeConfirmed uses the time of the payment confirmation as
+	// provider payment time.
+	PaymentTimeConfirmed = "confirmed"
+
+	// PaymentTimeCreated uses the time of the payment order creation as
+	// provider payment time.
+	PaymentTimeCreated = "created"
 )
 
 // PaymentProvider is keeping the configured payment provider in UCRM.
 type PaymentProvider struct {
-	MethodID       string
-	Name           string
-	PaymentID      string
-	PaymentTime    string
+	MethodID string
+	Name     string
+
+	// PaymentID is the template of the providerPaymentId of the payments,
+	// e.g. EPAY-{TID}-{IDN}. The transaction ID is used when it's not set.
+	PaymentID string
+
+	// PaymentTime is the source of the providerPaymentTime of the payments,
+	// PaymentTimeConfirmed or PaymentTimeCreated. PaymentTimeConfirmed is
+	// used when it's not set.
+	PaymentTime string
+
 	OrganizationID string
+
+	// Note is the template of the note of the payments.
+	Note string
+
+	// Currency is the currency code of the payments. The paid amounts are
+	// converted to it when it's set, otherwise they are booked in the
+	// currency of the invoices.
+	Currency string
+
+	// InvoiceStrategy is the strategy used for assigning of payments to
+	// invoices. InvoiceStrategyOldestFirst is used when it's not set.
+	InvoiceStrategy InvoiceStrategy
 }
 
 // NewClient creates a new client that uses the provided app key and baseURL.
 func NewClient(baseURL *url.URL, appKey string, poStore epay.PaymentOrderStore, paymentProvider PaymentProvider) epay.Client {
-	return &client{BaseURL: baseURL, AppKey: appKey, poStore: poStore, paymentProvider: paymentProvider}
+	return newClient(&client{BaseURL: baseURL, AppKey: appKey, paymentProvider: paymentProvider, httpClient: http.DefaultClient}, poStore)
+}
+
+// NewClientFromConfig creates a new client by using the provided configuration.
+func NewClientFromConfig(conf Config, poStore epay.PaymentOrderStore) epay.Client {
+	httpClient := conf.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return newClient(&client{BaseURL: conf.BillingURL, AppKey: conf.APIKey, paymentProvider: conf.Provider, httpClient: httpClient}, poStore)
+}
+
+func newClient(c *client, poStore epay.PaymentOrderStore) *client {
+	c.orders = &order.Orders{Store: poStore, Find: c.findPaidAmount, Book: c.book}
+	return c
 }
 
 type client struct {
 	BaseURL         *url.URL
 	AppKey          string
-	poStore         epay.PaymentOrderStore
+	orders          *order.Orders
 	paymentProvider PaymentProvider
+	httpClient      *http.Client
 }
 
 // GetSubscriberDuties gets current subscriber duties.
 func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (*epay.SubscriberDuties, error) {
 	clientRef, err := c.findClientID(ctx, subscriberID)
 	if err != nil {
-		return nil, epay.ErrSubscriberNotFound
+		return nil, err
 	}
 
 	clientID := strconv.Itoa(clientRef.ID)
@@ -50,144 +125,302 @@ func (c *client) GetSubscriberDuties(ctx context.Context, subscriberID string) (
 		customerName = clientRef.CompanyName
 	}
 
-	params := url.Values{}
-	params.Add("clientId", clientID)
-	params.Add("statuses[0]", "1")
-	params.Add("statuses[1]", "2")
-	params.Add("limit", "100")
-
-	req, err := c.newRequest(ctx, "GET", "/api/v1.0/invoices", params)
-	if err != nil {
-		return nil, fmt.Errorf("could not create request due: %v", err)
-	}
-	var duties []invoice
-	resp, err := c.do(req, &duties)
+	duties, err := c.getUnpaidInvoices(ctx, clientID)
 	if err != nil {
-		return nil, fmt.Errorf("could not process get subscriber duties request due: %v", err)
+		return nil, err
 	}
 
-	if resp.StatusCode == http.StatusOK {
-		dutyAmount := 0.0
-		documentIDs := make([]string, 0)
-		items := make([]epay.Item, 0)
-		for _, duty := range duties {
-			dutyAmount += duty.Total - duty.AmountPaid
-			documentID := strconv.Itoa(duty.ID)
-			documentIDs = append(documentIDs, documentID)
+	var dutyAmount epay.Money
+	var dueDate time.Time
+	// the taxes are retrieved only for invoices with taxed items
+	var taxRates map[int]float64
+	documentIDs := make([]string, 0)
+	items := make([]epay.Item, 0)
+	for _, duty := range duties {
+		unpaid, err := duty.unpaidAmount()
+		if err != nil {
+			return nil, fmt.Errorf("could not get amount of invoice %d due: %w", duty.ID, err)
+		}
+		if dutyAmount, err = dutyAmount.Add(unpaid); err != nil {
+			return nil, fmt.Errorf("could not sum invoices of client %s due: %w", clientID, err)
+		}
+		documentID := strconv.Itoa(duty.ID)
+		documentIDs = append(documentIDs, documentID)
+		// the earliest due date is the one which has to be met
+		if !duty.DueDate.IsZero() && (dueDate.IsZero() || duty.DueDate.Before(dueDate)) {
+			dueDate = duty.DueDate.Time
+		}
 
-			for _, item := range duty.Items {
-				items = append(items, epay.Item{Name: item.Label})
+		for _, item := range duty.Items {
+			if taxRates == nil && len(item.taxIDs()) > 0 {
+				if taxRates, err = c.getTaxRates(ctx); err != nil {
+					return nil, err
+				}
+			}
+			item, err := newItem(duty, item, taxRates)
+			if err != nil {
+				return nil, fmt.Errorf("could not get item of invoice %d due: %w", duty.ID, err)
 			}
+			items = append(items, item)
 		}
-
-		amount := fmt.Sprintf("%.2f", dutyAmount)
-		return &epay.SubscriberDuties{
-			CustomerName: customerName,
-			CustomerRef:  clientID,
-			DutyAmount:   epay.Amount{Value: amount},
-			DocumentIDs:  documentIDs,
-			Items:        items,
-		}, nil
-	}
-	if resp.StatusCode == http.StatusNotFound {
-		return nil, epay.ErrSubscriberNotFound
 	}
 
-	return nil, fmt.Errorf("unknown response during retrieving of subscriber duties: %v", err)
+	return &epay.SubscriberDuties{
+		CustomerName: customerName,
+		CustomerRef:  clientID,
+		DutyAmount:   dutyAmount.Amount(),
+		DueDate:      dueDate,
+		DocumentIDs:  documentIDs,
+		Items:        items,
+	}, nil
 }
 
-func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePaymentOrderRequest) (*epay.PaymentOrder, error) {
-	contextLogger := log.WithContext(ctx)
+// getUnpaidInvoices gets all unpaid and partially paid invoices of the client
+// by retrieving them page by page.
+func (c *client) getUnpaidInvoices(ctx context.Context, clientID string) ([]invoice, error) {
+	var invoices []invoice
+	for offset := 0; ; offset += invoicesPageSize {
+		params := url.Values{}
+		params.Add("clientId", clientID)
+		params.Add("statuses[0]", "1")
+		params.Add("statuses[1]", "2")
+		params.Add("limit", strconv.Itoa(invoicesPageSize))
+		params.Add("offset", strconv.Itoa(offset))
+
+		req, err := c.newRequest(ctx, "GET", "/api/v1.0/invoices", params)
+		if err != nil {
+			return nil, fmt.Errorf("could not create request due: %v", err)
+		}
+		var page []invoice
+		resp, err := c.do(req, &page)
+		if err != nil {
+			return nil, fmt.Errorf("could not process get subscriber duties request due: %w", err)
+		}
 
-	duties, err := c.GetSubscriberDuties(ctx, createReq.SubscriberID)
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil, epay.ErrSubscriberNotFound
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, epay.ResponseError(backend, resp)
+		}
+
+		invoices = append(invoices, page...)
+		if len(page) < invoicesPageSize {
+			return invoices, nil
+		}
+	}
+}
+
+// getTaxRates gets the rates of the taxes in UCRM by their ID.
+func (c *client) getTaxRates(ctx context.Context) (map[int]float64, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/v1.0/taxes", nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not create request due: %v", err)
+	}
+	var taxes []tax
+	resp, err := c.do(req, &taxes)
+	if err != nil {
+		return nil, fmt.Errorf("could not process get taxes request due: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, epay.ResponseError(backend, resp)
 	}
 
-	po := &epay.PaymentOrderRecord{
-		CustomerName:  duties.CustomerName,
-		ClientID:      duties.CustomerRef,
-		TransactionID: createReq.TransactionID,
-		SubscriberID:  createReq.SubscriberID,
-		Amount:        duties.DutyAmount.Value,
-		CreatedAt:     time.Now(),
-		InvoiceIDs:    duties.DocumentIDs,
+	rates := make(map[int]float64, len(taxes))
+	for _, t := range taxes {
+		rates[t.ID] = t.Rate
 	}
+	return rates, nil
+}
 
-	if err := c.poStore.Put(ctx, po); err != nil {
-		contextLogger.Printf("got error: %v", err)
-		return nil, epay.ErrUnknown
+// newItem maps the item of an UCRM invoice to epay.Item. The VAT of the item is
+// the sum of the rates of its taxes. UCRM is not providing the period of the
+// separate items, so their dates are left empty.
+func newItem(inv invoice, item invoiceItem, taxRates map[int]float64) (epay.Item, error) {
+	// items without total are listed with zero amount
+	amount := epay.NewMoney(0, inv.CurrencyCode)
+	if item.Total != "" {
+		var err error
+		if amount, err = epay.RoundMoney(item.Total.String(), inv.CurrencyCode); err != nil {
+			return epay.Item{}, err
+		}
+	}
+	price := epay.NewMoney(0, inv.CurrencyCode)
+	if item.Price != "" {
+		var err error
+		if price, err = epay.RoundMoney(item.Price.String(), inv.CurrencyCode); err != nil {
+			return epay.Item{}, err
+		}
 	}
 
-	return &epay.PaymentOrder{
-		ID:            po.TransactionID,
-		CustomerName:  po.CustomerName,
-		TransactionID: po.TransactionID,
-		Amount:        epay.Amount{Value: po.Amount},
-		Created:       po.CreatedAt,
-		Items:         duties.Items,
+	vat := 0.0
+	for _, id := range item.taxIDs() {
+		rate, ok := taxRates[id]
+		if !ok {
+			return epay.Item{}, fmt.Errorf("unknown tax %d of item '%s'", id, item.Label)
+		}
+		vat += rate
+	}
+
+	return epay.Item{
+		Name:     item.Label,
+		Amount:   amount.Amount(),
+		Vat:      number.Round(vat, 2),
+		Price:    price.String(),
+		Quantity: int(math.Round(item.Quantity)),
 	}, nil
 }
 
-func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
-	po, err := c.poStore.Get(ctx, orderKey)
+func (c *client) CreatePaymentOrder(ctx context.Context, createReq epay.CreatePaymentOrderRequest) (*epay.PaymentOrder, error) {
+	duties, err := c.GetSubscriberDuties(ctx, createReq.SubscriberID)
 	if err != nil {
 		return nil, err
 	}
 
-	return &epay.PaymentOrder{
-		ID:            po.TransactionID,
-		CustomerName:  po.CustomerName,
-		TransactionID: po.TransactionID,
-		Amount:        epay.Amount{Value: po.Amount},
-		Created:       po.CreatedAt,
-	}, nil
+	po, err := order.NewRecord(createReq, duties)
+	if err != nil {
+		return nil, err
+	}
+	return c.orders.Create(ctx, po, duties.Items)
+}
+
+func (c *client) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
+	return c.orders.Get(ctx, orderKey)
 }
 
-func (c *client) PayPaymentOrder(ctx context.Context, orderID string) (*epay.PayPaymentOrderResponse, error) {
-	po, err := c.poStore.Get(ctx, orderID)
+// PayPaymentOrder books the payment of the order in UCRM. The payments which were
+// booked by previous confirmations are recognized by their providerPaymentId.
+func (c *client) PayPaymentOrder(ctx context.Context, payReq epay.PayPaymentOrderRequest) (*epay.PayPaymentOrderResponse, error) {
+	return c.orders.Pay(ctx, payReq)
+}
+
+// book books the payment of the claimed order in UCRM.
+func (c *client) book(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	// The paid amount is in the currency of the invoices, so it's converted
+	// when the payments are booked in another currency.
+	booked, err := po.PaidAmount.Convert(c.paymentProvider.Currency)
 	if err != nil {
-		return nil, epay.ErrPaymentOrderNotFound
+		return err
 	}
 
 	clientID, _ := strconv.Atoi(po.ClientID)
-	amount, _ := strconv.ParseFloat(po.Amount, 64)
+	now := time.Now()
+	paymentTime := now
+	if c.paymentProvider.PaymentTime == PaymentTimeCreated && !po.CreatedAt.IsZero() {
+		paymentTime = po.CreatedAt
+	}
 	paymentReq := &paymentRequest{
-		ClientID:          clientID,
-		MethodID:          c.paymentProvider.MethodID,
-		Amount:            amount,
-		ProviderName:      c.paymentProvider.Name,
-		ProviderPaymentID: po.TransactionID,
+		ClientID:            clientID,
+		MethodID:            c.paymentProvider.MethodID,
+		CreatedDate:         jsonDateTime{now},
+		Amount:              json.Number(booked.String()),
+		CurrencyCode:        booked.Currency(),
+		Note:                c.expand(c.paymentProvider.Note, po),
+		ProviderName:        c.paymentProvider.Name,
+		ProviderPaymentID:   c.providerPaymentID(po),
+		ProviderPaymentTime: jsonDateTime{paymentTime},
 	}
+	assignInvoices(paymentReq, c.paymentProvider.InvoiceStrategy, po.InvoiceIDs)
 
 	req, err := c.newRequest(ctx, "POST", "/api/v1.0/payments", paymentReq)
 	if err != nil {
-		return nil, fmt.Errorf("could not create request due: %v", err)
+		return fmt.Errorf("could not create request due: %v", err)
 	}
 
 	r := &paymentResponse{}
 	resp, err := c.do(req, &r)
 	if err != nil {
-		return nil, fmt.Errorf("could not process payment request due: %v", err)
+		return order.Uncertain(fmt.Errorf("could not process payment request due: %w", err))
 	}
-
 	if resp.StatusCode != http.StatusCreated {
-		return nil, epay.ErrUnknown
+		return epay.ResponseError(backend, resp)
 	}
+	return nil
+}
 
-	po.ProcessedOn = time.Now()
-	if err := c.poStore.Put(ctx, po); err != nil {
-		log.Printf("got error: %v", err)
-		return nil, epay.ErrUnknown
+// findPaidAmount finds the amount of the payment which was booked for the order.
+func (c *client) findPaidAmount(ctx context.Context, po *epay.PaymentOrderRecord) (*epay.Money, error) {
+	payment, err := c.findPayment(ctx, po)
+	if err != nil || payment == nil {
+		return nil, err
 	}
+	log.WithContext(ctx).Debugf("payment order '%s' was booked as payment %d", po.TransactionID, payment.ID)
 
-	return &epay.PayPaymentOrderResponse{
-		ID:            orderID,
-		TransactionID: po.TransactionID,
-		Amount:        epay.Amount{Value: po.Amount},
-		Created:       po.CreatedAt,
-		PaidOn:        po.ProcessedOn,
-	}, nil
+	paid, err := payment.paidAmount(po.Amount.Currency())
+	if err != nil {
+		// the payment is booked anyway, so the order is considered paid in full
+		paid = po.PaidAmount
+	}
+	return &paid, nil
+}
+
+// providerPaymentID returns the providerPaymentId of the payment of
+// the provided payment order.
+func (c *client) providerPaymentID(po *epay.PaymentOrderRecord) string {
+	if c.paymentProvider.PaymentID == "" {
+		return po.TransactionID
+	}
+	return c.expand(c.paymentProvider.PaymentID, po)
+}
+
+// findPayment finds the payment booked for the provided payment order using
+// its providerPaymentId. It returns nil if no such payment exists.
+func (c *client) findPayment(ctx context.Context, po *epay.PaymentOrderRecord) (*paymentResponse, error) {
+	for offset := 0; ; offset += paymentsPageSize {
+		params := url.Values{}
+		params.Add("clientId", po.ClientID)
+		params.Add("createdDateFrom", po.CreatedAt.Format("2006-01-02"))
+		params.Add("limit", strconv.Itoa(paymentsPageSize))
+		params.Add("offset", strconv.Itoa(offset))
+
+		req, err := c.newRequest(ctx, "GET", "/api/v1.0/payments", params)
+		if err != nil {
+			return nil, err
+		}
+
+		var payments []paymentResponse
+		resp, err := c.do(req, &payments)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, epay.ResponseError(backend, resp)
+		}
+
+		for i := range payments {
+			if payments[i].ProviderPaymentID == c.providerPaymentID(po) {
+				return &payments[i], nil
+			}
+		}
+
+		if len(payments) < paymentsPageSize {
+			return nil, nil
+		}
+	}
+}
+
+// assignInvoices assigns the payment to the provided invoices using the strategy.
+func assignInvoices(paymentReq *paymentRequest, strategy InvoiceStrategy, invoiceIDs []string) {
+	ids := make([]int, 0, len(invoiceIDs))
+	for _, v := range invoiceIDs {
+		if id, err := strconv.Atoi(v); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	switch strategy {
+	case InvoiceStrategyCredit:
+		paymentReq.ApplyToInvoicesAutomatically = false
+	case InvoiceStrategyRecorded:
+		paymentReq.InvoiceIDs = ids
+		paymentReq.ApplyToInvoicesAutomatically = false
+	default:
+		// invoice IDs are increasing in UCRM, so older invoices are with lower IDs
+		sort.Ints(ids)
+		paymentReq.InvoiceIDs = ids
+		paymentReq.ApplyToInvoicesAutomatically = true
+	}
 }
 
 func (c *client) findClientID(ctx context.Context, subscriberID string) (*clientRef, error) {
@@ -226,13 +459,13 @@ func (c *client) findClientID(ctx context.Context, subscriberID string) (*client
 	var clients []clientRef
 	resp, err := c.do(req, &clients)
 	if err != nil {
-		return nil, fmt.Errorf("could not process get subscriber duties request due: %v", err)
+		return nil, fmt.Errorf("could not process get subscriber duties request due: %w", err)
 	}
 
 	contextLogger.Infof("UCRM client search response: status=%d, clients found=%d", resp.StatusCode, len(clients))
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got bad response due: %v", err)
+		return nil, epay.ResponseError(backend, resp)
 	}
 	if len(clients) == 0 {
 		return nil, epay.ErrSubscriberNotFound
@@ -276,9 +509,9 @@ func (c *client) newRequest(ctx context.Context, method, path string, body inter
 }
 
 func (c *client) do(req *http.Request, v interface{}) (*http.Response, error) {
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, epay.RequestError(backend, err)
 	}
 	if resp.StatusCode >= 300 {
 		return resp, nil
@@ -297,8 +530,11 @@ type clientRef struct {
 
 type invoice struct {
 	ID                int           `json:"id"`
-	Total             float64       `json:"total"`
-	AmountPaid        float64       `json:"amountPaid"`
+	Total             json.Number   `json:"total"`
+	AmountPaid        json.Number   `json:"amountPaid"`
+	CurrencyCode      string        `json:"currencyCode"`
+	CreatedDate       jsonDateTime  `json:"createdDate"`
+	DueDate           jsonDateTime  `json:"dueDate"`
 	ClientFirstName   string        `json:"clientFirstName"`
 	ClientLastName    string        `json:"clientLastName"`
 	ClientCompanyName string        `json:"clientCompanyName"`
@@ -306,17 +542,80 @@ type invoice struct {
 }
 
 type invoiceItem struct {
-	Label string `json:"label"`
+	Label    string      `json:"label"`
+	Price    json.Number `json:"price"`
+	Quantity float64     `json:"quantity"`
+	Total    json.Number `json:"total"`
+	Tax1ID   *int        `json:"tax1Id"`
+	Tax2ID   *int        `json:"tax2Id"`
+	Tax3ID   *int        `json:"tax3Id"`
+}
+
+// taxIDs returns the IDs of the taxes of the item.
+func (item invoiceItem) taxIDs() []int {
+	var ids []int
+	for _, id := range []*int{item.Tax1ID, item.Tax2ID, item.Tax3ID} {
+		if id != nil {
+			ids = append(ids, *id)
+		}
+	}
+	return ids
+}
+
+type tax struct {
+	ID   int     `json:"id"`
+	Rate float64 `json:"rate"`
+}
+
+// unpaidAmount returns the amount of the invoice which remains to be paid. UCRM
+// is serializing the amounts as floats, so they are rounded to the minor units
+// of the currency.
+func (inv invoice) unpaidAmount() (epay.Money, error) {
+	total, err := epay.RoundMoney(inv.Total.String(), inv.CurrencyCode)
+	if err != nil {
+		return epay.Money{}, err
+	}
+	if inv.AmountPaid == "" {
+		return total, nil
+	}
+	paid, err := epay.RoundMoney(inv.AmountPaid.String(), inv.CurrencyCode)
+	if err != nil {
+		return epay.Money{}, err
+	}
+	return total.Sub(paid)
 }
 
 type paymentRequest struct {
-	ClientID          int     `json:"clientId"`
-	MethodID          string  `json:"methodId"`
-	Amount            float64 `json:"amount"`
-	ProviderName      string  `json:"providerName"`
-	ProviderPaymentID string  `json:"providerPaymentId"`
+	ClientID                     int          `json:"clientId"`
+	MethodID                     string       `json:"methodId"`
+	CreatedDate                  jsonDateTime `json:"createdDate"`
+	Amount                       json.Number  `json:"amount"`
+	CurrencyCode                 string       `json:"currencyCode,omitempty"`
+	Note                         string       `json:"note,omitempty"`
+	ProviderName                 string       `json:"providerName"`
+	ProviderPaymentID            string       `json:"providerPaymentId"`
+	ProviderPaymentTime          jsonDateTime `json:"providerPaymentTime"`
+	InvoiceIDs                   []int        `json:"invoiceIds,omitempty"`
+	ApplyToInvoicesAutomatically bool         `json:"applyToInvoicesAutomatically"`
 }
 
 type paymentResponse struct {
-	ID int `json:"id"`
+	ID                int         `json:"id"`
+	Amount            json.Number `json:"amount"`
+	CurrencyCode      string      `json:"currencyCode"`
+	ProviderPaymentID string      `json:"providerPaymentId"`
+}
+
+// paidAmount returns the amount of the payment in the provided currency of the
+// invoices. Payments without currency are considered to be in that currency.
+func (p *paymentResponse) paidAmount(currency string) (epay.Money, error) {
+	bookedIn := p.CurrencyCode
+	if bookedIn == "" {
+		bookedIn = currency
+	}
+	paid, err := epay.RoundMoney(p.Amount.String(), bookedIn)
+	if err != nil {
+		return epay.Money{}, err
+	}
+	return paid.Convert(currency)
 }
@@ -3,12 +3,16 @@ package ucrm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/clouway/go-epay/pkg/client/order"
 	"github.com/clouway/go-epay/pkg/epay"
 )
 
@@ -25,7 +29,7 @@ func TestSusbscriberNotFound(t *testing.T) {
 
 	client := NewClient(baseURL, "testing-key", nil, PaymentProvider{})
 	_, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
-	if err != epay.ErrSubscriberNotFound {
+	if !errors.Is(err, epay.ErrSubscriberNotFound) {
 		t.Fatalf("expected subscriber not found but got: %v", err)
 	}
 }
@@ -49,17 +53,28 @@ func TestResidentialSubscriberDuties(t *testing.T) {
 			   "id": 101,           
 			   "total":20.0,
 			   "amountPaid": 0.0,
+			   "currencyCode": "BGN",
+			   "createdDate": "2020-04-01T00:00:00+0000",
+			   "dueDate": "2020-04-15T00:00:00+0000",
 			   "clientFirstName": "John",
 			   "clientLastName": "Smith",
 			   "items":[
 					{
-						"label":"service 1 04/2020"
+						"label":"service 1 04/2020",
+						"price": 8.335,
+						"quantity": 2,
+						"total": 20.0,
+						"tax1Id": 1,
+						"tax2Id": 3
 					}
 				]
 			}
 		 ]`
 		w.Write([]byte(content))
 	}))
+	mux.HandleFunc("/api/v1.0/taxes", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonReply(w, []tax{{ID: 1, Rate: 20}, {ID: 2, Rate: 9}, {ID: 3, Rate: 0.5}})
+	}))
 
 	ts := httptest.NewServer(mux)
 	defer ts.Close()
@@ -75,9 +90,18 @@ func TestResidentialSubscriberDuties(t *testing.T) {
 	serverResponse := &epay.SubscriberDuties{
 		CustomerName: "John Smith",
 		CustomerRef:  "708",
-		DutyAmount:   epay.Amount{Value: "20.00"},
-		Items:        []epay.Item{epay.Item{Name: "service 1 04/2020"}},
-		DocumentIDs:  []string{"101"},
+		DutyAmount:   epay.Amount{Value: "20.00", Currency: "BGN"},
+		DueDate:      time.Date(2020, 4, 15, 0, 0, 0, 0, time.UTC),
+		Items: []epay.Item{
+			epay.Item{
+				Name:     "service 1 04/2020",
+				Amount:   epay.Amount{Value: "20.00", Currency: "BGN"},
+				Vat:      20.5,
+				Price:    "8.34",
+				Quantity: 2,
+			},
+		},
+		DocumentIDs: []string{"101"},
 	}
 	if !reflect.DeepEqual(resp, serverResponse) {
 		t.Errorf("expected response to be: %v", serverResponse)
@@ -157,9 +181,9 @@ func TestGetDutiesWhenMultipleInvoices(t *testing.T) {
 		DutyAmount:   epay.Amount{Value: "35.43"},
 		DocumentIDs:  []string{"101", "102", "103"},
 		Items: []epay.Item{
-			epay.Item{Name: "service 1 04/2020"},
-			epay.Item{Name: "service 1 05/2020"},
-			epay.Item{Name: "service 1 06/2020"},
+			epay.Item{Name: "service 1 04/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"},
+			epay.Item{Name: "service 1 05/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"},
+			epay.Item{Name: "service 1 06/2020", Amount: epay.Amount{Value: "0.00"}, Price: "0.00"},
 		},
 	}
 
@@ -211,6 +235,305 @@ func TestGetDutiesWhenNoInvoices(t *testing.T) {
 	}
 }
 
+func TestGetDutiesOfAllInvoicePages(t *testing.T) {
+	var offsets []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/clients", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonReply(w, []clientRef{{ID: 708, FirstName: "John", LastName: "Smith"}})
+	}))
+	mux.HandleFunc("/api/v1.0/invoices", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		offsets = append(offsets, r.URL.Query().Get("offset"))
+
+		invoices := make([]invoice, 0)
+		for id := offset; id < invoicesPageSize+1 && id < offset+invoicesPageSize; id++ {
+			invoices = append(invoices, invoice{ID: id, Total: "1.00", CurrencyCode: "EUR"})
+		}
+		jsonReply(w, invoices)
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClient(baseURL, "testing-key", nil, PaymentProvider{})
+	resp, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
+	if err != nil {
+		t.Fatalf("unable to retrieve subscriber duties due: %v", err)
+	}
+
+	if !reflect.DeepEqual(offsets, []string{"0", "100"}) {
+		t.Errorf("expected pages: %v", []string{"0", "100"})
+		t.Errorf("           got: %v", offsets)
+	}
+	want := epay.Amount{Value: "101.00", Currency: "EUR"}
+	if resp.DutyAmount != want || len(resp.DocumentIDs) != invoicesPageSize+1 {
+		t.Errorf("expected: %v for %d invoices", want, invoicesPageSize+1)
+		t.Errorf("     got: %v for %d invoices", resp.DutyAmount, len(resp.DocumentIDs))
+	}
+}
+
+func TestGetDutiesRoundsFloatAmounts(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/clients", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonReply(w, []clientRef{{ID: 708, FirstName: "John", LastName: "Smith"}})
+	}))
+	mux.HandleFunc("/api/v1.0/invoices", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content := `[
+			{
+			   "id": 101,
+			   "total": 0.30000000000000004,
+			   "amountPaid": 0.1,
+			   "currencyCode": "EUR"
+			},
+			{
+			   "id": 102,
+			   "total": 1.5E1,
+			   "amountPaid": 2.5e-1,
+			   "currencyCode": "EUR"
+			}
+		 ]`
+		w.Write([]byte(content))
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	baseURL, _ := url.Parse(ts.URL)
+
+	client := NewClient(baseURL, "testing-key", nil, PaymentProvider{})
+	resp, err := client.GetSubscriberDuties(context.Background(), "::subscriber id::")
+	if err != nil {
+		t.Fatalf("unable to retrieve subscriber duties due: %v", err)
+	}
+
+	want := epay.Amount{Value: "14.95", Currency: "EUR"}
+	if resp.DutyAmount != want {
+		t.Errorf("expected duty amount: %v", want)
+		t.Errorf("                 got: %v", resp.DutyAmount)
+	}
+}
+
+func TestPayPaymentOrderBooksPaidAmount(t *testing.T) {
+	var got paymentRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/payments", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			jsonReply(w, []paymentResponse{})
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusCreated)
+		jsonReply(w, &paymentResponse{ID: 1})
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	baseURL, _ := url.Parse(ts.URL)
+
+	store := order.NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", ClientID: "708", Amount: epay.NewMoney(2000, "BGN")})
+
+	client := NewClient(baseURL, "testing-key", store, PaymentProvider{MethodID: "::method::"})
+	resp, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1", Amount: epay.Amount{Value: "15.50", Currency: "BGN"}})
+	if err != nil {
+		t.Fatalf("unable to pay payment order due: %v", err)
+	}
+
+	if got.Amount != "15.50" || got.ClientID != 708 || got.ProviderPaymentID != "TID1" {
+		t.Errorf("unexpected payment request: %+v", got)
+	}
+	if resp.Amount.Value != "15.50" {
+		t.Errorf("expected paid amount to be 15.50, but got: %s", resp.Amount.Value)
+	}
+
+	po, _ := store.Get(context.Background(), "TID1")
+	if po.PaidAmount != epay.NewMoney(1550, "BGN") || po.Amount != epay.NewMoney(2000, "BGN") {
+		t.Errorf("expected paid amount to be recorded, but got: %+v", po)
+	}
+}
+
+func TestPayPaymentOrderBooksAmountInProviderCurrency(t *testing.T) {
+	cases := []struct {
+		currency     string
+		wantAmount   json.Number
+		wantCurrency string
+	}{
+		{"", "39.12", "BGN"},
+		{"BGN", "39.12", "BGN"},
+		{"EUR", "20.00", "EUR"},
+	}
+
+	for _, c := range cases {
+		var got paymentRequest
+		mux := http.NewServeMux()
+		mux.HandleFunc("/api/v1.0/payments", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				jsonReply(w, []paymentResponse{})
+				return
+			}
+			json.NewDecoder(r.Body).Decode(&got)
+			w.WriteHeader(http.StatusCreated)
+			jsonReply(w, &paymentResponse{ID: 1})
+		}))
+		ts := httptest.NewServer(mux)
+		baseURL, _ := url.Parse(ts.URL)
+
+		store := order.NewFakeStore()
+		store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", ClientID: "708", Amount: epay.NewMoney(3912, "BGN")})
+
+		client := NewClient(baseURL, "testing-key", store, PaymentProvider{MethodID: "::method::", Currency: c.currency})
+		_, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"})
+		ts.Close()
+		if err != nil {
+			t.Fatalf("unable to pay payment order due: %v", err)
+		}
+
+		if got.Amount != c.wantAmount || got.CurrencyCode != c.wantCurrency {
+			t.Errorf("expected payment of %s %s in provider currency '%s', but got: %s %s", c.wantAmount, c.wantCurrency, c.currency, got.Amount, got.CurrencyCode)
+		}
+	}
+}
+
+func TestPayPaymentOrderUsesProviderTemplates(t *testing.T) {
+	var got paymentRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/payments", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			jsonReply(w, []paymentResponse{})
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("unable to decode payment request due: %v", err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		jsonReply(w, &paymentResponse{ID: 1})
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	baseURL, _ := url.Parse(ts.URL)
+
+	createdAt := time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC)
+	store := order.NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", SubscriberID: "1234567", ClientID: "708", Amount: epay.NewMoney(2000, "BGN"), CreatedAt: createdAt})
+
+	client := NewClient(baseURL, "testing-key", store, PaymentProvider{
+		Name:           "ePay",
+		PaymentID:      "EPAY-{TID}-{IDN}",
+		PaymentTime:    PaymentTimeCreated,
+		OrganizationID: "2",
+		Note:           "ePay {TID}/{DATE} org {ORG} client {CLIENT}: {AMOUNT}",
+		Currency:       "BGN",
+	})
+	if _, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); err != nil {
+		t.Fatalf("unable to pay payment order due: %v", err)
+	}
+
+	if want := "EPAY-TID1-1234567"; got.ProviderPaymentID != want {
+		t.Errorf("expected provider payment ID to be: %s, but got: %s", want, got.ProviderPaymentID)
+	}
+	if want := "ePay TID1/20240305 org 2 client 708: 20.00"; got.Note != want {
+		t.Errorf("expected note to be: %s, but got: %s", want, got.Note)
+	}
+	if !got.ProviderPaymentTime.Equal(createdAt) {
+		t.Errorf("expected provider payment time to be: %v, but got: %v", createdAt, got.ProviderPaymentTime)
+	}
+	if got.CreatedDate.IsZero() || got.CurrencyCode != "BGN" || got.ProviderName != "ePay" {
+		t.Errorf("unexpected payment request: %+v", got)
+	}
+}
+
+func TestPayPaymentOrderAssignsInvoices(t *testing.T) {
+	cases := []struct {
+		strategy      InvoiceStrategy
+		wantInvoices  []int
+		wantAutoApply bool
+	}{
+		{"", []int{101, 102, 110}, true},
+		{InvoiceStrategyOldestFirst, []int{101, 102, 110}, true},
+		{InvoiceStrategyRecorded, []int{110, 101, 102}, false},
+		{InvoiceStrategyCredit, nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(string(c.strategy), func(t *testing.T) {
+			var got paymentRequest
+			mux := http.NewServeMux()
+			mux.HandleFunc("/api/v1.0/payments", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					jsonReply(w, []paymentResponse{})
+					return
+				}
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(http.StatusCreated)
+				jsonReply(w, &paymentResponse{ID: 1})
+			}))
+
+			ts := httptest.NewServer(mux)
+			defer ts.Close()
+
+			baseURL, _ := url.Parse(ts.URL)
+
+			store := order.NewFakeStore()
+			store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", ClientID: "708", Amount: epay.NewMoney(2000, "BGN"), InvoiceIDs: []string{"110", "101", "102"}})
+
+			client := NewClient(baseURL, "testing-key", store, PaymentProvider{InvoiceStrategy: c.strategy})
+			if _, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"}); err != nil {
+				t.Fatalf("unable to pay payment order due: %v", err)
+			}
+
+			if !reflect.DeepEqual(got.InvoiceIDs, c.wantInvoices) || got.ApplyToInvoicesAutomatically != c.wantAutoApply {
+				t.Errorf("expected invoices %v with automatic apply: %v", c.wantInvoices, c.wantAutoApply)
+				t.Errorf("                              but got: %v, %v", got.InvoiceIDs, got.ApplyToInvoicesAutomatically)
+			}
+		})
+	}
+}
+
+func TestPayPaymentOrderRecoversBookedPayment(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1.0/payments", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("payment should not be booked twice")
+			return
+		}
+		if got := r.URL.Query().Get("clientId"); got != "708" {
+			t.Errorf("expected payments of client 708, but got: %s", got)
+		}
+		jsonReply(w, []paymentResponse{
+			{ID: 1, Amount: "10", ProviderPaymentID: "TID0"},
+			{ID: 2, Amount: "10.23", CurrencyCode: "EUR", ProviderPaymentID: "TID1"},
+		})
+	}))
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	baseURL, _ := url.Parse(ts.URL)
+
+	store := order.NewFakeStore()
+	store.Put(context.Background(), &epay.PaymentOrderRecord{TransactionID: "TID1", ClientID: "708", Amount: epay.NewMoney(2000, "BGN"), CreatedAt: time.Now()})
+
+	client := NewClient(baseURL, "testing-key", store, PaymentProvider{})
+	_, err := client.PayPaymentOrder(context.Background(), epay.PayPaymentOrderRequest{OrderID: "TID1"})
+	if !errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
+		t.Errorf("expected: %v", epay.ErrPaymentOrderAlreadyPaid)
+		t.Errorf("     got: %v", err)
+	}
+
+	po, _ := store.Get(context.Background(), "TID1")
+	if po.ProcessedOn.IsZero() {
+		t.Error("expected recovered payment order to be marked as processed")
+	}
+	if want := epay.NewMoney(2001, "BGN"); po.PaidAmount != want {
+		t.Errorf("expected recovered paid amount to be %v, but got: %v", want, po.PaidAmount)
+	}
+}
+
 func jsonReply(w http.ResponseWriter, v interface{}) {
 	jsonVal, _ := json.Marshal(v)
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,83 @@
+package ucrm
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// Config is the configuration of the client for UCRM.
+type Config struct {
+	// BillingURL is the base URL of the UCRM API
+	BillingURL *url.URL
+
+	// APIKey is the app key used for authentication to the UCRM API
+	APIKey string
+
+	// Provider is the payment provider of the payments added to UCRM
+	Provider PaymentProvider
+
+	// HTTPClient is the client used for the requests to the billing system.
+	// http.DefaultClient is used when it's not set.
+	HTTPClient *http.Client
+}
+
+// ConfigFromEnvironment parses and validates the UCRM configuration kept in
+// the metadata of the provided environment.
+func ConfigFromEnvironment(env epay.Environment) (*Config, error) {
+	m := env.Metadata
+	if m["billingUrl"] == "" {
+		return nil, fmt.Errorf("billingUrl of ucrm is required")
+	}
+	billingURL, err := url.Parse(m["billingUrl"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid billingUrl of ucrm: %v", err)
+	}
+
+	c := &Config{
+		BillingURL: billingURL,
+		APIKey:     m["apiKey"],
+		Provider: PaymentProvider{
+			MethodID:        m["methodId"],
+			Name:            m["providerName"],
+			PaymentID:       m["providerPaymentId"],
+			PaymentTime:     m["providerPaymentTime"],
+			OrganizationID:  m["organizationId"],
+			Note:            m["paymentNote"],
+			Currency:        m["currency"],
+			InvoiceStrategy: InvoiceStrategy(m["invoiceStrategy"]),
+		},
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate validates the configuration.
+func (c *Config) Validate() error {
+	if c.BillingURL == nil || !c.BillingURL.IsAbs() || c.BillingURL.Host == "" {
+		return fmt.Errorf("billingUrl of ucrm must be an absolute URL")
+	}
+	if c.APIKey == "" {
+		return fmt.Errorf("apiKey of ucrm is required")
+	}
+	if c.Provider.MethodID == "" {
+		return fmt.Errorf("methodId of ucrm is required")
+	}
+
+	switch c.Provider.PaymentTime {
+	case "", PaymentTimeConfirmed, PaymentTimeCreated:
+	default:
+		return fmt.Errorf("unknown providerPaymentTime '%s'", c.Provider.PaymentTime)
+	}
+
+	switch c.Provider.InvoiceStrategy {
+	case "", InvoiceStrategyOldestFirst, InvoiceStrategyRecorded, InvoiceStrategyCredit:
+	default:
+		return fmt.Errorf("unknown invoiceStrategy '%s'", c.Provider.InvoiceStrategy)
+	}
+	return nil
+}
@@ -11,7 +11,7 @@ type jsonDate struct {
 }
 
 func (t jsonDate) MarshalJSON() ([]byte, error) {
-	date := fmt.Sprintf("%sT00:00:00+0000", t.Format("2006-01-02"))
+	date := fmt.Sprintf("%sT00:00:00+0000", t.UTC().Format("2006-01-02"))
 	return json.Marshal(date)
 }
 
@@ -20,6 +20,23 @@ type jsonDateTime struct {
 }
 
 func (t jsonDateTime) MarshalJSON() ([]byte, error) {
-	date := t.Format("2006-01-02T15:04:05") + "+0000"
+	date := t.UTC().Format("2006-01-02T15:04:05") + "+0000"
 	return json.Marshal(date)
 }
+
+func (t *jsonDateTime) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if v == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.Parse("2006-01-02T15:04:05-0700", v)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed.UTC()
+	return nil
+}
@@ -0,0 +1,32 @@
+package ucrm
+
+import (
+	"strings"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// expand expands the placeholders of the provided template using the values
+// of the payment order. The supported placeholders are:
+//
+//	{TID}    - the transaction ID of ePay
+//	{IDN}    - the subscriber ID which was used for payment
+//	{CLIENT} - the ID of the client in UCRM
+//	{ORG}    - the ID of the configured organization
+//	{DATE}   - the date of creation of the payment order as YYYYMMDD
+//	{AMOUNT} - the paid amount
+func (c *client) expand(tmpl string, po *epay.PaymentOrderRecord) string {
+	if tmpl == "" {
+		return ""
+	}
+
+	r := strings.NewReplacer(
+		"{TID}", po.TransactionID,
+		"{IDN}", po.SubscriberID,
+		"{CLIENT}", po.ClientID,
+		"{ORG}", c.paymentProvider.OrganizationID,
+		"{DATE}", po.CreatedAt.Format("20060102"),
+		"{AMOUNT}", po.PaidAmount.String(),
+	)
+	return r.Replace(tmpl)
+}
@@ -4,8 +4,9 @@ import "context"
 
 // ClientFactory creates a client for particular environment.
 type ClientFactory interface {
-	// Create creates a new client for the provided environment.
-	Create(ctx context.Context, env Environment, idn string) Client
+	// Create creates a new client for the provided environment. An error is
+	// returned when the billing configuration of the environment is not valid.
+	Create(ctx context.Context, env Environment, idn string) (Client, error)
 }
 
 // Client is representing a client to billing.
@@ -21,5 +22,5 @@ type Client interface {
 
 	// PayPaymentOrder performs payment of the the order associated with the providing
 	// the ID of the order or the transactionID associated with it.
-	PayPaymentOrder(ctx context.Context, orderID string) (*PayPaymentOrderResponse, error)
+	PayPaymentOrder(ctx context.Context, payReq PayPaymentOrderRequest) (*PayPaymentOrderResponse, error)
 }
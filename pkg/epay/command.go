@@ -1,51 +1,155 @@
 package epay
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 )
 
+const (
+	// maxRequestSize is the maximum number of bytes accepted for a single request.
+	maxRequestSize = 4096
+
+	// maxFieldSize is the maximum length of a single field value.
+	maxFieldSize = 256
+
+	typeBillCheck = "QBN"
+	typePayment   = "QBC"
+)
+
+var (
+	// ErrMalformedRequest is returned when the received request is not
+	// following the key=value format or some of the fields are not valid.
+	ErrMalformedRequest = errors.New("malformed request")
+
+	// ErrTruncatedRequest is returned when the connection was closed or
+	// failed before a complete request was received.
+	ErrTruncatedRequest = errors.New("truncated request")
+
+	// ErrRequestTooLarge is returned when the request exceeds the maximum
+	// allowed size.
+	ErrRequestTooLarge = errors.New("request exceeds the maximum allowed size")
+
+	// ErrUnknownRequestType is returned when the XTYPE of the request
+	// is not supported.
+	ErrUnknownRequestType = errors.New("unknown XTYPE")
+)
+
+// parseRequest reads a single request from the provided reader. The request is
+// a sequence of KEY=VALUE lines which is terminated by a blank line or by the
+// end of the stream.
 func parseRequest(r io.Reader) (*request, error) {
-	buf := make([]byte, 512)
-	pairs := make(map[string]string)
+	br := bufio.NewReader(io.LimitReader(r, maxRequestSize+1))
+	fields := make(map[string]string)
+	size := 0
+
 	for {
-		n, err := r.Read(buf)
-		if n == 0 || err == io.EOF {
-			break
+		line, rerr := br.ReadString('\n')
+		size += len(line)
+		if size > maxRequestSize {
+			return nil, ErrRequestTooLarge
 		}
-		content := string(buf)
-		if !strings.Contains(content, "=") {
-			return nil, fmt.Errorf("could not find command separator")
+		if rerr != nil && rerr != io.EOF {
+			return nil, fmt.Errorf("%w: %v", ErrTruncatedRequest, rerr)
 		}
 
-		lines := strings.Split(content, "\n")
-		for _, line := range lines {
-			parts := strings.Split(line, "=")
-			if len(parts) > 1 {
-				pairs[parts[0]] = parts[1]
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" {
+			if err := parseField(fields, line); err != nil {
+				return nil, err
 			}
+		} else if len(fields) > 0 && rerr == nil {
+			// blank line terminates the request
+			break
+		}
+
+		if rerr == io.EOF {
+			break
 		}
 	}
 
-	c := &request{Type: pairs["XTYPE"], CustomerID: pairs["IDN"], TransactionID: pairs["TID"]}
+	if len(fields) == 0 {
+		return nil, ErrTruncatedRequest
+	}
+
+	return newRequest(fields)
+}
+
+func parseField(fields map[string]string, line string) error {
+	i := strings.IndexByte(line, '=')
+	if i < 0 {
+		return fmt.Errorf("%w: missing key separator in %q", ErrMalformedRequest, line)
+	}
+	key, value := line[:i], line[i+1:]
 
-	if amount, err := strconv.Atoi(pairs["AMOUNT"]); err == nil {
+	if !isValidKey(key) {
+		return fmt.Errorf("%w: invalid key %q", ErrMalformedRequest, key)
+	}
+	if len(value) > maxFieldSize {
+		return fmt.Errorf("%w: value of %s is too long", ErrMalformedRequest, key)
+	}
+	for _, c := range value {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("%w: value of %s contains control characters", ErrMalformedRequest, key)
+		}
+	}
+	if _, ok := fields[key]; ok {
+		return fmt.Errorf("%w: duplicated key %s", ErrMalformedRequest, key)
+	}
+
+	fields[key] = value
+	return nil
+}
+
+func newRequest(fields map[string]string) (*request, error) {
+	c := &request{Type: fields["XTYPE"], CustomerID: fields["IDN"], TransactionID: fields["TID"]}
+
+	if c.Type == "" {
+		return nil, fmt.Errorf("%w: missing XTYPE", ErrMalformedRequest)
+	}
+	if !c.IsForBillCheck() && !c.IsForPayment() {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRequestType, c.Type)
+	}
+	if c.CustomerID == "" {
+		return nil, fmt.Errorf("%w: missing IDN", ErrMalformedRequest)
+	}
+	if c.TransactionID == "" {
+		return nil, fmt.Errorf("%w: missing TID", ErrMalformedRequest)
+	}
+
+	if c.IsForPayment() {
+		amount, err := strconv.Atoi(fields["AMOUNT"])
+		if err != nil || amount < 0 {
+			return nil, fmt.Errorf("%w: invalid AMOUNT %q", ErrMalformedRequest, fields["AMOUNT"])
+		}
 		c.Amount = amount
 	}
 
 	return c, nil
+}
 
+func isValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, c := range key {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
 }
 
 // IsForBillCheck determines whether it's a bill check request. Returns true if it's
 // a bill check request and false in other case
 func (c *request) IsForBillCheck() bool {
-	return strings.EqualFold(c.Type, "QBN")
+	return strings.EqualFold(c.Type, typeBillCheck)
 }
 
 // IsForPayment checks whether command is for payment processing
 func (c *request) IsForPayment() bool {
-	return strings.EqualFold(c.Type, "QBC")
+	return strings.EqualFold(c.Type, typePayment)
 }
@@ -2,8 +2,11 @@ package epay
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 func TestParseCommand(t *testing.T) {
@@ -14,12 +17,18 @@ func TestParseCommand(t *testing.T) {
 		{"XTYPE=QBN\nIDN=123\nTID=TID123\n", request{Type: "QBN", CustomerID: "123", TransactionID: "TID123"}},
 		{"XTYPE=QBN\nIDN=321\nTID=TID321\n", request{Type: "QBN", CustomerID: "321", TransactionID: "TID321"}},
 		{"XTYPE=QBC\nIDN=321\nTID=TID321\nAMOUNT=120\n", request{Type: "QBC", CustomerID: "321", TransactionID: "TID321", Amount: 120}},
-		{"", request{}},
+		{"XTYPE=QBN\r\nIDN=123\r\nTID=TID123\r\n\r\n", request{Type: "QBN", CustomerID: "123", TransactionID: "TID123"}},
+		{"XTYPE=QBN\nIDN=123\nTID=a=b\n", request{Type: "QBN", CustomerID: "123", TransactionID: "a=b"}},
+		{"XTYPE=QBN\nIDN=123\nTID=TID123\n\nIDN=456\n", request{Type: "QBN", CustomerID: "123", TransactionID: "TID123"}},
+		{"XTYPE=QBN\nIDN=123\nTID=TID123", request{Type: "QBN", CustomerID: "123", TransactionID: "TID123"}},
 	}
 
 	for _, c := range cases {
 		buf := bytes.NewBufferString(c.message)
-		cmd, _ := parseRequest(buf)
+		cmd, err := parseRequest(buf)
+		if err != nil {
+			t.Fatalf("unexpected error while parsing %q: %v", c.message, err)
+		}
 
 		if !reflect.DeepEqual(*cmd, c.exp) {
 			t.Errorf("expected: %v", c.exp)
@@ -27,3 +36,60 @@ func TestParseCommand(t *testing.T) {
 		}
 	}
 }
+
+func TestParseCommandSplitAcrossReads(t *testing.T) {
+	r := iotest.OneByteReader(strings.NewReader("XTYPE=QBC\nIDN=321\nTID=TID321\nAMOUNT=120\n"))
+
+	cmd, err := parseRequest(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	exp := request{Type: "QBC", CustomerID: "321", TransactionID: "TID321", Amount: 120}
+	if !reflect.DeepEqual(*cmd, exp) {
+		t.Errorf("expected: %v", exp)
+		t.Errorf("     got: %v", cmd)
+	}
+}
+
+func TestParseBrokenCommand(t *testing.T) {
+	cases := []struct {
+		name    string
+		message string
+		exp     error
+	}{
+		{"empty", "", ErrTruncatedRequest},
+		{"blank lines only", "\n\n", ErrTruncatedRequest},
+		{"no separator", "::broken::", ErrMalformedRequest},
+		{"invalid key", "xtype=QBN\nIDN=123\nTID=1\n", ErrMalformedRequest},
+		{"duplicated key", "XTYPE=QBN\nIDN=123\nIDN=321\nTID=1\n", ErrMalformedRequest},
+		{"missing type", "IDN=123\nTID=1\n", ErrMalformedRequest},
+		{"missing idn", "XTYPE=QBN\nTID=1\n", ErrMalformedRequest},
+		{"missing tid", "XTYPE=QBN\nIDN=123\n", ErrMalformedRequest},
+		{"missing amount", "XTYPE=QBC\nIDN=123\nTID=1\n", ErrMalformedRequest},
+		{"negative amount", "XTYPE=QBC\nIDN=123\nTID=1\nAMOUNT=-10\n", ErrMalformedRequest},
+		{"control characters", "XTYPE=QBN\nIDN=12\x003\nTID=1\n", ErrMalformedRequest},
+		{"unknown type", "XTYPE=QXX\nIDN=123\nTID=1\n", ErrUnknownRequestType},
+		{"too large", "XTYPE=QBN\nIDN=" + strings.Repeat("1", maxRequestSize) + "\n", ErrRequestTooLarge},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseRequest(iotest.DataErrReader(strings.NewReader(c.message)))
+			if !errors.Is(err, c.exp) {
+				t.Errorf("expected: %v", c.exp)
+				t.Errorf("     got: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseCommandWhenReadFails(t *testing.T) {
+	r := iotest.TimeoutReader(strings.NewReader("XTYPE=QBN\nIDN=123\n"))
+
+	_, err := parseRequest(iotest.OneByteReader(r))
+	if !errors.Is(err, ErrTruncatedRequest) {
+		t.Errorf("expected: %v", ErrTruncatedRequest)
+		t.Errorf("     got: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package epay
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// CurrencyBGN is the ISO 4217 code of the Bulgarian lev.
+	CurrencyBGN = "BGN"
+
+	// CurrencyEUR is the ISO 4217 code of the euro.
+	CurrencyEUR = "EUR"
+)
+
+// bgnPerEUR is the fixed conversion rate of the Bulgarian lev to the euro
+// (1 EUR = 1.95583 BGN) kept as a fraction.
+var bgnPerEUR = big.NewRat(195583, 100000)
+
+// Convert converts the money to the provided currency. Conversions between BGN
+// and EUR are made at the fixed rate and are rounded half away from zero to the
+// minor units of the target currency. Money without currency and conversions to
+// an empty currency are returned unchanged. ErrCurrencyMismatch is returned for
+// all other currencies.
+func (m Money) Convert(currency string) (Money, error) {
+	currency = strings.ToUpper(currency)
+	if currency == "" || m.currency == "" || m.currency == currency {
+		return m, nil
+	}
+
+	var rate *big.Rat
+	switch {
+	case m.currency == CurrencyBGN && currency == CurrencyEUR:
+		rate = new(big.Rat).Inv(bgnPerEUR)
+	case m.currency == CurrencyEUR && currency == CurrencyBGN:
+		rate = bgnPerEUR
+	default:
+		return Money{}, fmt.Errorf("%w: could not convert %s to %s", ErrCurrencyMismatch, m.currency, currency)
+	}
+
+	// both currencies are using the same minor units, so the rate is applied directly
+	v := new(big.Rat).Mul(new(big.Rat).SetInt64(m.units), rate)
+	return Money{units: roundHalfAway(v), currency: currency}, nil
+}
+
+// roundHalfAway rounds the value to the nearest integer with halves rounded
+// away from zero.
+func roundHalfAway(v *big.Rat) int64 {
+	num := new(big.Int).Abs(v.Num())
+	den := v.Denom()
+
+	// (2*num + den) / (2*den) is floor(|v| + 1/2)
+	q := new(big.Int).Add(new(big.Int).Lsh(num, 1), den)
+	q.Quo(q, new(big.Int).Lsh(den, 1))
+	if v.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+// Convert converts the amount to the provided currency. See Money.Convert for
+// the supported conversions.
+func (a Amount) Convert(currency string) (Amount, error) {
+	m, err := a.Money()
+	if err != nil {
+		return Amount{}, err
+	}
+	if m, err = m.Convert(currency); err != nil {
+		return Amount{}, err
+	}
+	return m.Amount(), nil
+}
+
+// Counterpart returns the amount in the other currency of the euro changeover,
+// i.e EUR for BGN and BGN for EUR. false is returned for all other currencies.
+func (m Money) Counterpart() (Money, bool) {
+	var other string
+	switch m.currency {
+	case CurrencyBGN:
+		other = CurrencyEUR
+	case CurrencyEUR:
+		other = CurrencyBGN
+	default:
+		return Money{}, false
+	}
+
+	c, err := m.Convert(other)
+	if err != nil {
+		return Money{}, false
+	}
+	return c, true
+}
@@ -20,6 +20,8 @@ const (
 	PaymentProcessed Status = "00"
 	// PaymentAlreadyProcessed indicates that payment was already processed
 	PaymentAlreadyProcessed Status = "94"
+	// InvalidAmount indicates that paid amount is not accepted for the bill
+	InvalidAmount Status = "13"
 	// CommonError indicates an error which was occurred during payment
 	CommonError Status = "96"
 )
@@ -0,0 +1,153 @@
+package epay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody is the maximum size of the error response which is read.
+const maxErrorBody = 64 << 10
+
+// BillingError is an error returned by a billing system. It's matched by
+// errors.Is to the sentinel error of its kind, e.g ErrSubscriberNotFound, and
+// errors.Unwrap returns the error which caused the failure of the request.
+type BillingError struct {
+	// Backend is the name of the billing system
+	Backend string
+
+	// StatusCode is the HTTP status of the response. It's zero when no
+	// response was received.
+	StatusCode int
+
+	// Code and Message are the error code and message returned by the
+	// billing system
+	Code    string
+	Message string
+
+	// Retriable reports whether the request could succeed when it's
+	// sent again later
+	Retriable bool
+
+	// Err is the sentinel error of the kind of the error
+	Err error
+
+	// Cause is the error which caused the failure of the request
+	Cause error
+}
+
+func (e *BillingError) Error() string {
+	details := make([]string, 0, 3)
+	if e.StatusCode != 0 {
+		details = append(details, fmt.Sprintf("status %d", e.StatusCode))
+	}
+	if e.Code != "" {
+		details = append(details, "code "+e.Code)
+	}
+	if e.Message != "" {
+		details = append(details, e.Message)
+	}
+	if e.Cause != nil {
+		details = append(details, e.Cause.Error())
+	}
+
+	msg := e.Backend + ": " + e.kind().Error()
+	if len(details) > 0 {
+		msg += " (" + strings.Join(details, ", ") + ")"
+	}
+	return msg
+}
+
+// Is reports whether the error is of the kind of the target.
+func (e *BillingError) Is(target error) bool {
+	return target == e.kind()
+}
+
+// Unwrap returns the cause of the error.
+func (e *BillingError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *BillingError) kind() error {
+	if e.Err == nil {
+		return ErrUnknown
+	}
+	return e.Err
+}
+
+// NewBillingError creates an error of the billing system by the status of its
+// response. The kind of the error is set by the status, so callers could
+// change it to a sentinel error for the statuses which are specific for the
+// request, e.g to ErrSubscriberNotFound for 404.
+func NewBillingError(backend string, statusCode int, code, message string) *BillingError {
+	e := &BillingError{Backend: backend, StatusCode: statusCode, Code: code, Message: message, Err: ErrUnknown}
+	switch {
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		e.Err = ErrBillingUnauthorized
+	case statusCode == http.StatusBadRequest || statusCode == http.StatusUnprocessableEntity:
+		e.Err = ErrBillingRejected
+	case statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500:
+		e.Err = ErrBillingUnavailable
+		e.Retriable = true
+	}
+	return e
+}
+
+// ResponseError creates an error of the billing system from its response. The
+// code and the message are decoded from the JSON body when it's having any of
+// the common fields for them. The body of the response is closed.
+func ResponseError(backend string, resp *http.Response) *BillingError {
+	defer resp.Body.Close()
+
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	code, message := decodeErrorBody(b)
+	return NewBillingError(backend, resp.StatusCode, code, message)
+}
+
+// RequestError creates an error of a request to the billing system which
+// was not answered. Such requests could be retried later.
+func RequestError(backend string, err error) *BillingError {
+	return &BillingError{Backend: backend, Retriable: true, Err: ErrBillingUnavailable, Cause: err}
+}
+
+// IsRetriable reports whether the request which failed with the provided error
+// could succeed when it's sent again later.
+func IsRetriable(err error) bool {
+	var e *BillingError
+	if errors.As(err, &e) {
+		return e.Retriable
+	}
+	return errors.Is(err, ErrBillingUnavailable)
+}
+
+// decodeErrorBody returns the error code and message of the JSON body. The
+// error could be nested in an error object, e.g {"error": {"message": "..."}}.
+func decodeErrorBody(b []byte) (string, string) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(b, &body); err != nil {
+		return "", ""
+	}
+	if nested, ok := body["error"].(map[string]interface{}); ok {
+		body = nested
+	}
+
+	return firstValue(body, "code", "errorCode", "error_code"), firstValue(body, "message", "error", "detail", "title")
+}
+
+func firstValue(m map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		switch v := m[key].(type) {
+		case string:
+			if v != "" {
+				return v
+			}
+		case float64:
+			return fmt.Sprintf("%v", v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,70 @@
+package epay
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestBillingErrorKind(t *testing.T) {
+	cases := []struct {
+		status    int
+		kind      error
+		retriable bool
+	}{
+		{http.StatusBadRequest, ErrBillingRejected, false},
+		{http.StatusUnauthorized, ErrBillingUnauthorized, false},
+		{http.StatusForbidden, ErrBillingUnauthorized, false},
+		{http.StatusNotFound, ErrUnknown, false},
+		{http.StatusUnprocessableEntity, ErrBillingRejected, false},
+		{http.StatusTooManyRequests, ErrBillingUnavailable, true},
+		{http.StatusInternalServerError, ErrBillingUnavailable, true},
+		{http.StatusServiceUnavailable, ErrBillingUnavailable, true},
+	}
+
+	for _, c := range cases {
+		err := fmt.Errorf("could not process request due: %w", NewBillingError("ucrm", c.status, "", ""))
+		if !errors.Is(err, c.kind) || IsRetriable(err) != c.retriable {
+			t.Errorf("expected: %v (retriable: %t) for status %d", c.kind, c.retriable, c.status)
+			t.Errorf("     got: %v (retriable: %t)", err, IsRetriable(err))
+		}
+	}
+}
+
+func TestResponseError(t *testing.T) {
+	cases := []struct {
+		body string
+		want string
+	}{
+		{`{"code": 422, "message": "Validation failed."}`, "ucrm: billing system rejected the request (status 422, code 422, Validation failed.)"},
+		{`{"error": {"code": "E1", "message": "Invalid amount"}}`, "ucrm: billing system rejected the request (status 422, code E1, Invalid amount)"},
+		{`<html>error</html>`, "ucrm: billing system rejected the request (status 422)"},
+	}
+
+	for _, c := range cases {
+		resp := &http.Response{StatusCode: http.StatusUnprocessableEntity, Body: ioutil.NopCloser(strings.NewReader(c.body))}
+		if got := ResponseError("ucrm", resp).Error(); got != c.want {
+			t.Errorf("expected: %s", c.want)
+			t.Errorf("     got: %s", got)
+		}
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := RequestError("splynx", cause)
+
+	if !errors.Is(err, ErrBillingUnavailable) || !errors.Is(err, cause) || !IsRetriable(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// the kind could be changed for the statuses which are specific for the request
+	notFound := NewBillingError("telcong", http.StatusNotFound, "", "")
+	notFound.Err = ErrSubscriberNotFound
+	if !errors.Is(notFound, ErrSubscriberNotFound) || errors.Is(notFound, ErrUnknown) {
+		t.Errorf("unexpected error: %v", notFound)
+	}
+}
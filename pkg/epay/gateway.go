@@ -0,0 +1,123 @@
+package epay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// PaymentSourceEPAY is the source of the payment orders created by ePay.
+const PaymentSourceEPAY PaymentSource = "EPAY"
+
+// NewClientGateway creates a new Gateway which serves the requests using
+// the provided billing client. Only payments of the ordered amount are accepted
+// and amounts are not converted.
+func NewClientGateway(client Client) Gateway {
+	env := &Environment{AmountPolicy: AmountPolicyExact}
+	return &clientGateway{
+		newClient: func(ctx context.Context, idn string) (Client, *Environment, error) {
+			return client, env, nil
+		},
+	}
+}
+
+// NewGateway creates a new Gateway which serves the requests using the billing
+// clients created by the provided factory. The environment with the provided name
+// is loaded from the envStore on every request, so changes of the configuration
+// are applied without restart.
+func NewGateway(cf ClientFactory, envStore EnvironmentStore, envName string) Gateway {
+	return &clientGateway{
+		newClient: func(ctx context.Context, idn string) (Client, *Environment, error) {
+			env, err := envStore.Get(ctx, envName)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unable to read environment '%s' due: %v", envName, err)
+			}
+			client, err := cf.Create(ctx, *env, idn)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not create billing client of environment '%s' due: %v", envName, err)
+			}
+			return client, env, nil
+		},
+	}
+}
+
+type clientGateway struct {
+	newClient func(ctx context.Context, idn string) (Client, *Environment, error)
+}
+
+// GetCurrentBill creates a new payment order for the provided transaction and
+// returns the amount of it in the currency of the environment.
+func (g *clientGateway) GetCurrentBill(ctx context.Context, customerID, transactionID string) (*BillResponse, error) {
+	client, env, err := g.newClient(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := client.CreatePaymentOrder(ctx, CreatePaymentOrderRequest{SubscriberID: customerID, TransactionID: transactionID, PaymentSource: PaymentSourceEPAY})
+	if err != nil {
+		if errors.Is(err, ErrPaymentOrderAlreadyExists) {
+			return &BillResponse{NoCurrentBill: true}, nil
+		}
+
+		if errors.Is(err, ErrSubscriberNotFound) {
+			return &BillResponse{UnknownSubscriber: true}, nil
+		}
+
+		return nil, err
+	}
+
+	amount, err := res.Amount.Convert(env.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("could not get amount of payment order due: %w", err)
+	}
+	coins, err := amount.InCoins()
+	if err != nil {
+		return nil, fmt.Errorf("could not get amount of payment order due: %w", err)
+	}
+	if coins <= 0 {
+		return &BillResponse{NoCurrentBill: true}, nil
+	}
+
+	return &BillResponse{Successful: true, Amount: coins}, nil
+}
+
+// PayBill pays the payment order associated with the provided transaction after
+// verification of the paid amount.
+func (g *clientGateway) PayBill(ctx context.Context, customerID, transactionID string, amount int) (*PaymentResponse, error) {
+	client, env, err := g.newClient(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentOrder, err := client.GetPaymentOrder(ctx, transactionID)
+	if err != nil {
+		if errors.Is(err, ErrPaymentOrderNotFound) {
+			return &PaymentResponse{Successful: false}, nil
+		}
+		return nil, fmt.Errorf("could not retrieve payment order due: %v", err)
+	}
+
+	ordered, err := paymentOrder.Amount.Convert(env.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("could not get amount of payment order due: %w", err)
+	}
+
+	if err := ReconcileAmount(env.AmountPolicy, ordered, amount); err != nil {
+		if !errors.Is(err, ErrAmountMismatch) {
+			return nil, err
+		}
+		log.Printf("rejecting payment of transaction '%s' due: %v", transactionID, err)
+		return &PaymentResponse{AmountMismatch: true}, nil
+	}
+
+	payReq := PayPaymentOrderRequest{OrderID: paymentOrder.ID, Amount: AmountFromCoins(amount, ordered.Currency)}
+	if _, err := client.PayPaymentOrder(ctx, payReq); err != nil {
+		if errors.Is(err, ErrPaymentOrderAlreadyPaid) {
+			return &PaymentResponse{AlreadyPaid: true}, nil
+		}
+		return nil, err
+	}
+
+	return &PaymentResponse{Successful: true}, nil
+}
@@ -0,0 +1,184 @@
+package epay
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGatewayGetCurrentBill(t *testing.T) {
+	cases := []struct {
+		name   string
+		client *fakeClient
+		want   *BillResponse
+	}{
+		{"bill returned", &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "19.99"}}}, &BillResponse{Successful: true, Amount: 1999}},
+		{"no duties", &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "0.00"}}}, &BillResponse{NoCurrentBill: true}},
+		{"order already exists", &fakeClient{err: ErrPaymentOrderAlreadyExists}, &BillResponse{NoCurrentBill: true}},
+		{"unknown subscriber", &fakeClient{err: ErrSubscriberNotFound}, &BillResponse{UnknownSubscriber: true}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewClientGateway(c.client).GetCurrentBill(context.Background(), "123", "T1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("expected: %v", c.want)
+				t.Errorf("     got: %v", got)
+			}
+		})
+	}
+}
+
+func TestGatewayGetCurrentBillOfInvalidAmount(t *testing.T) {
+	client := &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "19,99"}}}
+
+	_, err := NewClientGateway(client).GetCurrentBill(context.Background(), "123", "T1")
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected: %v", ErrInvalidAmount)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestGatewayPayBill(t *testing.T) {
+	cases := []struct {
+		name   string
+		client *fakeClient
+		want   *PaymentResponse
+	}{
+		{"paid", &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "1.00"}}}, &PaymentResponse{Successful: true}},
+		{"amount mismatch", &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "1.20"}}}, &PaymentResponse{AmountMismatch: true}},
+		{"already paid", &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "1.00"}}, payErr: ErrPaymentOrderAlreadyPaid}, &PaymentResponse{AlreadyPaid: true}},
+		{"unknown order", &fakeClient{err: ErrPaymentOrderNotFound}, &PaymentResponse{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := NewClientGateway(c.client).PayBill(context.Background(), "123", "T1", 100)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("expected: %v", c.want)
+				t.Errorf("     got: %v", got)
+			}
+		})
+	}
+}
+
+func TestGatewayUsesEnvironmentClient(t *testing.T) {
+	client := &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "1.20"}}}
+	cf := &fakeClientFactory{clients: map[string]Client{"::merchant::": client}}
+	envStore := &fakeEnvironmentStore{envs: map[string]*Environment{"epay.example.com": {MerchantID: "::merchant::"}}}
+
+	g := NewGateway(cf, envStore, "epay.example.com")
+	got, err := g.GetCurrentBill(context.Background(), "123", "T1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (&BillResponse{Successful: true, Amount: 120}); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected: %v", want)
+		t.Errorf("     got: %v", got)
+	}
+
+	if _, err := NewGateway(cf, envStore, "unknown").GetCurrentBill(context.Background(), "123", "T1"); err == nil {
+		t.Error("expected error for unknown environment")
+	}
+}
+
+func TestGatewayPayBillUsesEnvironmentAmountPolicy(t *testing.T) {
+	client := &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "12.00", Currency: "BGN"}}}
+	cf := &fakeClientFactory{clients: map[string]Client{"::merchant::": client}}
+	envStore := &fakeEnvironmentStore{envs: map[string]*Environment{"default": {MerchantID: "::merchant::", AmountPolicy: AmountPolicyPartial}}}
+
+	got, err := NewGateway(cf, envStore, "default").PayBill(context.Background(), "123", "T1", 500)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (&PaymentResponse{Successful: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected: %v", want)
+		t.Errorf("     got: %v", got)
+	}
+	if want := (&PayPaymentOrderRequest{OrderID: "T1", Amount: Amount{Value: "5.00", Currency: "BGN"}}); !reflect.DeepEqual(client.paid, want) {
+		t.Errorf("expected payment: %v", want)
+		t.Errorf("             got: %v", client.paid)
+	}
+}
+
+func TestGatewayConvertsAmountsToEnvironmentCurrency(t *testing.T) {
+	client := &fakeClient{order: &PaymentOrder{ID: "T1", Amount: Amount{Value: "19.99", Currency: "BGN"}}}
+	cf := &fakeClientFactory{clients: map[string]Client{"::merchant::": client}}
+	envStore := &fakeEnvironmentStore{envs: map[string]*Environment{"default": {MerchantID: "::merchant::", Currency: "EUR"}}}
+	g := NewGateway(cf, envStore, "default")
+
+	bill, err := g.GetCurrentBill(context.Background(), "123", "T1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (&BillResponse{Successful: true, Amount: 1022}); !reflect.DeepEqual(bill, want) {
+		t.Errorf("expected: %v", want)
+		t.Errorf("     got: %v", bill)
+	}
+
+	if _, err := g.PayBill(context.Background(), "123", "T1", 1022); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := (&PayPaymentOrderRequest{OrderID: "T1", Amount: Amount{Value: "10.22", Currency: "EUR"}}); !reflect.DeepEqual(client.paid, want) {
+		t.Errorf("expected payment: %v", want)
+		t.Errorf("             got: %v", client.paid)
+	}
+}
+
+type fakeClient struct {
+	order  *PaymentOrder
+	err    error
+	payErr error
+	paid   *PayPaymentOrderRequest
+}
+
+func (f *fakeClient) GetSubscriberDuties(ctx context.Context, subscriberID string) (*SubscriberDuties, error) {
+	return nil, f.err
+}
+
+func (f *fakeClient) CreatePaymentOrder(ctx context.Context, createReq CreatePaymentOrderRequest) (*PaymentOrder, error) {
+	return f.order, f.err
+}
+
+func (f *fakeClient) GetPaymentOrder(ctx context.Context, orderKey string) (*PaymentOrder, error) {
+	return f.order, f.err
+}
+
+func (f *fakeClient) PayPaymentOrder(ctx context.Context, payReq PayPaymentOrderRequest) (*PayPaymentOrderResponse, error) {
+	if f.payErr != nil {
+		return nil, f.payErr
+	}
+	f.paid = &payReq
+	return &PayPaymentOrderResponse{ID: payReq.OrderID}, nil
+}
+
+type fakeClientFactory struct {
+	clients map[string]Client
+}
+
+func (f *fakeClientFactory) Create(ctx context.Context, env Environment, idn string) (Client, error) {
+	client, ok := f.clients[env.MerchantID]
+	if !ok {
+		return nil, ErrInvalidConfig
+	}
+	return client, nil
+}
+
+type fakeEnvironmentStore struct {
+	envs map[string]*Environment
+}
+
+func (f *fakeEnvironmentStore) Get(ctx context.Context, name string) (*Environment, error) {
+	env, ok := f.envs[name]
+	if !ok {
+		return nil, errors.New("environment not found")
+	}
+	return env, nil
+}
@@ -0,0 +1,73 @@
+package epay
+
+import (
+	"context"
+	"log"
+)
+
+// LegacyGateway is a gateway to the remote billing system which is not aware
+// of the request context.
+//
+// Deprecated: implement Gateway instead.
+type LegacyGateway interface {
+	// GetCurrentBill returns the current bill of the provided customer.
+	GetCurrentBill(customerID, transactionID string) (*BillResponse, error)
+
+	// PayBill pays bill using the provided amount
+	PayBill(customerID, transactionID string, Amount int) (*PaymentResponse, error)
+}
+
+// AdaptLegacyGateway adapts the provided LegacyGateway to Gateway. A legacy
+// call could not be cancelled, so the returned gateway stops waiting for a bill
+// check and returns the context error when the context is done. Payments are
+// always waited for, as a payment could be booked after the context is done and
+// ePay must not be told that it failed. The outcome of a payment which finished
+// after the context was done is logged with its TID.
+func AdaptLegacyGateway(g LegacyGateway) Gateway {
+	return &legacyGateway{g}
+}
+
+type legacyGateway struct {
+	g LegacyGateway
+}
+
+func (l *legacyGateway) GetCurrentBill(ctx context.Context, customerID, transactionID string) (*BillResponse, error) {
+	type result struct {
+		resp *BillResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := l.g.GetCurrentBill(customerID, transactionID)
+		done <- result{resp, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.resp, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *legacyGateway) PayBill(ctx context.Context, customerID, transactionID string, amount int) (*PaymentResponse, error) {
+	type result struct {
+		resp *PaymentResponse
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resp, err := l.g.PayBill(customerID, transactionID, amount)
+		done <- result{resp, err}
+	}()
+
+	r := <-done
+	if ctx.Err() != nil {
+		if r.err != nil {
+			log.Printf("payment of TID %s finished after the request was done with error: %v", transactionID, r.err)
+		} else {
+			log.Printf("payment of TID %s finished after the request was done with status: %s", transactionID, r.resp.Status())
+		}
+	}
+	return r.resp, r.err
+}
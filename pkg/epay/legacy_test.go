@@ -0,0 +1,72 @@
+package epay
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLegacyGateway(t *testing.T) {
+	g := AdaptLegacyGateway(&fakeLegacyGateway{billResponse: &BillResponse{Successful: true, Amount: 120}})
+
+	resp, err := g.GetCurrentBill(context.Background(), "123", "T1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Amount != 120 {
+		t.Errorf("expected amount to be 120, but got: %d", resp.Amount)
+	}
+}
+
+func TestLegacyGatewayStopsWaitingWhenContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	g := AdaptLegacyGateway(&fakeLegacyGateway{wait: release})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := g.GetCurrentBill(ctx, "123", "T1"); err != context.DeadlineExceeded {
+		t.Errorf("expected: %v", context.DeadlineExceeded)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestLegacyGatewayWaitsForPaymentWhenContextIsDone(t *testing.T) {
+	release := make(chan struct{})
+	g := AdaptLegacyGateway(&fakeLegacyGateway{paymentResponse: &PaymentResponse{Successful: true}, wait: release})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		close(release)
+	}()
+
+	resp, err := g.PayBill(ctx, "123", "T1", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Successful {
+		t.Errorf("expected the late payment to be reported as successful")
+	}
+}
+
+type fakeLegacyGateway struct {
+	billResponse    *BillResponse
+	paymentResponse *PaymentResponse
+	wait            chan struct{}
+}
+
+func (f *fakeLegacyGateway) GetCurrentBill(customerID, transactionID string) (*BillResponse, error) {
+	if f.wait != nil {
+		<-f.wait
+	}
+	return f.billResponse, nil
+}
+
+func (f *fakeLegacyGateway) PayBill(customerID, transactionID string, amount int) (*PaymentResponse, error) {
+	if f.wait != nil {
+		<-f.wait
+	}
+	return f.paymentResponse, nil
+}
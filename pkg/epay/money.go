@@ -0,0 +1,214 @@
+package epay
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// maxMoneyDigits is the maximum number of digits of a parsed amount. It keeps
+// the amount in minor units within the range of int64.
+const maxMoneyDigits = 18
+
+// minorUnits holds the ISO 4217 currencies which are not using two digits
+// after the decimal point.
+var minorUnits = map[string]int{
+	"BHD": 3, "BIF": 0, "CLF": 4, "CLP": 0, "DJF": 0, "GNF": 0, "IQD": 3,
+	"ISK": 0, "JOD": 3, "JPY": 0, "KMF": 0, "KRW": 0, "KWD": 3, "LYD": 3,
+	"OMR": 3, "PYG": 0, "RWF": 0, "TND": 3, "UGX": 0, "UYI": 0, "UYW": 4,
+	"VND": 0, "VUV": 0, "XAF": 0, "XOF": 0, "XPF": 0,
+}
+
+// MinorUnits returns the number of digits after the decimal point of the
+// provided ISO 4217 currency. Unknown or missing currencies are using 2 digits.
+func MinorUnits(currency string) int {
+	if n, ok := minorUnits[strings.ToUpper(currency)]; ok {
+		return n
+	}
+	return 2
+}
+
+// Money is an exact amount of money kept in the minor units of its currency,
+// e.g 19.99 BGN is kept as 1999 stotinki. The zero value is a zero amount
+// without currency.
+type Money struct {
+	units    int64
+	currency string
+}
+
+// NewMoney creates a new Money from an amount in minor units of the currency.
+func NewMoney(units int64, currency string) Money {
+	return Money{units: units, currency: strings.ToUpper(currency)}
+}
+
+// ParseMoney parses a decimal value like "19.99" in the provided currency.
+// ErrInvalidAmount is returned when the value is not a decimal number or
+// when it has more significant digits than the minor units of the currency.
+func ParseMoney(value, currency string) (Money, error) {
+	s := strings.TrimSpace(value)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	}
+
+	whole, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, fraction = s[:i], s[i+1:]
+		if fraction == "" {
+			return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+		}
+	}
+	if whole == "" || !isDigits(whole) || !isDigits(fraction) {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+
+	digits := MinorUnits(currency)
+	if len(fraction) > digits {
+		if strings.Trim(fraction[digits:], "0") != "" {
+			return Money{}, fmt.Errorf("%w: %q has more than %d decimal places", ErrInvalidAmount, value, digits)
+		}
+		fraction = fraction[:digits]
+	}
+	fraction += strings.Repeat("0", digits-len(fraction))
+
+	whole = strings.TrimLeft(whole, "0")
+	if len(whole)+len(fraction) > maxMoneyDigits {
+		return Money{}, fmt.Errorf("%w: %q is too large", ErrInvalidAmount, value)
+	}
+
+	var units int64
+	for _, c := range whole + fraction {
+		units = units*10 + int64(c-'0')
+	}
+	if negative {
+		units = -units
+	}
+	return NewMoney(units, currency), nil
+}
+
+// RoundMoney parses a decimal value which could be in exponent form or could
+// carry the artefacts of a binary float, e.g "0.30000000000000004" or "1.5e1",
+// and rounds it half away from zero to the minor units of the currency. It's
+// meant for the amounts of the billing systems which are serialized as floats,
+// ParseMoney should be used for all exact amounts. ErrInvalidAmount is returned
+// when the value is not a number or when it's out of range.
+func RoundMoney(value, currency string) (Money, error) {
+	v, ok := new(big.Rat).SetString(strings.TrimSpace(value))
+	if !ok || strings.Contains(value, "/") {
+		return Money{}, fmt.Errorf("%w: %q", ErrInvalidAmount, value)
+	}
+	m, err := RatMoney(v, currency)
+	if err != nil {
+		return Money{}, fmt.Errorf("%w: %q is too large", ErrInvalidAmount, value)
+	}
+	return m, nil
+}
+
+// RatMoney rounds the exact value half away from zero to the minor units of the
+// currency. It's used for amounts which are calculated, e.g price * quantity,
+// so they are rounded only once. ErrInvalidAmount is returned when the value
+// is out of range.
+func RatMoney(v *big.Rat, currency string) (Money, error) {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(MinorUnits(currency))), nil)
+	units := new(big.Rat).Mul(v, new(big.Rat).SetInt(scale))
+
+	limit := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(maxMoneyDigits), nil))
+	if new(big.Rat).Abs(units).Cmp(limit) >= 0 {
+		return Money{}, fmt.Errorf("%w: %s is too large", ErrInvalidAmount, v.FloatString(MinorUnits(currency)))
+	}
+	return NewMoney(roundHalfAway(units), currency), nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Units returns the amount in minor units of the currency.
+func (m Money) Units() int64 {
+	return m.units
+}
+
+// Currency returns the ISO 4217 code of the currency.
+func (m Money) Currency() string {
+	return m.currency
+}
+
+// IsZero reports whether the amount is zero.
+func (m Money) IsZero() bool {
+	return m.units == 0
+}
+
+// Sign returns -1, 0 or 1 depending on the sign of the amount.
+func (m Money) Sign() int {
+	switch {
+	case m.units < 0:
+		return -1
+	case m.units > 0:
+		return 1
+	}
+	return 0
+}
+
+// Add returns the sum of both amounts. ErrCurrencyMismatch is returned when the
+// amounts are in different currencies. The zero Money could be added to any amount.
+func (m Money) Add(o Money) (Money, error) {
+	currency, err := m.commonCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{units: m.units + o.units, currency: currency}, nil
+}
+
+// Sub returns the difference of both amounts. ErrCurrencyMismatch is returned
+// when the amounts are in different currencies.
+func (m Money) Sub(o Money) (Money, error) {
+	currency, err := m.commonCurrency(o)
+	if err != nil {
+		return Money{}, err
+	}
+	return Money{units: m.units - o.units, currency: currency}, nil
+}
+
+func (m Money) commonCurrency(o Money) (string, error) {
+	switch {
+	case m == Money{}:
+		return o.currency, nil
+	case o == Money{}:
+		return m.currency, nil
+	case m.currency != o.currency:
+		return "", fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.currency, o.currency)
+	}
+	return m.currency, nil
+}
+
+// String returns the decimal value of the amount without the currency, e.g 19.99.
+func (m Money) String() string {
+	units := m.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	digits := MinorUnits(m.currency)
+	if digits == 0 {
+		return fmt.Sprintf("%s%d", sign, units)
+	}
+
+	scale := int64(1)
+	for i := 0; i < digits; i++ {
+		scale *= 10
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, units/scale, digits, units%scale)
+}
+
+// Amount converts the money to Amount.
+func (m Money) Amount() Amount {
+	return Amount{Value: m.String(), Currency: m.currency}
+}
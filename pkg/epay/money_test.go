@@ -0,0 +1,169 @@
+package epay
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		value    string
+		currency string
+		units    int64
+		str      string
+	}{
+		{"19.99", "BGN", 1999, "19.99"},
+		{"0.29", "EUR", 29, "0.29"},
+		{"1.1", "EUR", 110, "1.10"},
+		{"20", "BGN", 2000, "20.00"},
+		{"20.000", "BGN", 2000, "20.00"},
+		{"007.05", "", 705, "7.05"},
+		{"-1.20", "BGN", -120, "-1.20"},
+		{"1500", "JPY", 1500, "1500"},
+		{"1.234", "KWD", 1234, "1.234"},
+		{"9999999999999999.99", "EUR", 999999999999999999, "9999999999999999.99"},
+	}
+
+	for _, c := range cases {
+		got, err := ParseMoney(c.value, c.currency)
+		if err != nil {
+			t.Errorf("unexpected error while parsing %q: %v", c.value, err)
+			continue
+		}
+		if got.Units() != c.units || got.String() != c.str {
+			t.Errorf("expected: %d (%s)", c.units, c.str)
+			t.Errorf("     got: %d (%s)", got.Units(), got.String())
+		}
+	}
+}
+
+func TestParseInvalidMoney(t *testing.T) {
+	cases := []struct {
+		value    string
+		currency string
+	}{
+		{"", "BGN"},
+		{"-", "BGN"},
+		{"abc", "BGN"},
+		{"1,20", "BGN"},
+		{"1.", "BGN"},
+		{".5", "BGN"},
+		{"1e2", "BGN"},
+		{"19.999", "BGN"},
+		{"1.5", "JPY"},
+		{"1000000000000000000", "BGN"},
+	}
+
+	for _, c := range cases {
+		_, err := ParseMoney(c.value, c.currency)
+		if !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("expected %q to be rejected, but got: %v", c.value, err)
+		}
+	}
+}
+
+func TestRoundMoney(t *testing.T) {
+	cases := []struct {
+		value    string
+		currency string
+		units    int64
+	}{
+		{"19.99", "BGN", 1999},
+		{"0.30000000000000004", "EUR", 30},
+		{"19.989999999999998", "BGN", 1999},
+		{"8.335", "BGN", 834},
+		{"-8.335", "BGN", -834},
+		{"1.5e1", "EUR", 1500},
+		{"2.5E-2", "EUR", 3},
+		{"1e2", "JPY", 100},
+	}
+
+	for _, c := range cases {
+		got, err := RoundMoney(c.value, c.currency)
+		if err != nil {
+			t.Errorf("unexpected error while rounding %q: %v", c.value, err)
+			continue
+		}
+		if got.Units() != c.units {
+			t.Errorf("expected %q to be %d but was %d", c.value, c.units, got.Units())
+		}
+	}
+}
+
+func TestRoundInvalidMoney(t *testing.T) {
+	for _, v := range []string{"", "abc", "1.2.3", "1e30", "1/3"} {
+		if _, err := RoundMoney(v, "BGN"); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("expected invalid amount for %q but got: %v", v, err)
+		}
+	}
+}
+
+func TestAddMoney(t *testing.T) {
+	var total Money
+	for _, v := range []string{"20.00", "12.00", "13.43"} {
+		m, _ := ParseMoney(v, "BGN")
+
+		var err error
+		if total, err = total.Add(m); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if want := NewMoney(4543, "BGN"); total != want {
+		t.Errorf("expected: %v", want)
+		t.Errorf("     got: %v", total)
+	}
+}
+
+func TestAddMoneyOfDifferentCurrencies(t *testing.T) {
+	_, err := NewMoney(100, "BGN").Add(NewMoney(100, "EUR"))
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected: %v", ErrCurrencyMismatch)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestInvalidAmountInCoins(t *testing.T) {
+	_, err := Amount{Value: "19.99.1", Currency: "BGN"}.InCoins()
+	if !errors.Is(err, ErrInvalidAmount) {
+		t.Errorf("expected: %v", ErrInvalidAmount)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestConvertMoney(t *testing.T) {
+	cases := []struct {
+		from Money
+		to   string
+		want Money
+	}{
+		{NewMoney(1999, "BGN"), "EUR", NewMoney(1022, "EUR")},
+		{NewMoney(195583, "BGN"), "EUR", NewMoney(100000, "EUR")},
+		{NewMoney(1000, "EUR"), "BGN", NewMoney(1956, "BGN")},
+		{NewMoney(1, "EUR"), "BGN", NewMoney(2, "BGN")},
+		{NewMoney(-1000, "EUR"), "BGN", NewMoney(-1956, "BGN")},
+		{NewMoney(1000, "EUR"), "EUR", NewMoney(1000, "EUR")},
+		{NewMoney(1000, "EUR"), "", NewMoney(1000, "EUR")},
+		{NewMoney(1000, ""), "EUR", NewMoney(1000, "")},
+	}
+
+	for _, c := range cases {
+		got, err := c.from.Convert(c.to)
+		if err != nil {
+			t.Errorf("unexpected error while converting %v: %v", c.from, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("expected: %v %s", c.want, c.want.Currency())
+			t.Errorf("     got: %v %s", got, got.Currency())
+		}
+	}
+}
+
+func TestConvertMoneyToUnsupportedCurrency(t *testing.T) {
+	_, err := NewMoney(1000, "BGN").Convert("USD")
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Errorf("expected: %v", ErrCurrencyMismatch)
+		t.Errorf("     got: %v", err)
+	}
+}
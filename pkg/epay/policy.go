@@ -0,0 +1,52 @@
+package epay
+
+import "fmt"
+
+// AmountPolicy defines how payments which amount differs from the amount
+// of the payment order are handled.
+type AmountPolicy string
+
+const (
+	// AmountPolicyExact accepts only payments of the ordered amount. It's
+	// used when no policy is configured.
+	AmountPolicyExact AmountPolicy = "exact"
+
+	// AmountPolicyPartial accepts payments which are less than the ordered amount.
+	AmountPolicyPartial AmountPolicy = "partial"
+
+	// AmountPolicyOver accepts payments which are greater than the ordered amount.
+	AmountPolicyOver AmountPolicy = "over"
+
+	// AmountPolicyAny accepts payments of any amount. Mismatches are
+	// flagged only by the paid amount recorded in the store.
+	AmountPolicyAny AmountPolicy = "any"
+)
+
+// ReconcileAmount verifies the paid amount in coins against the amount of
+// the payment order using the provided policy. ErrAmountMismatch is returned
+// when the paid amount is not accepted and ErrInvalidAmount when the amount of
+// the order could not be parsed.
+//
+// Billing systems which book the amount of the order on their own, like
+// TelcoNG, are used only with AmountPolicyExact.
+func ReconcileAmount(policy AmountPolicy, ordered Amount, paidCoins int) error {
+	orderedCoins, err := ordered.InCoins()
+	if err != nil {
+		return err
+	}
+
+	accepted := paidCoins == orderedCoins
+	switch policy {
+	case AmountPolicyPartial:
+		accepted = accepted || (paidCoins > 0 && paidCoins < orderedCoins)
+	case AmountPolicyOver:
+		accepted = accepted || paidCoins > orderedCoins
+	case AmountPolicyAny:
+		accepted = accepted || paidCoins > 0
+	}
+
+	if !accepted {
+		return fmt.Errorf("%w: paid %d coins for order of %d coins", ErrAmountMismatch, paidCoins, orderedCoins)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package epay
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReconcileAmount(t *testing.T) {
+	cases := []struct {
+		policy   AmountPolicy
+		ordered  string
+		paid     int
+		accepted bool
+	}{
+		{"", "10.00", 1000, true},
+		{"", "10.00", 999, false},
+		{AmountPolicyExact, "10.00", 1001, false},
+		{AmountPolicyPartial, "10.00", 500, true},
+		{AmountPolicyPartial, "10.00", 0, false},
+		{AmountPolicyPartial, "10.00", 1500, false},
+		{AmountPolicyOver, "10.00", 1500, true},
+		{AmountPolicyOver, "10.00", 500, false},
+		{AmountPolicyAny, "10.00", 500, true},
+		{AmountPolicyAny, "10.00", 1500, true},
+	}
+
+	for _, c := range cases {
+		err := ReconcileAmount(c.policy, Amount{Value: c.ordered}, c.paid)
+		if c.accepted && err != nil {
+			t.Errorf("expected %d coins to be accepted for %s with policy '%s', but got: %v", c.paid, c.ordered, c.policy, err)
+		}
+		if !c.accepted && !errors.Is(err, ErrAmountMismatch) {
+			t.Errorf("expected %d coins to be rejected for %s with policy '%s', but got: %v", c.paid, c.ordered, c.policy, err)
+		}
+	}
+}
+
+func TestAmountFromCoins(t *testing.T) {
+	cases := []struct {
+		coins int
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1999, "19.99"},
+		{-120, "-1.20"},
+	}
+
+	for _, c := range cases {
+		if got := AmountFromCoins(c.coins, "BGN"); got.Value != c.want || got.Currency != "BGN" {
+			t.Errorf("expected AmountFromCoins(%d) to be: %s", c.coins, c.want)
+			t.Errorf("                          but was: %v", got)
+		}
+	}
+}
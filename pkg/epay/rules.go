@@ -0,0 +1,148 @@
+package epay
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// RuleRequest is the kind of the ePay requests to which a subscriber rule applies.
+type RuleRequest string
+
+const (
+	// RuleCheck applies to the checks of the duties, i.e init?TYPE=CHECK
+	RuleCheck RuleRequest = "CHECK"
+	// RuleBilling applies to the creation of payment orders, i.e init?TYPE=BILLING
+	RuleBilling RuleRequest = "BILLING"
+	// RuleConfirm applies to the confirmations of the payments
+	RuleConfirm RuleRequest = "CONFIRM"
+)
+
+// The statuses which could be returned by the subscriber rules.
+const (
+	RuleStatusSubscriberNotFound = "14"
+	RuleStatusNoDuties           = "62"
+	RuleStatusNotAvailable       = "80"
+)
+
+// SubscriberRule is a policy for the requests of the subscribers which answers
+// the matched requests with its status without calling the billing system. It's
+// used for blocking of subscribers, e.g for fraud or suspended clients, for test
+// IDNs and for maintenance of the billing system.
+type SubscriberRule struct {
+	// Name describes the rule in the logs
+	Name string `json:"name,omitempty"`
+
+	// IDNs are the IDNs of the matched subscribers. The rule matches all
+	// subscribers when both IDNs and Pattern are empty.
+	IDNs []string `json:"idns,omitempty"`
+
+	// Pattern is a regular expression which the IDN of the matched
+	// subscribers should match
+	Pattern string `json:"pattern,omitempty"`
+
+	// From is the start of the window in which the rule is active,
+	// the rule is active from any time when it's nil
+	From *time.Time `json:"from,omitempty"`
+
+	// To is the end of the window in which the rule is active, the
+	// rule is active until it's removed when it's nil
+	To *time.Time `json:"to,omitempty"`
+
+	// Status is the status of the response to the matched requests:
+	// 14 (subscriber not found), 62 (no duties) or 80 (temporary not available)
+	Status string `json:"status"`
+
+	// Requests are the requests to which the rule applies, it applies
+	// to all requests when it's empty
+	Requests []RuleRequest `json:"requests,omitempty"`
+
+	// re is the Pattern compiled by Validate
+	re *regexp.Regexp
+}
+
+// Validate validates the settings of the rule and compiles its pattern, so
+// it's not compiled again for each request matched by the rule.
+func (r *SubscriberRule) Validate() error {
+	switch r.Status {
+	case RuleStatusSubscriberNotFound, RuleStatusNoDuties, RuleStatusNotAvailable:
+	default:
+		return fmt.Errorf("unknown status '%s' of rule '%s'", r.Status, r.Name)
+	}
+	for _, req := range r.Requests {
+		switch req {
+		case RuleCheck, RuleBilling, RuleConfirm:
+		default:
+			return fmt.Errorf("unknown request '%s' of rule '%s'", req, r.Name)
+		}
+	}
+	if r.From != nil && r.To != nil && !r.To.After(*r.From) {
+		return fmt.Errorf("window of rule '%s' ends before it starts", r.Name)
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern of rule '%s': %v", r.Name, err)
+	}
+	r.re = re
+	return nil
+}
+
+// Matches reports whether the rule applies to the request of the subscriber
+// with the provided IDN at the provided time.
+func (r *SubscriberRule) Matches(req RuleRequest, idn string, now time.Time) (bool, error) {
+	if len(r.Requests) > 0 && !containsRequest(r.Requests, req) {
+		return false, nil
+	}
+	if (r.From != nil && now.Before(*r.From)) || (r.To != nil && !now.Before(*r.To)) {
+		return false, nil
+	}
+	if len(r.IDNs) == 0 && r.Pattern == "" {
+		return true, nil
+	}
+	for _, v := range r.IDNs {
+		if v == idn {
+			return true, nil
+		}
+	}
+	if r.Pattern == "" {
+		return false, nil
+	}
+	re, err := compilePattern(r.re, r.Pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern of rule '%s': %v", r.Name, err)
+	}
+	return re.MatchString(idn), nil
+}
+
+// MatchRule finds the first rule which applies to the request of the subscriber
+// with the provided IDN at the provided time. It returns nil when no rule applies.
+func MatchRule(rules []SubscriberRule, req RuleRequest, idn string, now time.Time) (*SubscriberRule, error) {
+	for i := range rules {
+		ok, err := rules[i].Matches(req, idn, now)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// compilePattern returns the pattern compiled by the validation, the pattern is
+// compiled when it's matched without being validated.
+func compilePattern(re *regexp.Regexp, pattern string) (*regexp.Regexp, error) {
+	if re != nil {
+		return re, nil
+	}
+	return regexp.Compile(pattern)
+}
+
+func containsRequest(requests []RuleRequest, req RuleRequest) bool {
+	for _, r := range requests {
+		if r == req {
+			return true
+		}
+	}
+	return false
+}
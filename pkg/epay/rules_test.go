@@ -0,0 +1,85 @@
+package epay
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchRule(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(2 * time.Hour)
+
+	rules := []SubscriberRule{
+		{Name: "test", IDNs: []string{"1111111111"}, Status: RuleStatusSubscriberNotFound, Requests: []RuleRequest{RuleCheck}},
+		{Name: "fraud", Pattern: "^666", Status: RuleStatusNoDuties},
+		{Name: "maintenance", From: &from, To: &to, Status: RuleStatusNotAvailable},
+	}
+
+	cases := []struct {
+		req  RuleRequest
+		idn  string
+		now  time.Time
+		want string
+	}{
+		{RuleCheck, "1111111111", from.Add(-time.Hour), "test"},
+		{RuleBilling, "1111111111", from.Add(-time.Hour), ""},
+		{RuleConfirm, "6661234", from.Add(-time.Hour), "fraud"},
+		{RuleBilling, "1234567", from, "maintenance"},
+		{RuleBilling, "1234567", to, ""},
+		{RuleCheck, "1111111111", from.Add(time.Hour), "test"},
+	}
+	for _, c := range cases {
+		rule, err := MatchRule(rules, c.req, c.idn, c.now)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		got := ""
+		if rule != nil {
+			got = rule.Name
+		}
+		if got != c.want {
+			t.Errorf("%s of %s at %v: expected rule '%s', but got: '%s'", c.req, c.idn, c.now, c.want, got)
+		}
+	}
+}
+
+func TestValidateSubscriberRule(t *testing.T) {
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	invalid := []SubscriberRule{
+		{Status: "00"},
+		{Status: RuleStatusNoDuties, Requests: []RuleRequest{"REFUND"}},
+		{Status: RuleStatusNoDuties, Pattern: "["},
+		{Status: RuleStatusNoDuties, From: &from, To: &from},
+	}
+	for _, r := range invalid {
+		if err := r.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", r)
+		}
+	}
+}
+
+func TestPatternsAreCompiledOnValidation(t *testing.T) {
+	env := Environment{
+		SubscriberRules: []SubscriberRule{{Name: "fraud", Pattern: "^666", Status: RuleStatusNoDuties}},
+		BillingRoutes:   []BillingRoute{{BillingSystem: "ucrm", Pattern: "^7"}},
+	}
+	if err := env.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if env.SubscriberRules[0].re == nil || env.BillingRoutes[0].re == nil {
+		t.Error("expected patterns to be compiled by the validation")
+	}
+
+	if ok, err := env.BillingRoutes[0].MatchesPattern("7123"); !ok || err != nil {
+		t.Errorf("expected route to match, but got: %v, %v", ok, err)
+	}
+	if ok, err := (&BillingRoute{Pattern: "^7"}).MatchesPattern("8123"); ok || err != nil {
+		t.Errorf("expected route of not validated pattern not to match, but got: %v, %v", ok, err)
+	}
+
+	invalid := Environment{BillingRoutes: []BillingRoute{{BillingSystem: "ucrm", Pattern: "["}}}
+	if err := invalid.Validate(); err == nil {
+		t.Error("expected invalid pattern of route to be rejected")
+	}
+}
@@ -1,26 +1,30 @@
 package epay
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
+	"sync"
 	"time"
 )
 
-// Gateway is a generic gateway to the remote billing system
+// Gateway is a generic gateway to the remote billing system. The provided context
+// is cancelled when the request deadline is reached or when the ePay peer disconnects.
 type Gateway interface {
 	// GetCurrentBill returns the current bill of the provided customer.
-	GetCurrentBill(customerID, transactionID string) (*BillResponse, error)
+	GetCurrentBill(ctx context.Context, customerID, transactionID string) (*BillResponse, error)
 
 	// PayBill pays bill using the provided amount
-	PayBill(customerID, transactionID string, Amount int) (*PaymentResponse, error)
+	PayBill(ctx context.Context, customerID, transactionID string, amount int) (*PaymentResponse, error)
 }
 
 // BillResponse is representing the response from the billing
 type BillResponse struct {
 	Successful        bool
 	UnknownSubscriber bool
+	NoCurrentBill     bool
 	Amount            int
 }
 
@@ -30,6 +34,8 @@ func (br *BillResponse) Status() Status {
 		return BillReturned
 	} else if br.UnknownSubscriber {
 		return UnknownSubscriber
+	} else if br.NoCurrentBill {
+		return NoCurrentBill
 	} else {
 		return CommonError
 	}
@@ -37,8 +43,9 @@ func (br *BillResponse) Status() Status {
 
 // PaymentResponse is representing the response of payment in the billing system.
 type PaymentResponse struct {
-	AlreadyPaid bool
-	Successful  bool
+	AlreadyPaid    bool
+	AmountMismatch bool
+	Successful     bool
 }
 
 // Status gets payment response status
@@ -47,89 +54,338 @@ func (pr *PaymentResponse) Status() Status {
 		return PaymentProcessed
 	} else if pr.AlreadyPaid {
 		return PaymentAlreadyProcessed
+	} else if pr.AmountMismatch {
+		return InvalidAmount
 	} else {
 		return CommonError
 	}
 }
 
+// ErrServerClosed is returned by the Server's Serve method after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("epay: Server closed")
+
+const (
+	defaultReadTimeout  = 30 * time.Second
+	defaultWriteTimeout = 30 * time.Second
+
+	// defaultRequestTimeout is kept below the time window in which ePay
+	// waits for a response, so it gets an answer before giving up.
+	defaultRequestTimeout = 25 * time.Second
+
+	// shutdownPollInterval is how often Shutdown checks whether all
+	// connections were handled.
+	shutdownPollInterval = 10 * time.Millisecond
+)
+
 // Server is representing an implementation of the epay server
 type Server struct {
-	listener net.Listener
+	// ReadTimeout is the maximum duration for reading the entire request. Zero
+	// means no timeout.
+	ReadTimeout time.Duration
+
+	// WriteTimeout is the maximum duration before timing out writes of the
+	// response. Zero means no timeout.
+	WriteTimeout time.Duration
 
-	quit chan bool
+	// RequestTimeout is the maximum duration of the gateway call which handles
+	// a single request. Zero means no timeout.
+	RequestTimeout time.Duration
+
+	mu          sync.Mutex
+	inShutdown  bool
+	listeners   map[*net.Listener]struct{}
+	conns       map[net.Conn]struct{}
+	connCtx     context.Context
+	cancelConns context.CancelFunc
 }
 
 // NewServer creates a new instance of the EpayServer
 func NewServer() *Server {
-	s := &Server{quit: make(chan bool)}
-	return s
+	return &Server{
+		ReadTimeout:    defaultReadTimeout,
+		WriteTimeout:   defaultWriteTimeout,
+		RequestTimeout: defaultRequestTimeout,
+	}
 }
 
-// Serve serves the incoming connections received from the provided listener
-func (s *Server) Serve(l net.Listener, gateway Gateway) error {
+// Serve accepts incoming connections on the provided listener and handles them
+// using the provided gateway. Cancelling ctx stops accepting of new connections, but
+// the in-flight requests are handled till they are done, so the gateway calls are
+// cancelled only by Close or by the deadline of Shutdown.
+// Serve always returns a non-nil error and closes l.
+// After Shutdown or Close the returned error is ErrServerClosed and when ctx is
+// cancelled the error of the context is returned.
+func (s *Server) Serve(ctx context.Context, l net.Listener, gateway Gateway) error {
+	l = &onceCloseListener{Listener: l}
 	defer l.Close()
-	s.listener = l
 
-	for {
-		tcpConn := l.(*net.TCPListener)
-		tcpConn.SetDeadline(time.Now().Add(300 * time.Millisecond))
+	if !s.trackListener(&l, true) {
+		return ErrServerClosed
+	}
+	defer s.trackListener(&l, false)
+	connCtx := s.connContext()
 
-		c, err := l.Accept()
-		//Check for the channel being closed
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
 		select {
-		case <-s.quit:
-			fmt.Println("finishing task")
-			fmt.Println("task done")
-			s.quit <- true
-			return nil
-		default:
-			//If the channel is still open, continue as normal
+		case <-ctx.Done():
+			l.Close()
+		case <-stop:
 		}
+	}()
+
+	var tempDelay time.Duration
+	for {
+		c, err := l.Accept()
 		if err != nil {
-			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				log.Printf("accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
 				continue
 			}
-
+			return err
 		}
+		tempDelay = 0
 
-		go s.handle(c, gateway)
-
+		if !s.trackConn(c, true) {
+			c.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.trackConn(c, false)
+			s.handle(connCtx, c, gateway)
+		}()
 	}
 }
 
-func (s *Server) handle(c io.ReadWriteCloser, gateway Gateway) {
+func (s *Server) handle(ctx context.Context, c net.Conn, gateway Gateway) {
 	defer c.Close()
-	req, err := parseRequest(c)
+
+	if s.ReadTimeout > 0 {
+		c.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	}
+	er := &eofReader{r: c}
+	req, err := parseRequest(er)
+
 	var resp response
 	if err != nil {
 		log.Printf("could not parse request due: %v", err)
 		resp = &paymentResponse{CommonError}
+		s.setWriteDeadline(c)
 		resp.Write(c)
 		return
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if s.RequestTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.RequestTimeout)
+		defer cancel()
+	}
+
+	// A peer which closed its write side already could not be watched
+	// for disconnection, as reads will always return EOF.
+	if !er.eof {
+		c.SetReadDeadline(time.Time{})
+		go cancelOnDisconnect(c, cancel)
+	}
+
 	if req.IsForBillCheck() {
-		if cb, err := gateway.GetCurrentBill(req.CustomerID, req.TransactionID); err != nil {
+		if cb, err := gateway.GetCurrentBill(ctx, req.CustomerID, req.TransactionID); err != nil {
+			log.Printf("unable to call billing due: %v", err)
 			resp = &billResponse{Status: CommonError}
 		} else {
-			log.Printf("unable to call billing due: %v", err)
 			resp = &billResponse{Amount: cb.Amount, Status: cb.Status()}
 		}
 	} else if req.IsForPayment() {
-		pr, err := gateway.PayBill(req.CustomerID, req.TransactionID, req.Amount)
+		pr, err := gateway.PayBill(ctx, req.CustomerID, req.TransactionID, req.Amount)
 		if err != nil {
+			log.Printf("unable to call billing due: %v", err)
 			resp = &paymentResponse{CommonError}
 		} else {
 			resp = &paymentResponse{pr.Status()}
 		}
 	}
 
-	resp.Write(c)
+	// the write timeout starts after the gateway call, so a slow billing
+	// system doesn't leave less time for the response of a booked payment
+	s.setWriteDeadline(c)
+	if _, err := resp.Write(c); err != nil {
+		log.Printf("could not write response due: %v", err)
+	}
+}
+
+func (s *Server) setWriteDeadline(c net.Conn) {
+	if s.WriteTimeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	}
+}
+
+// connContext returns the context of the connections which is cancelled
+// only by Close.
+func (s *Server) connContext() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.connCtx == nil {
+		s.connCtx, s.cancelConns = context.WithCancel(context.Background())
+	}
+	return s.connCtx
 }
 
-// Stop stops listening of incomming connections and terminates
-// server instance
-func (s *Server) Stop() {
-	s.quit <- true
-	<-s.quit
+// cancelOnDisconnect blocks until the connection is reset by the peer or it's
+// closed by the server and cancels the request afterwards. The EOF of a peer
+// which closed its write side is not a disconnection, as the peer could still
+// wait for the response, so the request is left to its timeout then.
+func cancelOnDisconnect(c net.Conn, cancel context.CancelFunc) {
+	buf := make([]byte, 1)
+	for {
+		if _, err := c.Read(buf); err != nil {
+			if err != io.EOF {
+				cancel()
+			}
+			return
+		}
+	}
+}
+
+// Shutdown gracefully shuts down the server without interrupting any active
+// connections. Shutdown works by first closing all open listeners and then
+// waiting for the in-flight connections to be handled. If the provided context
+// expires before the shutdown is complete, the remaining connections are closed
+// and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	err := s.closeListenersLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.activeConns() == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all active listeners and connections. For a graceful
+// shutdown use Shutdown.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inShutdown = true
+	err := s.closeListenersLocked()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+	if s.cancelConns != nil {
+		s.cancelConns()
+	}
+	return err
+}
+
+func (s *Server) closeListenersLocked() error {
+	var err error
+	for l := range s.listeners {
+		if cerr := (*l).Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (s *Server) trackListener(l *net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[*net.Listener]struct{})
+	}
+	if add {
+		if s.inShutdown {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) trackConn(c net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	if add {
+		if s.inShutdown {
+			return false
+		}
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+	return true
+}
+
+func (s *Server) activeConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+// onceCloseListener wraps a net.Listener, protecting it from
+// multiple Close calls.
+type onceCloseListener struct {
+	net.Listener
+	once     sync.Once
+	closeErr error
+}
+
+func (oc *onceCloseListener) Close() error {
+	oc.once.Do(func() { oc.closeErr = oc.Listener.Close() })
+	return oc.closeErr
+}
+
+// eofReader is a reader which keeps track whether the end of the
+// underlying stream was reached.
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err == io.EOF {
+		e.eof = true
+	}
+	return n, err
 }
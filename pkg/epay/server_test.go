@@ -1,18 +1,21 @@
 package epay
 
 import (
+	"context"
 	"io"
+	"io/ioutil"
 	"net"
 	"testing"
+	"time"
 
 	"github.com/clouway/go-epay/pkg/epay/epaytest"
 )
 
 func TestGetCurrentBill(t *testing.T) {
 	s := NewServer()
-	defer s.Stop()
+	defer s.Close()
 	l, _ := net.Listen("tcp", ":0")
-	go s.Serve(l, &fakeGateway{billResponse: &BillResponse{Successful: true, Amount: 360}, err: nil})
+	go s.Serve(context.Background(), l, &fakeGateway{billResponse: &BillResponse{Successful: true, Amount: 360}, err: nil})
 
 	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
 	defer tearDown()
@@ -25,9 +28,9 @@ func TestGetCurrentBill(t *testing.T) {
 
 func TestGetCurrentBillFails(t *testing.T) {
 	s := NewServer()
-	defer s.Stop()
+	defer s.Close()
 	l, _ := net.Listen("tcp", ":0")
-	go s.Serve(l, &fakeGateway{err: io.ErrClosedPipe})
+	go s.Serve(context.Background(), l, &fakeGateway{err: io.ErrClosedPipe})
 
 	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
 	defer tearDown()
@@ -40,9 +43,9 @@ func TestGetCurrentBillFails(t *testing.T) {
 
 func TestGatewaySendsBrokenRequest(t *testing.T) {
 	s := NewServer()
-	defer s.Stop()
+	defer s.Close()
 	l, _ := net.Listen("tcp", ":0")
-	go s.Serve(l, nil)
+	go s.Serve(context.Background(), l, nil)
 
 	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
 	defer tearDown()
@@ -55,9 +58,9 @@ func TestGatewaySendsBrokenRequest(t *testing.T) {
 
 func TestPayBill(t *testing.T) {
 	s := NewServer()
-	defer s.Stop()
+	defer s.Close()
 	l, _ := net.Listen("tcp", ":0")
-	go s.Serve(l, &fakeGateway{paymentResponse: &PaymentResponse{Successful: true}, err: nil})
+	go s.Serve(context.Background(), l, &fakeGateway{paymentResponse: &PaymentResponse{Successful: true}, err: nil})
 
 	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
 	defer tearDown()
@@ -70,9 +73,9 @@ func TestPayBill(t *testing.T) {
 
 func TestPayBillWasNotSuccessful(t *testing.T) {
 	s := NewServer()
-	defer s.Stop()
+	defer s.Close()
 	l, _ := net.Listen("tcp", ":0")
-	go s.Serve(l, &fakeGateway{paymentResponse: &PaymentResponse{Successful: false}, err: nil})
+	go s.Serve(context.Background(), l, &fakeGateway{paymentResponse: &PaymentResponse{Successful: false}, err: nil})
 
 	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
 	defer tearDown()
@@ -85,9 +88,9 @@ func TestPayBillWasNotSuccessful(t *testing.T) {
 
 func TestBillAlreadyPaid(t *testing.T) {
 	s := NewServer()
-	defer s.Stop()
+	defer s.Close()
 	l, _ := net.Listen("tcp", ":0")
-	go s.Serve(l, &fakeGateway{paymentResponse: &PaymentResponse{AlreadyPaid: true, Successful: false}, err: nil})
+	go s.Serve(context.Background(), l, &fakeGateway{paymentResponse: &PaymentResponse{AlreadyPaid: true, Successful: false}, err: nil})
 
 	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
 	defer tearDown()
@@ -98,16 +101,243 @@ func TestBillAlreadyPaid(t *testing.T) {
 	}
 }
 
+func TestShutdownWaitsForActiveConnections(t *testing.T) {
+	s := NewServer()
+	l, _ := net.Listen("tcp", ":0")
+	release := make(chan struct{})
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(context.Background(), l, &fakeGateway{billResponse: &BillResponse{Successful: true, Amount: 360}, wait: release})
+	}()
+
+	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
+	defer tearDown()
+	responses := make(chan string, 1)
+	go func() {
+		responses <- epayServer.GetCurrentBill("123", "T1")
+	}()
+	// wait till connection is accepted by the server
+	for s.activeConns() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected Serve to return: %v", ErrServerClosed)
+		t.Errorf("                      got: %v", err)
+	}
+	close(release)
+
+	if err := <-shutdown; err != nil {
+		t.Errorf("unexpected shutdown error: %v", err)
+	}
+	if exp, got := "XTYPE=RBN\nXVALIDTO=\nAMOUNT=360\nSTATUS=00\n", <-responses; exp != got {
+		t.Errorf("expected: %s", exp)
+		t.Errorf("     got: %s", got)
+	}
+}
+
+func TestShutdownDeadlineClosesConnections(t *testing.T) {
+	s := NewServer()
+	l, _ := net.Listen("tcp", ":0")
+	release := make(chan struct{})
+	defer close(release)
+	go s.Serve(context.Background(), l, &fakeGateway{wait: release})
+
+	_, tearDown := epaytest.NewServer(t, l.Addr().String())
+	defer tearDown()
+	for s.activeConns() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected: %v", context.DeadlineExceeded)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestShutdownBeforeServe(t *testing.T) {
+	s := NewServer()
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+
+	l, _ := net.Listen("tcp", ":0")
+	if err := s.Serve(context.Background(), l, nil); err != ErrServerClosed {
+		t.Errorf("expected: %v", ErrServerClosed)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestServeStopsWhenContextIsCancelled(t *testing.T) {
+	s := NewServer()
+	l, _ := net.Listen("tcp", ":0")
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ctx, l, nil)
+	}()
+
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("expected: %v", context.Canceled)
+		t.Errorf("     got: %v", err)
+	}
+}
+
+func TestCancelledServeDrainsActiveConnections(t *testing.T) {
+	s := NewServer()
+	l, _ := net.Listen("tcp", ":0")
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	gateway := &fakeGateway{billResponse: &BillResponse{Successful: true, Amount: 360}, wait: release}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(ctx, l, gateway)
+	}()
+
+	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
+	defer tearDown()
+	responses := make(chan string, 1)
+	go func() {
+		responses <- epayServer.GetCurrentBill("123", "T1")
+	}()
+	for s.activeConns() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-served; err != context.Canceled {
+		t.Errorf("expected: %v", context.Canceled)
+		t.Errorf("     got: %v", err)
+	}
+	close(release)
+
+	if exp, got := "XTYPE=RBN\nXVALIDTO=\nAMOUNT=360\nSTATUS=00\n", <-responses; exp != got {
+		t.Errorf("expected: %s", exp)
+		t.Errorf("     got: %s", got)
+	}
+	if err := gateway.ctxErr; err != nil {
+		t.Errorf("expected gateway context to be active, but got: %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	s := NewServer()
+	s.RequestTimeout = 10 * time.Millisecond
+	defer s.Close()
+	l, _ := net.Listen("tcp", ":0")
+	gateway := &blockingGateway{cancelled: make(chan error, 1)}
+	go s.Serve(context.Background(), l, gateway)
+
+	epayServer, tearDown := epaytest.NewServer(t, l.Addr().String())
+	defer tearDown()
+	response := epayServer.PayBill("123", "T1", 10)
+	if exp := "XTYPE=RBC\nSTATUS=96\n"; exp != response {
+		t.Errorf("expected: %s", exp)
+		t.Errorf("     got: %s", response)
+	}
+	if err := <-gateway.cancelled; err != context.DeadlineExceeded {
+		t.Errorf("expected gateway context to be cancelled with: %v", context.DeadlineExceeded)
+		t.Errorf("                                          got: %v", err)
+	}
+}
+
+func TestRequestIsCancelledWhenPeerDisconnects(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	l, _ := net.Listen("tcp", ":0")
+	gateway := &blockingGateway{cancelled: make(chan error, 1)}
+	go s.Serve(context.Background(), l, gateway)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect to testing server due: %v", err)
+	}
+	c.Write([]byte("XTYPE=QBN\nIDN=123\nTID=T1\n\n"))
+	// the connection is reset instead of closed gracefully
+	c.(*net.TCPConn).SetLinger(0)
+	c.Close()
+
+	select {
+	case err := <-gateway.cancelled:
+		if err != context.Canceled {
+			t.Errorf("expected: %v", context.Canceled)
+			t.Errorf("     got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("gateway call was not cancelled after disconnect of the peer")
+	}
+}
+
+func TestRequestIsNotCancelledWhenPeerClosesWriteSide(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	l, _ := net.Listen("tcp", ":0")
+	gateway := &fakeGateway{billResponse: &BillResponse{Successful: true, Amount: 360}, wait: make(chan struct{})}
+	go s.Serve(context.Background(), l, gateway)
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to connect to testing server due: %v", err)
+	}
+	defer c.Close()
+	c.Write([]byte("XTYPE=QBN\nIDN=123\nTID=T1\n\n"))
+	c.(*net.TCPConn).CloseWrite()
+
+	// the server reads the EOF while the gateway is called
+	time.Sleep(100 * time.Millisecond)
+	close(gateway.wait)
+
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	response, _ := ioutil.ReadAll(c)
+	if exp := "XTYPE=RBN\nXVALIDTO=\nAMOUNT=360\nSTATUS=00\n"; exp != string(response) {
+		t.Errorf("expected: %s", exp)
+		t.Errorf("     got: %s", response)
+	}
+	if gateway.ctxErr != nil {
+		t.Errorf("expected request to not be cancelled, but got: %v", gateway.ctxErr)
+	}
+}
+
 type fakeGateway struct {
 	billResponse    *BillResponse
 	paymentResponse *PaymentResponse
 	err             error
+	wait            chan struct{}
+	ctxErr          error
 }
 
-func (f *fakeGateway) GetCurrentBill(CustomerID, TransactionID string) (*BillResponse, error) {
+func (f *fakeGateway) GetCurrentBill(ctx context.Context, CustomerID, TransactionID string) (*BillResponse, error) {
+	if f.wait != nil {
+		<-f.wait
+	}
+	f.ctxErr = ctx.Err()
 	return f.billResponse, f.err
 }
 
-func (f *fakeGateway) PayBill(CustomerID, TransactionID string, Amount int) (*PaymentResponse, error) {
+func (f *fakeGateway) PayBill(ctx context.Context, CustomerID, TransactionID string, Amount int) (*PaymentResponse, error) {
 	return f.paymentResponse, f.err
 }
+
+type blockingGateway struct {
+	cancelled chan error
+}
+
+func (b *blockingGateway) GetCurrentBill(ctx context.Context, CustomerID, TransactionID string) (*BillResponse, error) {
+	<-ctx.Done()
+	b.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
+
+func (b *blockingGateway) PayBill(ctx context.Context, CustomerID, TransactionID string, Amount int) (*PaymentResponse, error) {
+	<-ctx.Done()
+	b.cancelled <- ctx.Err()
+	return nil, ctx.Err()
+}
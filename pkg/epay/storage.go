@@ -11,17 +11,37 @@ type PaymentOrderRecord struct {
 	SubscriberID  string
 	CustomerName  string
 	ClientID      string
-	Amount        string
+	Amount        Money
+	PaidAmount    Money
 	CreatedAt     time.Time
 	ProcessedOn   time.Time
+	ClaimedAt     time.Time
 	InvoiceIDs    []string
 }
 
 // PaymentOrderStore is the interface for storing payment orders.
 type PaymentOrderStore interface {
+	// Create saves a new payment order. TransactionID is used as the key.
+	// ErrPaymentOrderAlreadyExists is returned when an order with the same
+	// TransactionID exists already, so it's never overwritten.
+	Create(ctx context.Context, po *PaymentOrderRecord) error
+
 	// Put saves a payment order. TransactionID is used as the key.
 	Put(ctx context.Context, po *PaymentOrderRecord) error
 
 	// Get retrieves a payment order by TransactionID.
 	Get(ctx context.Context, transactionID string) (*PaymentOrderRecord, error)
+
+	// Claim atomically marks the payment order as being paid by the caller. Claims
+	// older than the provided lease are considered as abandoned and are taken over.
+	// ErrPaymentOrderAlreadyPaid is returned when the order was processed already and
+	// ErrPaymentOrderInProgress when it's claimed by another payment.
+	Claim(ctx context.Context, transactionID string, lease time.Duration) error
+
+	// Release releases the claim of a payment order which was not processed.
+	Release(ctx context.Context, transactionID string) error
+
+	// Delete deletes a payment order which was not claimed or processed, e.g
+	// when it could not be created in the billing system.
+	Delete(ctx context.Context, transactionID string) error
 }
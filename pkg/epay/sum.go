@@ -1,8 +1,11 @@
 package epay
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"net/url"
@@ -11,8 +14,51 @@ import (
 	"strings"
 )
 
-// Checksum calculates the Values checksum using the epay specific format.
+// ChecksumAlgorithm is the algorithm of the checksums of the requests.
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumHMACSHA1 is HMAC-SHA1 which is used by ePay by default
+	ChecksumHMACSHA1 ChecksumAlgorithm = "hmac-sha1"
+	// ChecksumHMACSHA256 is HMAC-SHA256 which is used by the newer protocols of ePay
+	ChecksumHMACSHA256 ChecksumAlgorithm = "hmac-sha256"
+)
+
+// ChecksumPayload is the payload of the requests which is covered by the checksum.
+type ChecksumPayload string
+
+const (
+	// ChecksumPayloadQuery covers all parameters of the request sorted by name
+	ChecksumPayloadQuery ChecksumPayload = "query"
+	// ChecksumPayloadEncoded covers the base64 ENCODED parameter which is
+	// having the parameters of the request as KEY=VALUE lines
+	ChecksumPayloadEncoded ChecksumPayload = "encoded"
+)
+
+// Checksum calculates the Values checksum using the epay specific format
+// and HMAC-SHA1.
 func Checksum(q url.Values, secret string) string {
+	return Sign(ChecksumHMACSHA1, secret, checksumMessage(q))
+}
+
+// Sign signs the message with the provided algorithm and returns the
+// signature encoded as lower case HEX.
+func Sign(alg ChecksumAlgorithm, secret string, message []byte) string {
+	return hex.EncodeToString(sign(alg, secret, message))
+}
+
+func sign(alg ChecksumAlgorithm, secret string, message []byte) []byte {
+	h := hmac.New(sha1.New, []byte(secret))
+	if alg == ChecksumHMACSHA256 {
+		h = hmac.New(sha256.New, []byte(secret))
+	}
+	h.Write(message)
+	return h.Sum(nil)
+}
+
+// checksumMessage builds the message of the values as KEY+VALUE lines sorted
+// by key. The CHECKSUM is not part of the message.
+func checksumMessage(q url.Values) []byte {
 	keys := make([]string, 0, len(q))
 	for k := range q {
 		if k == "CHECKSUM" {
@@ -20,17 +66,85 @@ func Checksum(q url.Values, secret string) string {
 		}
 		keys = append(keys, k)
 	}
-
 	sort.Strings(keys)
-	message := ""
+
+	var b bytes.Buffer
 	for _, k := range keys {
-		message += fmt.Sprintf("%s%s\n", k, q[k][0])
+		for _, v := range q[k] {
+			b.WriteString(k)
+			b.WriteString(v)
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes()
+}
+
+// VerifyChecksum verifies the CHECKSUM of the request parameters and returns the
+// parameters of the request. The parameters of ChecksumPayloadEncoded requests
+// are decoded from the ENCODED parameter. The checksum is compared in constant
+// time and it's accepted in both lower and upper case HEX. Requests with
+// duplicated parameters are rejected as they could be read differently by the
+// verification and by the handlers.
+func VerifyChecksum(q url.Values, secret string, alg ChecksumAlgorithm, payload ChecksumPayload) (url.Values, error) {
+	for k, v := range q {
+		if len(v) > 1 {
+			return nil, fmt.Errorf("%w: duplicated parameter '%s'", ErrInvalidChecksum, k)
+		}
 	}
 
-	key := []byte(secret)
-	h := hmac.New(sha1.New, key)
-	h.Write([]byte(message))
-	return hex.EncodeToString(h.Sum(nil))
+	checksum, err := hex.DecodeString(q.Get("CHECKSUM"))
+	if err != nil || len(checksum) == 0 {
+		return nil, fmt.Errorf("%w: missing or malformed checksum", ErrInvalidChecksum)
+	}
+
+	message := checksumMessage(q)
+	if payload == ChecksumPayloadEncoded {
+		message = []byte(q.Get("ENCODED"))
+	}
+	if !hmac.Equal(checksum, sign(alg, secret, message)) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidChecksum)
+	}
+
+	if payload != ChecksumPayloadEncoded {
+		return q, nil
+	}
+	return decodePayload(q)
+}
+
+// decodePayload decodes the parameters of the ENCODED parameter. The encoded
+// parameters are separated by new lines or colons, e.g IDN=123:TID=456. Requests
+// with other parameters than ENCODED and CHECKSUM are rejected, as they are not
+// covered by the checksum.
+func decodePayload(q url.Values) (url.Values, error) {
+	encoded := q.Get("ENCODED")
+	if encoded == "" {
+		return nil, fmt.Errorf("%w: missing encoded payload", ErrInvalidChecksum)
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed encoded payload", ErrInvalidChecksum)
+	}
+
+	for k := range q {
+		if k != "ENCODED" && k != "CHECKSUM" {
+			return nil, fmt.Errorf("%w: parameter '%s' is not encoded", ErrInvalidChecksum, k)
+		}
+	}
+
+	params := url.Values{}
+
+	fields := strings.FieldsFunc(string(b), func(r rune) bool { return r == '\n' || r == '\r' || r == ':' })
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("%w: malformed encoded parameter '%s'", ErrInvalidChecksum, field)
+		}
+		if _, dup := params[kv[0]]; dup {
+			return nil, fmt.Errorf("%w: duplicated parameter '%s'", ErrInvalidChecksum, kv[0])
+		}
+		params.Set(kv[0], kv[1])
+	}
+	return params, nil
 }
 
 // IsContractCode validates a 7-digit code with Luhn checksum.
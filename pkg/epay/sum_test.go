@@ -1,7 +1,10 @@
 package epay
 
 import (
+	"encoding/base64"
+	"errors"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -21,3 +24,77 @@ func TestSum(t *testing.T) {
 		t.Errorf("                           but was: %s", cs)
 	}
 }
+
+func TestVerifyChecksum(t *testing.T) {
+	values := url.Values{
+		"IDN":        []string{"::idn::"},
+		"MERCHANTID": []string{"MARCHANTID"},
+	}
+	values.Set("CHECKSUM", strings.ToUpper(Checksum(values, "mysecret")))
+
+	params, err := VerifyChecksum(values, "mysecret", ChecksumHMACSHA1, ChecksumPayloadQuery)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := params.Get("IDN"); got != "::idn::" {
+		t.Errorf("expected IDN to be ::idn:: but was: %s", got)
+	}
+
+	if _, err := VerifyChecksum(values, "othersecret", ChecksumHMACSHA1, ChecksumPayloadQuery); !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("expected invalid checksum with other secret but got: %v", err)
+	}
+	if _, err := VerifyChecksum(values, "mysecret", ChecksumHMACSHA256, ChecksumPayloadQuery); !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("expected invalid checksum with other algorithm but got: %v", err)
+	}
+}
+
+func TestVerifyChecksumRejectsDuplicatedParameters(t *testing.T) {
+	values := url.Values{
+		"IDN":        []string{"::idn::", "::other::"},
+		"MERCHANTID": []string{"MARCHANTID"},
+	}
+	values.Set("CHECKSUM", Checksum(values, "mysecret"))
+
+	if _, err := VerifyChecksum(values, "mysecret", ChecksumHMACSHA1, ChecksumPayloadQuery); !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("expected duplicated parameters to be rejected but got: %v", err)
+	}
+}
+
+func TestVerifyEncodedChecksum(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("IDN=123\nTID=456\nTYPE=CHECK\n"))
+	values := url.Values{
+		"ENCODED":  []string{encoded},
+		"CHECKSUM": []string{Sign(ChecksumHMACSHA256, "mysecret", []byte(encoded))},
+	}
+
+	params, err := VerifyChecksum(values, "mysecret", ChecksumHMACSHA256, ChecksumPayloadEncoded)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := url.Values{"IDN": []string{"123"}, "TID": []string{"456"}, "TYPE": []string{"CHECK"}}
+	if params.Encode() != want.Encode() {
+		t.Errorf("expected params to be: %s", want.Encode())
+		t.Errorf("              but was: %s", params.Encode())
+	}
+
+	values.Set("ENCODED", base64.StdEncoding.EncodeToString([]byte("IDN=124\nTID=456\n")))
+	if _, err := VerifyChecksum(values, "mysecret", ChecksumHMACSHA256, ChecksumPayloadEncoded); !errors.Is(err, ErrInvalidChecksum) {
+		t.Errorf("expected tampered payload to be rejected but got: %v", err)
+	}
+}
+
+func TestVerifyEncodedChecksumRejectsParametersOutsideOfPayload(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte("IDN=123\nTID=456\nTYPE=BILLING\n"))
+	checksum := Sign(ChecksumHMACSHA256, "mysecret", []byte(encoded))
+
+	for _, param := range []string{"AMOUNT", "TYPE", "MERCHANTID"} {
+		values := url.Values{
+			"ENCODED":  []string{encoded},
+			"CHECKSUM": []string{checksum},
+			param:      []string{"1"},
+		}
+		if _, err := VerifyChecksum(values, "mysecret", ChecksumHMACSHA256, ChecksumPayloadEncoded); !errors.Is(err, ErrInvalidChecksum) {
+			t.Errorf("expected injected %s to be rejected but got: %v", param, err)
+		}
+	}
+}
@@ -2,10 +2,11 @@ package epay
 
 import (
 	"errors"
-	"strconv"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
 	"time"
-
-	"github.com/clouway/go-epay/pkg/number"
 )
 
 var (
@@ -21,10 +22,50 @@ var (
 	// when PaymentOrder was already paid
 	ErrPaymentOrderAlreadyPaid = errors.New("payment order was already paid")
 
+	// ErrPaymentOrderInProgress is the error used during payment
+	// when PaymentOrder is being paid by another request
+	ErrPaymentOrderInProgress = errors.New("payment order is being paid")
+
 	// ErrSubscriberNotFound is the error used for indication when
 	// subscriber was not found
 	ErrSubscriberNotFound = errors.New("the requested subscriber was not found")
 
+	// ErrAmountMismatch is the error used during payment when the
+	// paid amount is not accepted for the amount of the PaymentOrder
+	ErrAmountMismatch = errors.New("paid amount does not match the amount of the payment order")
+
+	// ErrInvalidAmount is the error used when an amount could not
+	// be represented exactly in the minor units of its currency
+	ErrInvalidAmount = errors.New("invalid amount")
+
+	// ErrCurrencyMismatch is the error used when amounts in different
+	// currencies are combined
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+
+	// ErrEnvironmentNotFound is the error used when the environment
+	// was not found in the store
+	ErrEnvironmentNotFound = errors.New("environment was not found")
+
+	// ErrInvalidConfig is the error used when the billing configuration
+	// of the environment is not valid
+	ErrInvalidConfig = errors.New("invalid billing configuration")
+
+	// ErrInvalidChecksum is the error used when the checksum of
+	// a request could not be verified
+	ErrInvalidChecksum = errors.New("invalid checksum")
+
+	// ErrBillingUnavailable is the error used when the billing system
+	// is not responding and the requests to it are short-circuited
+	ErrBillingUnavailable = errors.New("billing system is temporary not available")
+
+	// ErrBillingUnauthorized is the error used when the billing system
+	// rejects the credentials of the environment
+	ErrBillingUnauthorized = errors.New("billing system rejected the credentials")
+
+	// ErrBillingRejected is the error used when the billing system
+	// rejects a request as invalid
+	ErrBillingRejected = errors.New("billing system rejected the request")
+
 	// ErrUnknown is the error which is return when no known cases
 	// are recognized by the code
 	ErrUnknown = errors.New("unknown error")
@@ -44,25 +85,182 @@ type Environment struct {
 	BillingURL string
 
 	// EpaySecret is the secret that is provided from ePay for verification
-	// of the Checksum using HMAC SHA1 encoded as HEX
+	// of the Checksum using HMAC encoded as HEX
 	EpaySecret string
 
+	// ChecksumAlgorithm is the algorithm of the checksums of the requests.
+	// ChecksumHMACSHA1 is used when it's empty.
+	ChecksumAlgorithm ChecksumAlgorithm
+
+	// ChecksumPayload is the payload of the requests which is covered by the
+	// checksum. ChecksumPayloadQuery is used when it's empty.
+	ChecksumPayload ChecksumPayload
+
+	// SignResponses enables signing of the responses to ePay with the
+	// checksum algorithm and the secret of the environment
+	SignResponses bool
+
+	// AllowedNetworks are the networks, as CIDRs or IPs, from which the
+	// requests of ePay are accepted. Requests from any network are accepted
+	// when it's empty.
+	AllowedNetworks []string
+
 	// MerchantID is the identifier of the merchant which was issued by ePay
 	// provider
 	MerchantID string
 
+	// AmountPolicy is the policy used for payments which amount differs
+	// from the amount of the payment order
+	AmountPolicy AmountPolicy
+
+	// BillingSystem is the name of the billing system which serves the
+	// environment, e.g telcong or ucrm. The billing system is selected by
+	// the client factory when it's empty.
+	BillingSystem string
+
+	// BillingRoutes route the requests of the subscribers to the billing
+	// systems of the environment. They are used only when BillingSystem
+	// is empty.
+	BillingRoutes []BillingRoute
+
+	// SubscriberRules are the policies of the requests of the subscribers,
+	// e.g blocked IDNs or maintenance windows. The first matching rule
+	// is applied.
+	SubscriberRules []SubscriberRule
+
+	// Currency is the currency of the amounts exchanged with ePay. Amounts of
+	// the billing system are converted to it when they are in another currency.
+	// No conversion is made when it's empty.
+	Currency string
+
 	// Metadata is a set of key-value pairs keeping for keeping of internal metadata attributes
 	Metadata map[string]string
 }
 
+// Validate validates the settings of the environment which are common for
+// all billing systems. The configuration of the billing system is validated
+// by the client of it. The EpaySecret is not required, because it's used only
+// by the HTTP API which rejects the requests to environments without it.
+func (e *Environment) Validate() error {
+	switch e.AmountPolicy {
+	case "", AmountPolicyExact, AmountPolicyPartial, AmountPolicyOver, AmountPolicyAny:
+	default:
+		return fmt.Errorf("unknown amount policy '%s'", e.AmountPolicy)
+	}
+
+	switch e.ChecksumAlgorithm {
+	case "", ChecksumHMACSHA1, ChecksumHMACSHA256:
+	default:
+		return fmt.Errorf("unknown checksum algorithm '%s'", e.ChecksumAlgorithm)
+	}
+
+	switch e.ChecksumPayload {
+	case "", ChecksumPayloadQuery, ChecksumPayloadEncoded:
+	default:
+		return fmt.Errorf("unknown checksum payload '%s'", e.ChecksumPayload)
+	}
+
+	if _, err := e.Networks(); err != nil {
+		return err
+	}
+
+	for i := range e.SubscriberRules {
+		if err := e.SubscriberRules[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	for i := range e.BillingRoutes {
+		if err := e.BillingRoutes[i].Validate(); err != nil {
+			return err
+		}
+	}
+
+	switch strings.ToUpper(e.Currency) {
+	case "", CurrencyBGN, CurrencyEUR:
+	default:
+		return fmt.Errorf("unsupported currency '%s'", e.Currency)
+	}
+	return nil
+}
+
+// Networks parses the AllowedNetworks of the environment. The single IPs are
+// converted to networks with a full mask.
+func (e *Environment) Networks() ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, v := range e.AllowedNetworks {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid allowed network '%s'", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed network '%s'", v)
+		}
+		networks = append(networks, n)
+	}
+	return networks, nil
+}
+
+// BillingRoute routes the requests of the subscribers which match it to
+// a billing system. A route without conditions matches all subscribers.
+type BillingRoute struct {
+	// BillingSystem is the name of the billing system which serves the
+	// matched subscribers
+	BillingSystem string `json:"billingSystem"`
+
+	// Pattern is a regular expression which the IDN of the subscriber
+	// should match
+	Pattern string `json:"pattern,omitempty"`
+
+	// ContractCode requires the IDN to be a valid contract code
+	ContractCode bool `json:"contractCode,omitempty"`
+
+	// re is the Pattern compiled by Validate
+	re *regexp.Regexp
+}
+
+// Validate compiles the pattern of the route, so it's not compiled again for
+// each routed request.
+func (r *BillingRoute) Validate() error {
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern of route to '%s': %v", r.BillingSystem, err)
+	}
+	r.re = re
+	return nil
+}
+
+// MatchesPattern reports whether the IDN matches the pattern of the route. A
+// route without a pattern matches all IDNs.
+func (r *BillingRoute) MatchesPattern(idn string) (bool, error) {
+	if r.Pattern == "" {
+		return true, nil
+	}
+	re, err := compilePattern(r.re, r.Pattern)
+	if err != nil {
+		return false, fmt.Errorf("invalid pattern of route to '%s': %v", r.BillingSystem, err)
+	}
+	return re.MatchString(idn), nil
+}
+
 // SubscriberDuties represents duties of the subscriber
 type SubscriberDuties struct {
-	CustomerName string   `json:"customerName"`
-	CustomerRef  string   `json:"customerRef"`
-	Address      string   `json:"address"`
-	DutyAmount   Amount   `json:"dutyAmount"`
-	Items        []Item   `json:"items"`
-	DocumentIDs  []string `json:"documents"`
+	CustomerName string    `json:"customerName"`
+	CustomerRef  string    `json:"customerRef"`
+	Address      string    `json:"address"`
+	DutyAmount   Amount    `json:"dutyAmount"`
+	DueDate      time.Time `json:"dueDate"`
+	Items        []Item    `json:"items"`
+	DocumentIDs  []string  `json:"documents"`
 }
 
 // PaymentSource is representing the source of the payment
@@ -76,6 +274,18 @@ type CreatePaymentOrderRequest struct {
 	TransactionID string        `json:"transactionId"`
 }
 
+// PayPaymentOrderRequest represents the request for payment of
+// an existing payment order
+type PayPaymentOrderRequest struct {
+	// OrderID is the ID of the order or the transactionID associated with it.
+	OrderID string `json:"orderId"`
+
+	// Amount is the amount which was actually paid. When it's empty the amount
+	// of the payment order is considered as paid. It could be in another currency
+	// than the payment order and billing systems convert it when needed.
+	Amount Amount `json:"amount"`
+}
+
 // PayPaymentOrderResponse is representing the respons which is returned when payment
 // order is paid
 type PayPaymentOrderResponse struct {
@@ -115,8 +325,22 @@ type Amount struct {
 	Currency string `json:"currency"`
 }
 
-// InCoins gets the amount value in coins.
-func (a Amount) InCoins() int {
-	amount, _ := strconv.ParseFloat(a.Value, 64)
-	return int(number.Round(amount*100.00, 2))
+// AmountFromCoins creates a new Amount from a value in coins.
+func AmountFromCoins(coins int, currency string) Amount {
+	return NewMoney(int64(coins), currency).Amount()
+}
+
+// Money parses the amount. ErrInvalidAmount is returned when the value
+// is not an exact amount in the currency.
+func (a Amount) Money() (Money, error) {
+	return ParseMoney(a.Value, a.Currency)
+}
+
+// InCoins gets the amount value in coins, i.e the minor units of the currency.
+func (a Amount) InCoins() (int, error) {
+	m, err := a.Money()
+	if err != nil {
+		return 0, err
+	}
+	return int(m.Units()), nil
 }
@@ -1,9 +1,12 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 
 	log "github.com/sirupsen/logrus"
 
@@ -24,42 +27,129 @@ func CheckBill(cf epay.ClientFactory) http.Handler {
 		contextLogger := log.WithContext(ctx)
 
 		env := r.Context().Value(server.EnvironmentKey).(*epay.Environment)
-		idn := r.URL.Query().Get("IDN")
-		client := cf.Create(ctx, *env, idn)
+		req, ok := parseRequest(ctx, w, r, env, EndpointCheck)
+		if !ok {
+			return
+		}
+		idn := req.IDN
+		client, err := cf.Create(ctx, *env, idn)
+		if err != nil {
+			contextLogger.Printf("could not create billing client due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusTemporaryNotAvailable})
+			return
+		}
 
 		var response *DutyResponse
 
+		currency, requested := responseCurrency(req, env)
+		var amount epay.Money
 		res, err := client.GetSubscriberDuties(r.Context(), idn)
 		if err == nil {
-
-			coins := res.DutyAmount.InCoins()
-			contextLogger.Printf("got duty amount: %d", coins)
-			if coins == 0 {
+			amount, err = amountIn(res.DutyAmount, currency)
+		}
+		if err == nil {
+			contextLogger.Printf("got duty amount: %d", amount.Units())
+			if amount.Sign() <= 0 {
 				response = &DutyResponse{Status: StatusNoDuties}
 			} else {
 				contextLogger.Printf("checking bill of idn: %v", res.Items)
-				response = successResponse(idn, res.CustomerName, res.Items, coins)
+				response = successResponse(idn, res.CustomerName, res.Items, amount)
+				if requested {
+					response.Currency = currency
+				}
 			}
-		} else if err == epay.ErrSubscriberNotFound {
+		} else if errors.Is(err, epay.ErrSubscriberNotFound) {
 			contextLogger.Printf("subscriber '%s' was not found", idn)
 			// not valid idn number
 			response = &DutyResponse{Status: StatusSubscriberNotFound}
 		} else {
-			contextLogger.Printf("got unknown error: %v", err)
-			// temporary not available
-			response = &DutyResponse{Status: StatusTemporaryNotAvailable}
+			response = &DutyResponse{Status: billingErrorStatus(ctx, err, StatusTemporaryNotAvailable)}
 		}
 
 		httputil.RespondWithJSON(ctx, w, response)
 	})
 }
 
-func successResponse(subscriberID, customerName string, items []epay.Item, coins int) *DutyResponse {
+// responseCurrency returns the currency of the amounts in the response. ePay
+// could request the currency with the CURRENCY parameter, otherwise the currency
+// of the environment is used.
+func responseCurrency(req *Request, env *epay.Environment) (string, bool) {
+	if req.Currency != "" {
+		return req.Currency, true
+	}
+	return env.Currency, false
+}
+
+// amountIn parses the amount and converts it to the provided currency.
+func amountIn(a epay.Amount, currency string) (epay.Money, error) {
+	m, err := a.Money()
+	if err != nil {
+		return epay.Money{}, err
+	}
+	return m.Convert(currency)
+}
+
+// billingErrorStatus logs the unexpected error of the billing system and returns
+// the status of the response. Outages are reported as temporary unavailability,
+// so the request could be retried later, and the provided status is returned
+// for the other errors.
+func billingErrorStatus(ctx context.Context, err error, status string) string {
+	contextLogger := log.WithContext(ctx)
+	switch {
+	case epay.IsRetriable(err):
+		contextLogger.Warnf("billing system is not available: %v", err)
+		return StatusTemporaryNotAvailable
+	case errors.Is(err, epay.ErrBillingUnauthorized):
+		contextLogger.Errorf("billing system rejected the credentials: %v", err)
+	case errors.Is(err, epay.ErrBillingRejected):
+		contextLogger.Errorf("billing system rejected the request: %v", err)
+	default:
+		contextLogger.Printf("got unknown error: %v", err)
+	}
+	return status
+}
+
+func successResponse(subscriberID, customerName string, items []epay.Item, amount epay.Money) *DutyResponse {
+	amounts := formatAmounts(amount)
+	return &DutyResponse{
+		IDN:       subscriberID,
+		Status:    "00",
+		ShortDesc: buildShortDesc(subscriberID, amounts),
+		LongDesc:  buildLongDesc(customerName, subscriberID, amounts, items),
+		Amount:    int(amount.Units()),
+	}
+}
+
+// formatAmounts formats the amount for the descriptions. Amounts in BGN or EUR
+// are displayed in both currencies during the euro changeover.
+func formatAmounts(amount epay.Money) string {
+	if amount.Currency() == "" {
+		return ""
+	}
+
+	s := amount.String() + " " + amount.Currency()
+	if other, ok := amount.Counterpart(); ok {
+		s += "/" + other.String() + " " + other.Currency()
+	}
+	return s
+}
+
+func buildShortDesc(subscriberID, amounts string) string {
 	shortDesc := "Абонатен номер: " + subscriberID
-	return &DutyResponse{IDN: subscriberID, Status: "00", ShortDesc: shortDesc, LongDesc: buildLongDesc(customerName, subscriberID, items), Amount: coins}
+	if amounts == "" {
+		return shortDesc
+	}
+
+	// the amounts are displayed only when they fit in the short description
+	for _, desc := range []string{shortDesc + ", " + amounts, "№ " + subscriberID + ", " + amounts} {
+		if utf8.RuneCountInString(desc) <= shortDescMaxLen {
+			return desc
+		}
+	}
+	return shortDesc
 }
 
-func buildLongDesc(customerName string, subscriberID string, items []epay.Item) string {
+func buildLongDesc(customerName string, subscriberID string, amounts string, items []epay.Item) string {
 	lines := []string{}
 	dup := make(map[string]string)
 	for _, item := range items {
@@ -71,7 +161,11 @@ func buildLongDesc(customerName string, subscriberID string, items []epay.Item)
 		lines = append(lines, item.Name)
 	}
 
-	longDesc := fmt.Sprintf("Клиент: %s, Абонатен Номер: %s, Детайли: %s", customerName, subscriberID, strings.Join(lines, ","))
+	longDesc := fmt.Sprintf("Клиент: %s, Абонатен Номер: %s, ", customerName, subscriberID)
+	if amounts != "" {
+		longDesc += fmt.Sprintf("Сума: %s, ", amounts)
+	}
+	longDesc += fmt.Sprintf("Детайли: %s", strings.Join(lines, ","))
 	if len(longDesc) > longDescMaxLen {
 		longDesc = longDesc[0:longDescMaxLen]
 	}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/clouway/go-epay/pkg/epay"
@@ -13,14 +15,14 @@ func TestSuccessResponse(t *testing.T) {
 		subscriberID string
 		customerName string
 		items        []epay.Item
-		cents        int
+		amount       epay.Money
 		want         *DutyResponse
 	}{
 		{
 			name:         "short desc is limited",
 			subscriberID: "1234567",
 			customerName: "ЕРДОАН ЕФРАИМОВ ЕФРАИМОВ",
-			cents:        100,
+			amount:       epay.NewMoney(100, ""),
 			want: &DutyResponse{
 				Status:    "00",
 				IDN:       "1234567",
@@ -29,11 +31,51 @@ func TestSuccessResponse(t *testing.T) {
 				Amount:    100,
 			},
 		},
+		{
+			name:         "amount in BGN is displayed in EUR too",
+			subscriberID: "1234567",
+			customerName: "Иван Иванов",
+			items:        []epay.Item{{Name: "Интернет 04/2025"}},
+			amount:       epay.NewMoney(1999, "BGN"),
+			want: &DutyResponse{
+				Status:    "00",
+				IDN:       "1234567",
+				ShortDesc: "№ 1234567, 19.99 BGN/10.22 EUR",
+				LongDesc:  "Клиент: Иван Иванов, Абонатен Номер: 1234567, Сума: 19.99 BGN/10.22 EUR, Детайли: Интернет 04/2025",
+				Amount:    1999,
+			},
+		},
+		{
+			name:         "amount in EUR is displayed in BGN too",
+			subscriberID: "12",
+			customerName: "Иван Иванов",
+			amount:       epay.NewMoney(1000, "EUR"),
+			want: &DutyResponse{
+				Status:    "00",
+				IDN:       "12",
+				ShortDesc: "Абонатен номер: 12, 10.00 EUR/19.56 BGN",
+				LongDesc:  "Клиент: Иван Иванов, Абонатен Номер: 12, Сума: 10.00 EUR/19.56 BGN, Детайли: ",
+				Amount:    1000,
+			},
+		},
+		{
+			name:         "amounts are not displayed when they do not fit",
+			subscriberID: "1234567890123456789012345678",
+			customerName: "Иван Иванов",
+			amount:       epay.NewMoney(1000, "EUR"),
+			want: &DutyResponse{
+				Status:    "00",
+				IDN:       "1234567890123456789012345678",
+				ShortDesc: "Абонатен номер: 1234567890123456789012345678",
+				LongDesc:  "Клиент: Иван Иванов, Абонатен Номер: 1234567890123456789012345678, Сума: 10.00 EUR/19.56 BGN, Детайли: ",
+				Amount:    1000,
+			},
+		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			got := successResponse(c.subscriberID, c.customerName, c.items, c.cents)
+			got := successResponse(c.subscriberID, c.customerName, c.items, c.amount)
 
 			if diff := cmp.Diff(c.want, got); diff != "" {
 				t.Fatal("unexpected response (-want +got): ", diff)
@@ -41,3 +83,23 @@ func TestSuccessResponse(t *testing.T) {
 		})
 	}
 }
+
+func TestBillingErrorStatus(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{epay.NewBillingError("ucrm", 503, "", ""), StatusTemporaryNotAvailable},
+		{epay.RequestError("ucrm", errors.New("connection refused")), StatusTemporaryNotAvailable},
+		{epay.NewBillingError("ucrm", 401, "", ""), StatusCommonError},
+		{epay.NewBillingError("ucrm", 400, "", ""), StatusCommonError},
+		{errors.New("unknown"), StatusCommonError},
+	}
+
+	for _, c := range cases {
+		if got := billingErrorStatus(context.Background(), c.err, StatusCommonError); got != c.want {
+			t.Errorf("expected: %s for %v", c.want, c.err)
+			t.Errorf("     got: %s", got)
+		}
+	}
+}
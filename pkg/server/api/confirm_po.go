@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
@@ -17,23 +18,77 @@ func ConfirmPaymentOrder(cf epay.ClientFactory) http.Handler {
 		contextLogger := log.WithContext(ctx)
 
 		env := ctx.Value(server.EnvironmentKey).(*epay.Environment)
-		idn := r.URL.Query().Get("IDN")
-		client := cf.Create(r.Context(), *env, idn)
+		req, ok := parseRequest(ctx, w, r, env, EndpointConfirm)
+		if !ok {
+			return
+		}
+		idn := req.IDN
+		client, err := cf.Create(ctx, *env, idn)
+		if err != nil {
+			contextLogger.Printf("could not create billing client due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusTemporaryNotAvailable})
+			return
+		}
 
-		transactionID := r.URL.Query().Get("TID")
+		transactionID := req.TID
 
 		contextLogger.Printf("Confirming payment order with transaction: %s", transactionID)
 
-		_, err := client.PayPaymentOrder(ctx, transactionID)
+		payReq := epay.PayPaymentOrderRequest{OrderID: transactionID}
+
+		// The paid amount is verified only when it's provided by ePay.
+		if req.Amount != nil {
+			paidCoins := *req.Amount
+
+			po, err := client.GetPaymentOrder(ctx, transactionID)
+			if err != nil {
+				contextLogger.Printf("could not get payment order of transaction: %s", transactionID)
+				httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: billingErrorStatus(ctx, err, StatusCommonError)})
+				return
+			}
+
+			// the paid amount is in the requested currency or in the currency
+			// of the payment order when ePay is not sending it, as the currency
+			// of the environment could be changed after the order was created
+			currency := req.Currency
+			if currency == "" {
+				currency = po.Amount.Currency
+			}
+			ordered, err := po.Amount.Convert(currency)
+			if err != nil {
+				contextLogger.Printf("could not convert amount of payment order due: %v", err)
+				httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+				return
+			}
+
+			if err := epay.ReconcileAmount(env.AmountPolicy, ordered, paidCoins); err != nil {
+				contextLogger.Printf("rejecting payment due: %v", err)
+				status := StatusInvalidAmount
+				if !errors.Is(err, epay.ErrAmountMismatch) {
+					status = StatusCommonError
+				}
+				httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: status})
+				return
+			}
+			payReq.Amount = epay.AmountFromCoins(paidCoins, ordered.Currency)
+		}
+
+		_, err = client.PayPaymentOrder(ctx, payReq)
 
 		var response *DutyResponse
 		if err == nil {
 			response = &DutyResponse{Status: StatusSuccess}
-		} else if err == epay.ErrPaymentOrderAlreadyPaid {
+		} else if errors.Is(err, epay.ErrPaymentOrderAlreadyPaid) {
 			response = &DutyResponse{Status: StatusAlreadyPaid}
+		} else if errors.Is(err, epay.ErrAmountMismatch) {
+			response = &DutyResponse{Status: StatusInvalidAmount}
+		} else if errors.Is(err, epay.ErrPaymentOrderInProgress) {
+			// the confirmation could be retried after the concurrent
+			// payment is finished
+			response = &DutyResponse{Status: StatusTemporaryNotAvailable}
 		} else {
-			contextLogger.Printf("could not confirm order due: %v", err)
-			response = &DutyResponse{Status: StatusCommonError}
+			contextLogger.Printf("could not confirm order of transaction: %s", transactionID)
+			response = &DutyResponse{Status: billingErrorStatus(ctx, err, StatusCommonError)}
 		}
 
 		httputil.RespondWithJSON(ctx, w, response)
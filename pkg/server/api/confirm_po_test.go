@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server"
+)
+
+type fakeClient struct {
+	epay.Client
+	order *epay.PaymentOrder
+	paid  *epay.PayPaymentOrderRequest
+}
+
+func (f *fakeClient) GetPaymentOrder(ctx context.Context, orderKey string) (*epay.PaymentOrder, error) {
+	return f.order, nil
+}
+
+func (f *fakeClient) PayPaymentOrder(ctx context.Context, payReq epay.PayPaymentOrderRequest) (*epay.PayPaymentOrderResponse, error) {
+	f.paid = &payReq
+	return &epay.PayPaymentOrderResponse{ID: payReq.OrderID}, nil
+}
+
+type fakeClientFactory struct {
+	client epay.Client
+}
+
+func (f *fakeClientFactory) Create(ctx context.Context, env epay.Environment, idn string) (epay.Client, error) {
+	return f.client, nil
+}
+
+func TestConfirmPaymentOrderInCurrencyOfOrder(t *testing.T) {
+	cases := []struct {
+		name     string
+		query    string
+		status   string
+		wantPaid epay.Amount
+	}{
+		{"currency of order", "AMOUNT=2000", StatusSuccess, epay.Amount{Value: "20.00", Currency: "BGN"}},
+		{"requested currency", "AMOUNT=1023&CURRENCY=EUR", StatusSuccess, epay.Amount{Value: "10.23", Currency: "EUR"}},
+		{"currency of environment", "AMOUNT=1023", StatusInvalidAmount, epay.Amount{}},
+	}
+
+	// the currency of the environment was changed after the order was created
+	env := &epay.Environment{Currency: "EUR"}
+	for _, c := range cases {
+		client := &fakeClient{order: &epay.PaymentOrder{ID: "TID1", Amount: epay.Amount{Value: "20.00", Currency: "BGN"}}}
+
+		req := httptest.NewRequest("GET", "/v1/pay/confirm?TYPE=BILLING&IDN=123&TID=TID1&"+c.query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), server.EnvironmentKey, env))
+		rec := httptest.NewRecorder()
+		ConfirmPaymentOrder(&fakeClientFactory{client: client}).ServeHTTP(rec, req)
+
+		if got, want := strings.TrimSpace(rec.Body.String()), `{"STATUS":"`+c.status+`"}`; got != want {
+			t.Errorf("%s: expected response: %s", c.name, want)
+			t.Errorf("%s:      but it was: %s", c.name, got)
+		}
+		if client.paid != nil && client.paid.Amount != c.wantPaid {
+			t.Errorf("%s: expected paid amount %v, but got: %v", c.name, c.wantPaid, client.paid.Amount)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 
 	log "github.com/sirupsen/logrus"
@@ -16,29 +17,45 @@ func CreatePaymentOrder(cf epay.ClientFactory) http.Handler {
 		ctx := r.Context()
 		contextLogger := log.WithContext(ctx)
 		env := r.Context().Value(server.EnvironmentKey).(*epay.Environment)
-		idn := r.URL.Query().Get("IDN")
-		client := cf.Create(ctx, *env, idn)
+		req, ok := parseRequest(ctx, w, r, env, EndpointBilling)
+		if !ok {
+			return
+		}
+		idn := req.IDN
+		client, err := cf.Create(ctx, *env, idn)
+		if err != nil {
+			contextLogger.Printf("could not create billing client due: %v", err)
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusTemporaryNotAvailable})
+			return
+		}
 
-		transactionID := r.URL.Query().Get("TID")
+		transactionID := req.TID
 		contextLogger.Printf("IDN: %s, TID: %s", idn, transactionID)
 
+		currency, requested := responseCurrency(req, env)
 		res, err := client.CreatePaymentOrder(ctx, epay.CreatePaymentOrderRequest{SubscriberID: idn, TransactionID: transactionID, PaymentSource: EPAY})
 
+		var amount epay.Money
+		if err == nil {
+			amount, err = amountIn(res.Amount, currency)
+		}
+
 		var response *DutyResponse
 		if err == nil {
-			coins := res.Amount.InCoins()
-			if coins == 0 {
+			if amount.Sign() <= 0 {
 				response = &DutyResponse{Status: StatusNoDuties}
 			} else {
-				response = successResponse(idn, res.CustomerName, res.Items, coins)
+				response = successResponse(idn, res.CustomerName, res.Items, amount)
+				if requested {
+					response.Currency = currency
+				}
 			}
-		} else if err == epay.ErrPaymentOrderAlreadyExists {
+		} else if errors.Is(err, epay.ErrPaymentOrderAlreadyExists) {
 			response = &DutyResponse{Status: StatusNoDuties}
-		} else if err == epay.ErrSubscriberNotFound {
+		} else if errors.Is(err, epay.ErrSubscriberNotFound) {
 			response = &DutyResponse{Status: StatusSubscriberNotFound}
 		} else {
-			contextLogger.Printf("got error: %v", err)
-			response = &DutyResponse{Status: StatusCommonError}
+			response = &DutyResponse{Status: billingErrorStatus(ctx, err, StatusCommonError)}
 		}
 
 		httputil.RespondWithJSON(ctx, w, response)
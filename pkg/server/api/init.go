@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// Init creates a new handler of the init requests which dispatches them by their
+// TYPE to the check or the billing handler. The TYPE could be sent only in the
+// ENCODED payload, so it must be used after EpayAPIMiddleware which decodes it.
+func Init(checkHandler, billingHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		switch checkType(strings.ToUpper(r.URL.Query().Get("TYPE"))) {
+		case check:
+			checkHandler.ServeHTTP(w, r)
+		case billing:
+			billingHandler.ServeHTTP(w, r)
+		default:
+			log.WithContext(ctx).Printf("rejecting init request of unknown type '%s'", r.URL.Query().Get("TYPE"))
+			httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: StatusCommonError})
+		}
+	})
+}
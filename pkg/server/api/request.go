@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// Endpoint is the endpoint of the ePay requests which defines their validation rules.
+type Endpoint string
+
+const (
+	// EndpointCheck is the check of the duties, i.e init?TYPE=CHECK
+	EndpointCheck Endpoint = "check"
+	// EndpointBilling is the creation of a payment order, i.e init?TYPE=BILLING
+	EndpointBilling Endpoint = "billing"
+	// EndpointConfirm is the confirmation of the payment, i.e confirm?TYPE=BILLING
+	EndpointConfirm Endpoint = "confirm"
+)
+
+const maxParamLen = 64
+
+var (
+	idnPattern = regexp.MustCompile(`^[0-9A-Za-z._-]+$`)
+	tidPattern = regexp.MustCompile(`^[0-9A-Za-z-]+$`)
+
+	// expiredLayouts are the accepted formats of the EXPIRED parameter
+	expiredLayouts = []string{"20060102150405", "20060102", "02.01.2006"}
+
+	// dateLayouts are the formats of the EXPIRED parameter which are having
+	// only the date
+	dateLayouts = map[string]bool{"20060102": true, "02.01.2006": true}
+)
+
+// Request is a parsed and validated request of ePay.
+type Request struct {
+	// IDN is the identifier of the subscriber
+	IDN string
+
+	// TID is the ID of the transaction of ePay, it's required for
+	// the creation and the confirmation of payment orders
+	TID string
+
+	// MerchantID is the ID of the merchant to which the request is sent
+	MerchantID string
+
+	// Type is the type of the request, i.e CHECK or BILLING
+	Type string
+
+	// Amount is the paid amount in coins. It's nil when ePay is not sending it.
+	Amount *int
+
+	// Currency is the currency requested by ePay. It's empty when ePay is
+	// not requesting a currency.
+	Currency string
+
+	// Expired is the expiry time of the request. The requests which are
+	// having only the date of the expiry are expiring at the end of the
+	// day. It's zero when ePay is not sending it.
+	Expired time.Time
+}
+
+// RequestError is the error of a request which parameters are not valid. The
+// Status is the status of the response to the request.
+type RequestError struct {
+	Status string
+	Param  string
+	Reason string
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Param, e.Reason)
+}
+
+// ParseRequest parses and validates the parameters of the request to the provided
+// endpoint. The IDN and the TYPE are required by all endpoints and the TID is required
+// for the payment orders. The MERCHANTID is optional, but when it's sent it must match
+// the merchant of the environment. The requests which EXPIRED is in the past are
+// rejected. A RequestError is returned when the request is not valid.
+func ParseRequest(q url.Values, env *epay.Environment, endpoint Endpoint) (*Request, error) {
+	req := &Request{
+		IDN:        strings.TrimSpace(q.Get("IDN")),
+		TID:        strings.TrimSpace(q.Get("TID")),
+		MerchantID: strings.TrimSpace(q.Get("MERCHANTID")),
+		Type:       strings.ToUpper(q.Get("TYPE")),
+		Currency:   strings.ToUpper(q.Get("CURRENCY")),
+	}
+
+	wantType := billing
+	if endpoint == EndpointCheck {
+		wantType = check
+	}
+	if req.Type != string(wantType) {
+		return nil, &RequestError{Status: StatusCommonError, Param: "TYPE", Reason: fmt.Sprintf("expected %s but was '%s'", wantType, req.Type)}
+	}
+
+	if env.MerchantID != "" && req.MerchantID != "" && req.MerchantID != env.MerchantID {
+		return nil, &RequestError{Status: StatusCommonError, Param: "MERCHANTID", Reason: fmt.Sprintf("unknown merchant '%s'", req.MerchantID)}
+	}
+
+	if req.IDN == "" || len(req.IDN) > maxParamLen || !idnPattern.MatchString(req.IDN) {
+		return nil, &RequestError{Status: StatusSubscriberNotFound, Param: "IDN", Reason: fmt.Sprintf("malformed subscriber '%s'", req.IDN)}
+	}
+
+	if endpoint != EndpointCheck {
+		if req.TID == "" || len(req.TID) > maxParamLen || !tidPattern.MatchString(req.TID) {
+			return nil, &RequestError{Status: StatusCommonError, Param: "TID", Reason: fmt.Sprintf("malformed transaction '%s'", req.TID)}
+		}
+	}
+
+	if v := q.Get("AMOUNT"); v != "" {
+		amount, err := strconv.Atoi(v)
+		if err != nil || amount <= 0 {
+			return nil, &RequestError{Status: StatusInvalidAmount, Param: "AMOUNT", Reason: fmt.Sprintf("malformed amount '%s'", v)}
+		}
+		req.Amount = &amount
+	}
+
+	switch req.Currency {
+	case "", epay.CurrencyBGN, epay.CurrencyEUR:
+	default:
+		return nil, &RequestError{Status: StatusCommonError, Param: "CURRENCY", Reason: fmt.Sprintf("unknown currency '%s'", req.Currency)}
+	}
+
+	if v := q.Get("EXPIRED"); v != "" {
+		expired, ok := parseExpired(v)
+		if !ok {
+			return nil, &RequestError{Status: StatusCommonError, Param: "EXPIRED", Reason: fmt.Sprintf("malformed time '%s'", v)}
+		}
+		if !time.Now().Before(expired) {
+			return nil, &RequestError{Status: StatusCommonError, Param: "EXPIRED", Reason: fmt.Sprintf("request expired at %s", v)}
+		}
+		req.Expired = expired
+	}
+
+	return req, nil
+}
+
+// parseRequest parses the request to the endpoint and responds with the status
+// of the error when it's not valid.
+func parseRequest(ctx context.Context, w http.ResponseWriter, r *http.Request, env *epay.Environment, endpoint Endpoint) (*Request, bool) {
+	req, err := ParseRequest(r.URL.Query(), env, endpoint)
+	if err != nil {
+		log.WithContext(ctx).Printf("rejecting %s request due: %v", endpoint, err)
+		httputil.RespondWithJSON(ctx, w, &DutyResponse{Status: err.(*RequestError).Status})
+		return nil, false
+	}
+	return req, true
+}
+
+func parseExpired(v string) (time.Time, bool) {
+	for _, layout := range expiredLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			if dateLayouts[layout] {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseRequest(t *testing.T) {
+	env := &epay.Environment{MerchantID: "D000000001"}
+	amount := 1999
+
+	q := url.Values{
+		"IDN":        []string{"1234567"},
+		"TID":        []string{"20260101000001"},
+		"MERCHANTID": []string{"D000000001"},
+		"TYPE":       []string{"BILLING"},
+		"AMOUNT":     []string{"1999"},
+		"CURRENCY":   []string{"eur"},
+		"EXPIRED":    []string{"20991231"},
+	}
+	got, err := ParseRequest(q, env, EndpointConfirm)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := &Request{
+		IDN:        "1234567",
+		TID:        "20260101000001",
+		MerchantID: "D000000001",
+		Type:       "BILLING",
+		Amount:     &amount,
+		Currency:   "EUR",
+		Expired:    time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected request (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseInvalidRequest(t *testing.T) {
+	env := &epay.Environment{MerchantID: "D000000001"}
+	valid := func() url.Values {
+		return url.Values{
+			"IDN":        []string{"1234567"},
+			"TID":        []string{"20260101000001"},
+			"MERCHANTID": []string{"D000000001"},
+			"TYPE":       []string{"BILLING"},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		endpoint Endpoint
+		param    string
+		value    string
+		status   string
+	}{
+		{"missing IDN", EndpointBilling, "IDN", "", StatusSubscriberNotFound},
+		{"malformed IDN", EndpointBilling, "IDN", "12 34&x=1", StatusSubscriberNotFound},
+		{"missing TID", EndpointBilling, "TID", "", StatusCommonError},
+		{"missing TID of confirmation", EndpointConfirm, "TID", "", StatusCommonError},
+		{"other merchant", EndpointBilling, "MERCHANTID", "D000000002", StatusCommonError},
+		{"other type", EndpointCheck, "TYPE", "BILLING", StatusCommonError},
+		{"malformed amount", EndpointConfirm, "AMOUNT", "19.99", StatusInvalidAmount},
+		{"negative amount", EndpointConfirm, "AMOUNT", "-1", StatusInvalidAmount},
+		{"unknown currency", EndpointBilling, "CURRENCY", "USD", StatusCommonError},
+		{"malformed expiry", EndpointBilling, "EXPIRED", "tomorrow", StatusCommonError},
+		{"expired", EndpointConfirm, "EXPIRED", "20260131", StatusCommonError},
+		{"expired time", EndpointBilling, "EXPIRED", time.Now().Add(-time.Minute).UTC().Format("20060102150405"), StatusCommonError},
+	}
+	for _, c := range cases {
+		q := valid()
+		q.Set(c.param, c.value)
+		_, err := ParseRequest(q, env, c.endpoint)
+		reqErr, ok := err.(*RequestError)
+		if !ok {
+			t.Errorf("%s: expected request error, but got: %v", c.name, err)
+			continue
+		}
+		if reqErr.Status != c.status || reqErr.Param != c.param {
+			t.Errorf("%s: expected status %s of %s, but got: %s of %s", c.name, c.status, c.param, reqErr.Status, reqErr.Param)
+		}
+	}
+
+	// the MERCHANTID is not required
+	q := valid()
+	q.Del("MERCHANTID")
+	if _, err := ParseRequest(q, env, EndpointBilling); err != nil {
+		t.Errorf("unexpected error of request without merchant: %v", err)
+	}
+
+	// the TID is not required for checks
+	q = valid()
+	q.Set("TYPE", "CHECK")
+	q.Del("TID")
+	if _, err := ParseRequest(q, env, EndpointCheck); err != nil {
+		t.Errorf("unexpected error of check: %v", err)
+	}
+}
@@ -12,6 +12,8 @@ const (
 
 	// StatusSuccess indicates the success of payment operation
 	StatusSuccess string = "00"
+	// StatusInvalidAmount indicates that the paid amount is not accepted
+	StatusInvalidAmount string = "13"
 	// StatusSubscriberNotFound indicates that unknown subscriber was requested
 	StatusSubscriberNotFound string = "14"
 	// StatusNoDuties indicates that subscriber has no dutiies
@@ -26,5 +28,5 @@ const (
 	StatusCommonError string = "96"
 
 	// EPAY payment source
-	EPAY epay.PaymentSource = "EPAY"
+	EPAY = epay.PaymentSourceEPAY
 )
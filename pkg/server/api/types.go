@@ -12,5 +12,6 @@ type DutyResponse struct {
 	ShortDesc string `json:"SHORTDESC,omitempty"`
 	LongDesc  string `json:"LONGDESC,omitempty"`
 	Amount    int    `json:"AMOUNT,omitempty"`
+	Currency  string `json:"CURRENCY,omitempty"`
 	ValidTo   string `json:"VALIDTO,omitempty"`
 }
@@ -3,6 +3,18 @@ package server
 const (
 	// EnvironmentKey is representing the key of the environment.
 	EnvironmentKey key = iota
+
+	// ClientIPKey is representing the key of the IP of the client resolved
+	// through the trusted proxies.
+	ClientIPKey
+
+	// HostKey is representing the key of the host of the tenant resolved
+	// through the trusted proxies.
+	HostKey
+
+	// ChecksumKey is representing the key of the verified CHECKSUM of the
+	// request, as it's not passed to the handlers of ENCODED requests.
+	ChecksumKey
 )
 
 type key int
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"cloud.google.com/go/datastore"
+	"github.com/clouway/go-epay/pkg/client"
 	"github.com/clouway/go-epay/pkg/epay"
 )
 
@@ -29,17 +30,47 @@ func (s *store) Get(ctx context.Context, name string) (*epay.Environment, error)
 
 	e := &environmentEntity{}
 	if err := s.c.Get(ctx, k, e); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, fmt.Errorf("%w: '%s'", epay.ErrEnvironmentNotFound, name)
+		}
 		return nil, fmt.Errorf("could not load the environment '%s' due: %v", name, err)
 	}
 
-	return &epay.Environment{
-		BillingJWTKey: e.BillingKey,
-		BillingKey:    e.BillingKey,
-		BillingURL:    e.BillingURL,
-		EpaySecret:    e.EpaySecret,
-		MerchantID:    e.MerchantID,
-		Metadata:      e.Metadata,
-	}, nil
+	var routes []epay.BillingRoute
+	if e.BillingRoutes != "" {
+		if err := json.Unmarshal([]byte(e.BillingRoutes), &routes); err != nil {
+			return nil, fmt.Errorf("could not parse billing routes of environment '%s' due: %v", name, err)
+		}
+	}
+
+	var rules []epay.SubscriberRule
+	if e.SubscriberRules != "" {
+		if err := json.Unmarshal([]byte(e.SubscriberRules), &rules); err != nil {
+			return nil, fmt.Errorf("could not parse subscriber rules of environment '%s' due: %v", name, err)
+		}
+	}
+
+	env := &epay.Environment{
+		BillingJWTKey:     e.BillingKey,
+		BillingKey:        e.BillingKey,
+		BillingURL:        e.BillingURL,
+		EpaySecret:        e.EpaySecret,
+		MerchantID:        e.MerchantID,
+		AmountPolicy:      epay.AmountPolicy(e.AmountPolicy),
+		Currency:          e.Currency,
+		BillingSystem:     e.BillingSystem,
+		BillingRoutes:     routes,
+		Metadata:          e.Metadata,
+		ChecksumAlgorithm: epay.ChecksumAlgorithm(e.ChecksumAlgorithm),
+		ChecksumPayload:   epay.ChecksumPayload(e.ChecksumPayload),
+		SignResponses:     e.SignResponses,
+		AllowedNetworks:   e.AllowedNetworks,
+		SubscriberRules:   rules,
+	}
+	if err := client.ValidateEnvironment(*env); err != nil {
+		return nil, fmt.Errorf("invalid environment '%s': %v", name, err)
+	}
+	return env, nil
 }
 
 type environmentEntity struct {
@@ -49,7 +80,25 @@ type environmentEntity struct {
 	BillingURL string
 	EpaySecret string
 	MerchantID string
-	Metadata   map[string]string `datastore:"-"`
+	// AmountPolicy is optional and exact amounts are required when it's missing
+	AmountPolicy string
+	// Currency is optional and amounts are not converted when it's missing
+	Currency string
+	// ChecksumAlgorithm is optional and HMAC-SHA1 is used when it's missing
+	ChecksumAlgorithm string
+	// ChecksumPayload is optional and the query is verified when it's missing
+	ChecksumPayload string
+	// SignResponses is optional and the responses are not signed when it's missing
+	SignResponses bool
+	// AllowedNetworks is optional and requests from any network are accepted when it's missing
+	AllowedNetworks []string
+	// BillingSystem is optional and the billing system is selected by the routes when it's missing
+	BillingSystem string
+	// BillingRoutes is optional JSON list of routes to the billing systems
+	BillingRoutes string `datastore:",noindex"`
+	// SubscriberRules is optional JSON list of the rules of the subscribers
+	SubscriberRules string            `datastore:",noindex"`
+	Metadata        map[string]string `datastore:"-"`
 }
 
 func (e *environmentEntity) Load(ps []datastore.Property) error {
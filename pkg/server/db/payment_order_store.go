@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"cloud.google.com/go/datastore"
@@ -26,28 +27,61 @@ type paymentOrderEntity struct {
 	ClientID      string    `datastore:"clientID,noindex"`
 	TransactionID string    `datastore:"transactionId,noindex"`
 	Amount        string    `datastore:"amount,noindex"`
+	PaidAmount    string    `datastore:"paidAmount,noindex"`
+	Currency      string    `datastore:"currency,noindex"`
 	CreatedAt     time.Time `datastore:"createdOn,noindex"`
 	ProcessedOn   time.Time `datastore:"processedOn,omitempty"`
+	ClaimedAt     time.Time `datastore:"claimedAt,noindex,omitempty"`
 	InvoiceIDs    []string  `datastore:"invoiceIds,noindex"`
 }
 
+// Create saves a new payment order. ErrPaymentOrderAlreadyExists is returned
+// when the order exists already.
+func (s *PaymentOrderStore) Create(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	k := datastore.NameKey(poKind, po.TransactionID, nil)
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		err := tx.Get(k, &paymentOrderEntity{})
+		if err == nil {
+			return epay.ErrPaymentOrderAlreadyExists
+		}
+		if err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		_, err = tx.Put(k, newPaymentOrderEntity(po))
+		return err
+	})
+	return err
+}
+
 // Put saves a payment order.
 func (s *PaymentOrderStore) Put(ctx context.Context, po *epay.PaymentOrderRecord) error {
 	k := datastore.NameKey(poKind, po.TransactionID, nil)
+	_, err := s.client.Put(ctx, k, newPaymentOrderEntity(po))
+	return err
+}
+
+func newPaymentOrderEntity(po *epay.PaymentOrderRecord) *paymentOrderEntity {
+
+	// the paid amount is empty until the order is paid
+	paidAmount := ""
+	if po.PaidAmount != (epay.Money{}) {
+		paidAmount = po.PaidAmount.String()
+	}
 
-	entity := &paymentOrderEntity{
+	return &paymentOrderEntity{
 		SubscriberID:  po.SubscriberID,
 		CustomerName:  po.CustomerName,
 		ClientID:      po.ClientID,
 		TransactionID: po.TransactionID,
-		Amount:        po.Amount,
+		Amount:        po.Amount.String(),
+		PaidAmount:    paidAmount,
+		Currency:      po.Amount.Currency(),
 		CreatedAt:     po.CreatedAt,
 		ProcessedOn:   po.ProcessedOn,
+		ClaimedAt:     po.ClaimedAt,
 		InvoiceIDs:    po.InvoiceIDs,
 	}
-
-	_, err := s.client.Put(ctx, k, entity)
-	return err
 }
 
 // Get retrieves a payment order by TransactionID.
@@ -62,14 +96,95 @@ func (s *PaymentOrderStore) Get(ctx context.Context, transactionID string) (*epa
 		return nil, err
 	}
 
+	amount, err := epay.ParseMoney(entity.Amount, entity.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse amount of payment order %s due: %w", transactionID, err)
+	}
+	var paidAmount epay.Money
+	if entity.PaidAmount != "" {
+		if paidAmount, err = epay.ParseMoney(entity.PaidAmount, entity.Currency); err != nil {
+			return nil, fmt.Errorf("could not parse paid amount of payment order %s due: %w", transactionID, err)
+		}
+	}
+
 	return &epay.PaymentOrderRecord{
 		TransactionID: entity.TransactionID,
 		SubscriberID:  entity.SubscriberID,
 		CustomerName:  entity.CustomerName,
 		ClientID:      entity.ClientID,
-		Amount:        entity.Amount,
+		Amount:        amount,
+		PaidAmount:    paidAmount,
 		CreatedAt:     entity.CreatedAt,
 		ProcessedOn:   entity.ProcessedOn,
+		ClaimedAt:     entity.ClaimedAt,
 		InvoiceIDs:    entity.InvoiceIDs,
 	}, nil
 }
+
+// Claim atomically marks the payment order as being paid.
+func (s *PaymentOrderStore) Claim(ctx context.Context, transactionID string, lease time.Duration) error {
+	k := datastore.NameKey(poKind, transactionID, nil)
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		entity := &paymentOrderEntity{}
+		if err := tx.Get(k, entity); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return epay.ErrPaymentOrderNotFound
+			}
+			return err
+		}
+
+		if !entity.ProcessedOn.IsZero() {
+			return epay.ErrPaymentOrderAlreadyPaid
+		}
+		now := time.Now()
+		if !entity.ClaimedAt.IsZero() && entity.ClaimedAt.Add(lease).After(now) {
+			return epay.ErrPaymentOrderInProgress
+		}
+
+		entity.ClaimedAt = now
+		_, err := tx.Put(k, entity)
+		return err
+	})
+	return err
+}
+
+// Release releases the claim of a payment order which was not processed.
+func (s *PaymentOrderStore) Release(ctx context.Context, transactionID string) error {
+	k := datastore.NameKey(poKind, transactionID, nil)
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		entity := &paymentOrderEntity{}
+		if err := tx.Get(k, entity); err != nil {
+			return err
+		}
+		if !entity.ProcessedOn.IsZero() {
+			return nil
+		}
+
+		entity.ClaimedAt = time.Time{}
+		_, err := tx.Put(k, entity)
+		return err
+	})
+	return err
+}
+
+// Delete deletes a payment order which was not claimed or processed.
+func (s *PaymentOrderStore) Delete(ctx context.Context, transactionID string) error {
+	k := datastore.NameKey(poKind, transactionID, nil)
+
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		entity := &paymentOrderEntity{}
+		if err := tx.Get(k, entity); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return nil
+			}
+			return err
+		}
+		if !entity.ClaimedAt.IsZero() || !entity.ProcessedOn.IsZero() {
+			return nil
+		}
+		return tx.Delete(k)
+	})
+	return err
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+const replayKind = "ReplayedRequest"
+
+// ReplayStore keeps the keys of the handled ePay requests using Google Cloud Datastore.
+type ReplayStore struct {
+	client *datastore.Client
+}
+
+// NewReplayStore creates a new Datastore-backed replay store.
+func NewReplayStore(client *datastore.Client) *ReplayStore {
+	return &ReplayStore{client: client}
+}
+
+type replayEntity struct {
+	ExpiresAt time.Time `datastore:"expiresAt"`
+}
+
+// Reserve reserves the request with the provided key until the provided expiry
+// time in a transaction. It reports false when the request is already reserved
+// and it's not expired yet. The expired entities could be removed with a
+// Datastore TTL policy on the expiresAt property.
+func (s *ReplayStore) Reserve(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	k := datastore.NameKey(replayKind, key, nil)
+
+	reserved := false
+	_, err := s.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		reserved = false
+
+		entity := &replayEntity{}
+		err := tx.Get(k, entity)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if err == nil && time.Now().Before(entity.ExpiresAt) {
+			return nil
+		}
+
+		if _, err := tx.Put(k, &replayEntity{ExpiresAt: expiresAt}); err != nil {
+			return err
+		}
+		reserved = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return reserved, nil
+}
+
+// Release releases the reservation of the request with the provided key.
+func (s *ReplayStore) Release(ctx context.Context, key string) error {
+	return s.client.Delete(ctx, datastore.NameKey(replayKind, key, nil))
+}
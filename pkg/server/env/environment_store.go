@@ -2,8 +2,12 @@ package env
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
+	"strings"
 
+	"github.com/clouway/go-epay/pkg/client"
 	"github.com/clouway/go-epay/pkg/epay"
 )
 
@@ -41,13 +45,101 @@ func (s *envStore) Get(ctx context.Context, name string) (*epay.Environment, err
 	if id := os.Getenv("UCRM_ORGANIZATION_ID"); id != "" {
 		metadata["organizationId"] = id
 	}
+	if note := os.Getenv("UCRM_PAYMENT_NOTE"); note != "" {
+		metadata["paymentNote"] = note
+	}
+	if currency := os.Getenv("UCRM_CURRENCY"); currency != "" {
+		metadata["currency"] = currency
+	}
+	if strategy := os.Getenv("UCRM_INVOICE_STRATEGY"); strategy != "" {
+		metadata["invoiceStrategy"] = strategy
+	}
+
+	// Splynx-specific metadata
+	for key, name := range map[string]string{
+		"splynxUrl":           "SPLYNX_BILLING_URL",
+		"splynxApiKey":        "SPLYNX_API_KEY",
+		"splynxApiSecret":     "SPLYNX_API_SECRET",
+		"splynxPaymentTypeId": "SPLYNX_PAYMENT_TYPE_ID",
+		"splynxCurrency":      "SPLYNX_CURRENCY",
+	} {
+		if v := os.Getenv(name); v != "" {
+			metadata[key] = v
+		}
+	}
+
+	// metadata of billing systems integrated by HTTP endpoints
+	for key, name := range map[string]string{
+		"restUrl":        "REST_BILLING_URL",
+		"restAuthHeader": "REST_AUTH_HEADER",
+		"restAuthValue":  "REST_AUTH_VALUE",
+		"restCurrency":   "REST_CURRENCY",
+		"restConfig":     "REST_CONFIG",
+	} {
+		if v := os.Getenv(name); v != "" {
+			metadata[key] = v
+		}
+	}
+
+	// timeouts, retries and circuit breaking of the calls to the billing system
+	for key, name := range map[string]string{
+		"billingTimeout":          "BILLING_TIMEOUT",
+		"billingRetries":          "BILLING_RETRIES",
+		"billingRetryWait":        "BILLING_RETRY_WAIT",
+		"billingBreakerThreshold": "BILLING_BREAKER_THRESHOLD",
+		"billingBreakerTimeout":   "BILLING_BREAKER_TIMEOUT",
+	} {
+		if v := os.Getenv(name); v != "" {
+			metadata[key] = v
+		}
+	}
 
-	return &epay.Environment{
-		BillingJWTKey: os.Getenv("TELCONG_JWT_KEY"),
-		BillingKey:    os.Getenv("TELCONG_JWT_KEY"),
-		BillingURL:    os.Getenv("TELCONG_BILLING_URL"),
-		EpaySecret:    os.Getenv("EPAY_SECRET"),
-		MerchantID:    os.Getenv("EPAY_MERCHANT_ID"),
-		Metadata:      metadata,
-	}, nil
+	// Subscribers could be routed to several billing systems, otherwise
+	// TelcoNG is used by default as by the client factory
+	var routes []epay.BillingRoute
+	if v := os.Getenv("BILLING_ROUTES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &routes); err != nil {
+			return nil, fmt.Errorf("could not parse BILLING_ROUTES due: %v", err)
+		}
+	}
+	billingSystem := os.Getenv("BILLING_SYSTEM")
+	if billingSystem == "" && len(routes) == 0 {
+		billingSystem = string(client.BillingSystemTelcoNG)
+	}
+
+	var rules []epay.SubscriberRule
+	if v := os.Getenv("EPAY_SUBSCRIBER_RULES"); v != "" {
+		if err := json.Unmarshal([]byte(v), &rules); err != nil {
+			return nil, fmt.Errorf("could not parse EPAY_SUBSCRIBER_RULES due: %v", err)
+		}
+	}
+
+	var networks []string
+	for _, v := range strings.Split(os.Getenv("EPAY_ALLOWED_NETWORKS"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			networks = append(networks, v)
+		}
+	}
+
+	env := &epay.Environment{
+		BillingJWTKey:     os.Getenv("TELCONG_JWT_KEY"),
+		BillingKey:        os.Getenv("TELCONG_JWT_KEY"),
+		BillingURL:        os.Getenv("TELCONG_BILLING_URL"),
+		EpaySecret:        os.Getenv("EPAY_SECRET"),
+		MerchantID:        os.Getenv("EPAY_MERCHANT_ID"),
+		AmountPolicy:      epay.AmountPolicy(os.Getenv("EPAY_AMOUNT_POLICY")),
+		Currency:          os.Getenv("EPAY_CURRENCY"),
+		BillingSystem:     billingSystem,
+		BillingRoutes:     routes,
+		Metadata:          metadata,
+		ChecksumAlgorithm: epay.ChecksumAlgorithm(os.Getenv("EPAY_CHECKSUM_ALGORITHM")),
+		ChecksumPayload:   epay.ChecksumPayload(os.Getenv("EPAY_CHECKSUM_PAYLOAD")),
+		SignResponses:     os.Getenv("EPAY_SIGN_RESPONSES") == "true",
+		AllowedNetworks:   networks,
+		SubscriberRules:   rules,
+	}
+	if err := client.ValidateEnvironment(*env); err != nil {
+		return nil, fmt.Errorf("invalid environment configuration: %v", err)
+	}
+	return env, nil
 }
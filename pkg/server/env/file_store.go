@@ -0,0 +1,261 @@
+package env
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/client"
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+// FileEnvironmentStore is an environment store which serves multiple tenants
+// configured in a JSON file. Environments are looked up by their name, by the
+// hostnames on which they are served or by the merchant ID issued by ePay.
+//
+// The file has the following format:
+//
+//	{
+//	  "default": "isp1",
+//	  "environments": [
+//	    {
+//	      "name": "isp1",
+//	      "hosts": ["epay.isp1.example.com"],
+//	      "merchantId": "D000000001",
+//	      "epaySecret": "...",
+//	      "billingRoutes": [
+//	        {"billingSystem": "telcong", "contractCode": true},
+//	        {"billingSystem": "ucrm", "pattern": "^[0-9]{1,6}$"}
+//	      ],
+//	      "billingUrl": "https://billing.isp1.example.com",
+//	      "billingJWTKey": "...",
+//	      "metadata": {"billingUrl": "https://ucrm.isp1.example.com", "apiKey": "...", "methodId": "..."}
+//	    }
+//	  ]
+//	}
+type FileEnvironmentStore struct {
+	path string
+
+	mu      sync.RWMutex
+	config  *Tenants
+	modTime time.Time
+	size    int64
+}
+
+// NewFileEnvironmentStore creates a new store which loads the environments from
+// the provided file. An error is returned when the file could not be loaded or
+// when the configuration is not valid.
+func NewFileEnvironmentStore(path string) (*FileEnvironmentStore, error) {
+	s := &FileEnvironmentStore{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get gets the environment of the provided name, host or merchant ID. The default
+// environment is returned when the name is empty.
+func (s *FileEnvironmentStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	s.mu.RLock()
+	c := s.config
+	s.mu.RUnlock()
+
+	key := strings.ToLower(strings.TrimSpace(name))
+	if key == "" {
+		key = c.Default
+	}
+
+	e, ok := c.byKey[key]
+	if !ok {
+		e, ok = c.byKey[stripPort(key)]
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", epay.ErrEnvironmentNotFound, name)
+	}
+
+	env := e.Environment()
+	return &env, nil
+}
+
+// Reload loads the configuration from the file. The current configuration is
+// kept when the file could not be loaded or it's not valid.
+func (s *FileEnvironmentStore) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read environments file due: %v", err)
+	}
+
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read environments file due: %v", err)
+	}
+
+	c, err := ParseTenants(b)
+	if err != nil {
+		return fmt.Errorf("invalid environments file '%s': %v", s.path, err)
+	}
+
+	s.mu.Lock()
+	s.config = c
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	s.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the configuration when the file is changed until the context is
+// done. The file is checked for changes on every interval.
+func (s *FileEnvironmentStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if !s.changed() {
+			continue
+		}
+		if err := s.Reload(); err != nil {
+			log.Errorf("could not reload environments due: %v", err)
+			continue
+		}
+		log.Infof("environments were reloaded from '%s'", s.path)
+	}
+}
+
+func (s *FileEnvironmentStore) changed() bool {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !info.ModTime().Equal(s.modTime) || info.Size() != s.size
+}
+
+// Tenants is the configuration of the environments of multiple tenants.
+type Tenants struct {
+	Default      string   `json:"default,omitempty"`
+	Environments []Tenant `json:"environments"`
+
+	// byKey indexes the environments by name, host and merchant ID
+	byKey map[string]*Tenant
+}
+
+// Tenant is the configuration of the environment of a single tenant.
+type Tenant struct {
+	Name              string                `json:"name"`
+	Hosts             []string              `json:"hosts,omitempty"`
+	MerchantID        string                `json:"merchantId,omitempty"`
+	EpaySecret        string                `json:"epaySecret"`
+	BillingSystem     string                `json:"billingSystem,omitempty"`
+	BillingRoutes     []epay.BillingRoute   `json:"billingRoutes,omitempty"`
+	BillingURL        string                `json:"billingUrl,omitempty"`
+	BillingJWTKey     string                `json:"billingJWTKey,omitempty"`
+	AmountPolicy      string                `json:"amountPolicy,omitempty"`
+	Currency          string                `json:"currency,omitempty"`
+	Metadata          map[string]string     `json:"metadata,omitempty"`
+	ChecksumAlgorithm string                `json:"checksumAlgorithm,omitempty"`
+	ChecksumPayload   string                `json:"checksumPayload,omitempty"`
+	SignResponses     bool                  `json:"signResponses,omitempty"`
+	AllowedNetworks   []string              `json:"allowedNetworks,omitempty"`
+	SubscriberRules   []epay.SubscriberRule `json:"subscriberRules,omitempty"`
+}
+
+// Environment converts the tenant configuration to epay.Environment.
+func (e *Tenant) Environment() epay.Environment {
+	metadata := make(map[string]string, len(e.Metadata))
+	for k, v := range e.Metadata {
+		metadata[k] = v
+	}
+
+	return epay.Environment{
+		BillingJWTKey:     e.BillingJWTKey,
+		BillingKey:        e.BillingJWTKey,
+		BillingURL:        e.BillingURL,
+		EpaySecret:        e.EpaySecret,
+		MerchantID:        e.MerchantID,
+		AmountPolicy:      epay.AmountPolicy(e.AmountPolicy),
+		Currency:          e.Currency,
+		BillingSystem:     e.BillingSystem,
+		BillingRoutes:     append([]epay.BillingRoute(nil), e.BillingRoutes...),
+		Metadata:          metadata,
+		ChecksumAlgorithm: epay.ChecksumAlgorithm(e.ChecksumAlgorithm),
+		ChecksumPayload:   epay.ChecksumPayload(e.ChecksumPayload),
+		SignResponses:     e.SignResponses,
+		AllowedNetworks:   append([]string(nil), e.AllowedNetworks...),
+		SubscriberRules:   append([]epay.SubscriberRule(nil), e.SubscriberRules...),
+	}
+}
+
+// ParseTenants parses and validates the JSON configuration of the tenants.
+func ParseTenants(b []byte) (*Tenants, error) {
+	c := &Tenants{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	if len(c.Environments) == 0 {
+		return nil, fmt.Errorf("no environments are configured")
+	}
+
+	c.Default = strings.ToLower(c.Default)
+	c.byKey = make(map[string]*Tenant)
+	for i := range c.Environments {
+		e := &c.Environments[i]
+		if err := e.Validate(); err != nil {
+			return nil, fmt.Errorf("environment #%d '%s': %v", i+1, e.Name, err)
+		}
+
+		keys := []string{e.Name}
+		keys = append(keys, e.Hosts...)
+		if e.MerchantID != "" {
+			keys = append(keys, e.MerchantID)
+		}
+		for _, k := range keys {
+			k = strings.ToLower(k)
+			if other, ok := c.byKey[k]; ok && other != e {
+				return nil, fmt.Errorf("'%s' is used by environments '%s' and '%s'", k, other.Name, e.Name)
+			}
+			c.byKey[k] = e
+		}
+	}
+
+	if _, ok := c.byKey[c.Default]; c.Default != "" && !ok {
+		return nil, fmt.Errorf("default environment '%s' is not configured", c.Default)
+	}
+	return c, nil
+}
+
+// Validate validates the configuration of the tenant.
+func (e *Tenant) Validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	env := e.Environment()
+	if err := client.ValidateEnvironment(env); err != nil {
+		return err
+	}
+	// keep the rules and the routes with the patterns compiled by the validation
+	e.SubscriberRules, e.BillingRoutes = env.SubscriberRules, env.BillingRoutes
+	return nil
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
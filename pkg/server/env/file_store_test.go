@@ -0,0 +1,163 @@
+package env
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+)
+
+const environmentsFile = `{
+	"default": "isp1",
+	"environments": [
+		{
+			"name": "isp1",
+			"hosts": ["epay.isp1.example.com"],
+			"merchantId": "D000000001",
+			"epaySecret": "::secret 1::",
+			"billingSystem": "ucrm",
+			"currency": "EUR",
+			"metadata": {"billingUrl": "https://ucrm.isp1.example.com", "apiKey": "::key::", "methodId": "::method::"}
+		},
+		{
+			"name": "isp2",
+			"hosts": ["epay.isp2.example.com"],
+			"merchantId": "D000000002",
+			"epaySecret": "::secret 2::",
+			"billingSystem": "telcong",
+			"billingUrl": "https://billing.isp2.example.com",
+			"billingJWTKey": "{\"type\": \"service_account\", \"client_email\": \"epay@isp2.example.com\", \"private_key\": \"::key::\"}",
+			"amountPolicy": "exact"
+		}
+	]
+}`
+
+func TestFileEnvironmentStoreGet(t *testing.T) {
+	path := writeEnvironmentsFile(t, environmentsFile)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	store, err := NewFileEnvironmentStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name       string
+		merchantID string
+	}{
+		{"isp2", "D000000002"},
+		{"epay.isp2.example.com", "D000000002"},
+		{"EPAY.ISP2.example.com:443", "D000000002"},
+		{"D000000002", "D000000002"},
+		{"", "D000000001"},
+	}
+
+	for _, c := range cases {
+		env, err := store.Get(context.Background(), c.name)
+		if err != nil {
+			t.Errorf("unexpected error for '%s': %v", c.name, err)
+			continue
+		}
+		if env.MerchantID != c.merchantID {
+			t.Errorf("expected merchant of '%s' to be: %s", c.name, c.merchantID)
+			t.Errorf("                          but was: %s", env.MerchantID)
+		}
+	}
+
+	env, _ := store.Get(context.Background(), "isp1")
+	if env.BillingSystem != "ucrm" || env.Currency != "EUR" || env.Metadata["apiKey"] != "::key::" {
+		t.Errorf("unexpected environment: %+v", env)
+	}
+
+	if _, err := store.Get(context.Background(), "unknown.example.com"); !errors.Is(err, epay.ErrEnvironmentNotFound) {
+		t.Errorf("expected unknown environment to be not found, but got: %v", err)
+	}
+}
+
+func TestFileEnvironmentStoreRejectsInvalidConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"broken json", `{"environments": [`},
+		{"no environments", `{"environments": []}`},
+		{"missing secret", `{"environments": [{"name": "isp1"}]}`},
+		{"unknown billing system", `{"environments": [{"name": "isp1", "epaySecret": "s", "billingSystem": "other"}]}`},
+		{"incomplete ucrm", `{"environments": [{"name": "isp1", "epaySecret": "s", "billingSystem": "ucrm"}]}`},
+		{"relative ucrm url", `{"environments": [{"name": "isp1", "epaySecret": "s", "metadata": {"billingUrl": "ucrm", "apiKey": "k", "methodId": "m"}}]}`},
+		{"unknown invoice strategy", `{"environments": [{"name": "isp1", "epaySecret": "s", "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m", "invoiceStrategy": "some"}}]}`},
+		{"invalid telcong key", `{"environments": [{"name": "isp1", "epaySecret": "s", "billingSystem": "telcong", "billingUrl": "https://billing", "billingJWTKey": "key"}]}`},
+		{"invalid route pattern", `{"environments": [{"name": "isp1", "epaySecret": "s", "billingRoutes": [{"billingSystem": "ucrm", "pattern": "("}], "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m"}}]}`},
+		{"unconfigured route", `{"environments": [{"name": "isp1", "epaySecret": "s", "billingRoutes": [{"billingSystem": "telcong"}], "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m"}}]}`},
+		{"no billing system", `{"environments": [{"name": "isp1", "epaySecret": "s"}]}`},
+		{"unknown amount policy", `{"environments": [{"name": "isp1", "epaySecret": "s", "amountPolicy": "some", "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m"}}]}`},
+		{"unsupported currency", `{"environments": [{"name": "isp1", "epaySecret": "s", "currency": "USD", "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m"}}]}`},
+		{"duplicated host", `{"environments": [{"name": "isp1", "epaySecret": "s", "hosts": ["a"], "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m"}}, {"name": "isp2", "epaySecret": "s", "hosts": ["a"], "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m"}}]}`},
+		{"unknown default", `{"default": "isp2", "environments": [{"name": "isp1", "epaySecret": "s", "metadata": {"billingUrl": "https://ucrm", "apiKey": "k", "methodId": "m"}}]}`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := writeEnvironmentsFile(t, c.content)
+			defer os.RemoveAll(filepath.Dir(path))
+
+			if _, err := NewFileEnvironmentStore(path); err == nil {
+				t.Error("expected configuration to be rejected")
+			}
+		})
+	}
+}
+
+func TestFileEnvironmentStoreReloadsChangedFile(t *testing.T) {
+	path := writeEnvironmentsFile(t, environmentsFile)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	store, err := NewFileEnvironmentStore(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go store.Watch(ctx, 10*time.Millisecond)
+
+	// invalid configuration is not applied
+	ioutil.WriteFile(path, []byte(`{"environments": []}`), 0600)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := store.Get(context.Background(), "isp2"); err != nil {
+		t.Fatalf("expected previous configuration to be kept, but got: %v", err)
+	}
+
+	ioutil.WriteFile(path, []byte(strings.Replace(environmentsFile, "D000000002", "D000000003", 1)), 0600)
+	deadline := time.Now().Add(time.Second)
+	for {
+		env, _ := store.Get(context.Background(), "isp2")
+		if env.MerchantID == "D000000003" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected environments to be reloaded, but got: %+v", env)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// writeEnvironmentsFile writes the content to a new temporary file. The caller
+// should remove the directory of the file.
+func writeEnvironmentsFile(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "environments")
+	if err != nil {
+		t.Fatalf("could not create temp dir due: %v", err)
+	}
+	path := filepath.Join(dir, "environments.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("could not write environments file due: %v", err)
+	}
+	return path
+}
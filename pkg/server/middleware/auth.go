@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"net"
 	"net/http"
 	"strings"
 
@@ -13,40 +16,134 @@ import (
 	"github.com/clouway/go-epay/pkg/server/httputil"
 )
 
+// ChecksumHeader is the header of the responses which is having the checksum
+// of the response body when the environment requires signed responses.
+const ChecksumHeader = "X-Epay-Checksum"
+
 // EpayAPIMiddleware is a middleware used to check the request using internal secret
-// stored in the environment.
+// stored in the environment. The parameters of the requests which checksum covers
+// an ENCODED payload are decoded and passed to the next handler as query parameters.
+// Requests of clients which are not in the allowed networks of the environment are
+// rejected before the checksum is verified, as well as requests to environments
+// which are not having an epay secret.
 func EpayAPIMiddleware(envStore epay.EnvironmentStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			contextLogger := log.WithContext(r.Context())
-			metadata := r.Header["X-Google-Apps-Metadata"]
-			host := ""
-			for _, m := range metadata {
-				if strings.Contains(m, ",") && strings.Contains(m, "=") {
-					parts := strings.Split(m, ",")
-					host = strings.Split(parts[1], "=")[1]
-				}
-			}
-
-			if host == "" {
-				host = r.URL.Host
-			}
-			env, err := envStore.Get(r.Context(), host)
+			env, err := findEnvironment(r, envStore)
 			if err != nil {
 				contextLogger.Debugf("unable to read environment due: %v", err)
 				http.Error(w, "unable to read env configuration", http.StatusInternalServerError)
 				return
 			}
 
-			checksum := r.URL.Query().Get("CHECKSUM")
+			ip, _ := r.Context().Value(server.ClientIPKey).(net.IP)
+			if ip == nil {
+				ip = remoteIP(r)
+			}
+			if !allowed(env, ip) {
+				contextLogger.Warnf("rejecting request of %s which is not in the allowed networks", ip)
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
 
-			if checksum != epay.Checksum(r.URL.Query(), env.EpaySecret) {
+			// the secret is not required by the environments which are used
+			// only through the TCP gateway
+			if env.EpaySecret == "" {
+				contextLogger.Errorf("rejecting request to environment of merchant '%s' which is not having epay secret", env.MerchantID)
+				http.Error(w, "environment is not configured for the HTTP API", http.StatusInternalServerError)
+				return
+			}
+
+			alg := env.ChecksumAlgorithm
+			if alg == "" {
+				alg = epay.ChecksumHMACSHA1
+			}
+			params, err := epay.VerifyChecksum(r.URL.Query(), env.EpaySecret, alg, env.ChecksumPayload)
+			if err != nil {
+				contextLogger.Debugf("rejecting request due: %v", err)
 				httputil.RespondWithJSON(r.Context(), w, api.ErrBadChecksum)
 				return
 			}
 
+			u := *r.URL
+			u.RawQuery = params.Encode()
+
 			nextCtx := context.WithValue(r.Context(), server.EnvironmentKey, env)
-			next.ServeHTTP(w, r.WithContext(nextCtx))
+			nextCtx = context.WithValue(nextCtx, server.ChecksumKey, strings.ToLower(r.URL.Query().Get("CHECKSUM")))
+			nextReq := r.WithContext(nextCtx)
+			nextReq.URL = &u
+
+			if !env.SignResponses {
+				next.ServeHTTP(w, nextReq)
+				return
+			}
+
+			sw := &signingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(sw, nextReq)
+
+			w.Header().Set(ChecksumHeader, epay.Sign(alg, env.EpaySecret, sw.body.Bytes()))
+			w.WriteHeader(sw.status)
+			w.Write(sw.body.Bytes())
 		})
 	}
 }
+
+// findEnvironment finds the environment of the request by the host of the tenant,
+// by the MERCHANTID of the request or the default environment is used. The next
+// one is tried only when the environment was not found, so the requests are not
+// served by the default environment when the store fails.
+func findEnvironment(r *http.Request, envStore epay.EnvironmentStore) (*epay.Environment, error) {
+	host, ok := r.Context().Value(server.HostKey).(string)
+	if !ok {
+		host = metadataHost(r.Header[HeaderAppsMetadata])
+		if host == "" {
+			host = requestHost(r)
+		}
+	}
+
+	var err error
+	for _, name := range []string{host, r.URL.Query().Get("MERCHANTID"), ""} {
+		var env *epay.Environment
+		if env, err = envStore.Get(r.Context(), name); err == nil {
+			return env, nil
+		}
+		if !errors.Is(err, epay.ErrEnvironmentNotFound) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// allowed reports whether the requests of the IP are allowed by the environment.
+func allowed(env *epay.Environment, ip net.IP) bool {
+	if len(env.AllowedNetworks) == 0 {
+		return true
+	}
+	networks, err := env.Networks()
+	if err != nil || ip == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// signingResponseWriter buffers the response so its checksum could be sent
+// as a header before the body.
+type signingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *signingResponseWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+func (w *signingResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server"
+	"github.com/clouway/go-epay/pkg/server/api"
+)
+
+type fakeEnvStore map[string]*epay.Environment
+
+func (s fakeEnvStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	env, ok := s[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: '%s'", epay.ErrEnvironmentNotFound, name)
+	}
+	return env, nil
+}
+
+func TestEpayAPIMiddlewareFindsEnvironmentByHost(t *testing.T) {
+	envStore := fakeEnvStore{
+		"":                      {MerchantID: "default", EpaySecret: "::default secret::"},
+		"epay.isp1.example.com": {MerchantID: "isp1", EpaySecret: "::isp1 secret::"},
+	}
+
+	var got *epay.Environment
+	handler := EpayAPIMiddleware(envStore)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = r.Context().Value(server.EnvironmentKey).(*epay.Environment)
+	}))
+
+	cases := []struct {
+		host   string
+		secret string
+		want   string
+	}{
+		{"epay.isp1.example.com", "::isp1 secret::", "isp1"},
+		{"EPAY.isp1.example.com:8443", "::isp1 secret::", "isp1"},
+		{"epay.other.example.com", "::default secret::", "default"},
+	}
+
+	for _, c := range cases {
+		q := url.Values{"IDN": {"123"}, "TYPE": {"CHECK"}}
+		q.Set("CHECKSUM", epay.Checksum(q, c.secret))
+
+		got = nil
+		req := httptest.NewRequest("GET", "/v1/pay/init?"+q.Encode(), nil)
+		req.Host = c.host
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		if got == nil || got.MerchantID != c.want {
+			t.Errorf("expected environment '%s' for host '%s', but got: %+v", c.want, c.host, got)
+		}
+	}
+}
+
+type failingEnvStore struct{}
+
+func (failingEnvStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	if name == "" {
+		return &epay.Environment{MerchantID: "default", EpaySecret: "::default secret::"}, nil
+	}
+	return nil, errors.New("database is locked")
+}
+
+func TestEpayAPIMiddlewareDoesNotFallBackWhenStoreFails(t *testing.T) {
+	called := false
+	handler := EpayAPIMiddleware(failingEnvStore{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	q := url.Values{"IDN": {"123"}, "TYPE": {"CHECK"}}
+	q.Set("CHECKSUM", epay.Checksum(q, "::default secret::"))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/v1/pay/init?"+q.Encode(), nil)
+	req.Host = "epay.isp1.example.com"
+	handler.ServeHTTP(rec, req)
+
+	if called || rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected request to be rejected with %d, but got: %d", http.StatusInternalServerError, rec.Code)
+	}
+}
+
+func TestEpayAPIMiddlewareRejectsEnvironmentWithoutSecret(t *testing.T) {
+	envStore := fakeEnvStore{"": {MerchantID: "tcp-only"}}
+
+	called := false
+	handler := EpayAPIMiddleware(envStore)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	q := url.Values{"IDN": {"123"}, "TYPE": {"CHECK"}}
+	q.Set("CHECKSUM", epay.Checksum(q, ""))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/pay/init?"+q.Encode(), nil))
+
+	if called || rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected request to be rejected with %d, but got: %d", http.StatusInternalServerError, rec.Code)
+	}
+}
+
+func TestInitDispatchesEncodedType(t *testing.T) {
+	envStore := fakeEnvStore{"": {MerchantID: "default", EpaySecret: "::secret::", ChecksumAlgorithm: epay.ChecksumHMACSHA256, ChecksumPayload: epay.ChecksumPayloadEncoded}}
+
+	var got string
+	handler := EpayAPIMiddleware(envStore)(api.Init(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = "check" }),
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = "billing" }),
+	))
+
+	cases := []struct {
+		payload string
+		want    string
+	}{
+		{"IDN=123\nTYPE=CHECK\n", "check"},
+		{"IDN=123\nTYPE=billing\n", "billing"},
+		{"IDN=123\nTYPE=OTHER\n", ""},
+	}
+
+	for _, c := range cases {
+		encoded := base64.StdEncoding.EncodeToString([]byte(c.payload))
+		q := url.Values{"ENCODED": {encoded}, "CHECKSUM": {epay.Sign(epay.ChecksumHMACSHA256, "::secret::", []byte(encoded))}}
+
+		got = ""
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/pay/init?"+q.Encode(), nil))
+
+		if got != c.want {
+			t.Errorf("expected payload %q to be handled by '%s', but got: '%s'", c.payload, c.want, got)
+		}
+		if c.want == "" && !strings.Contains(rec.Body.String(), `"STATUS":"96"`) {
+			t.Errorf("expected unknown type to be rejected, but got: %s", rec.Body.String())
+		}
+	}
+}
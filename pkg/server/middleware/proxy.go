@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/clouway/go-epay/pkg/server"
+)
+
+const (
+	// HeaderCloudflareIP is the header of the client IP set by Cloudflare.
+	HeaderCloudflareIP = "CF-Connecting-IP"
+	// HeaderForwardedFor is the header of the chain of IPs set by the proxies.
+	HeaderForwardedFor = "X-Forwarded-For"
+	// HeaderAppEngineIP is the header of the client IP set by Google App Engine.
+	HeaderAppEngineIP = "X-Appengine-User-Ip"
+	// HeaderAppsMetadata is the header of the metadata set by Google App Engine.
+	HeaderAppsMetadata = "X-Google-Apps-Metadata"
+)
+
+// DefaultIPHeaders are the headers of the client IP in order of preference.
+var DefaultIPHeaders = []string{HeaderCloudflareIP, HeaderForwardedFor}
+
+// TrustedProxies resolves the IP of the client and the host of the tenant
+// using the headers set by the trusted proxies. The headers of the requests
+// which are not coming from a trusted proxy are ignored.
+type TrustedProxies struct {
+	networks []*net.IPNet
+	headers  []string
+}
+
+// NewTrustedProxies creates a new TrustedProxies of the provided networks, as
+// CIDRs or IPs, and headers of the client IP in order of preference.
+// DefaultIPHeaders are used when no headers are provided.
+func NewTrustedProxies(networks, headers []string) (*TrustedProxies, error) {
+	p := &TrustedProxies{}
+	for _, h := range headers {
+		if h = strings.TrimSpace(h); h != "" {
+			p.headers = append(p.headers, h)
+		}
+	}
+	if len(p.headers) == 0 {
+		p.headers = DefaultIPHeaders
+	}
+	for _, v := range networks {
+		v = strings.TrimSpace(v)
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s'", v)
+		}
+		p.networks = append(p.networks, n)
+	}
+	return p, nil
+}
+
+// Trusts reports whether the IP is of a trusted proxy.
+func (p *TrustedProxies) Trusts(ip net.IP) bool {
+	for _, n := range p.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP resolves the IP of the client of the request. The IP of the peer is
+// returned when it's not a trusted proxy. The chain of X-Forwarded-For is walked
+// from the nearest proxy and the first IP which is not trusted is the client.
+func (p *TrustedProxies) ClientIP(r *http.Request) net.IP {
+	peer := remoteIP(r)
+	if peer == nil || !p.Trusts(peer) {
+		return peer
+	}
+
+	for _, h := range p.headers {
+		v := r.Header.Get(h)
+		if v == "" {
+			continue
+		}
+		if !strings.EqualFold(h, HeaderForwardedFor) {
+			if ip := net.ParseIP(strings.TrimSpace(v)); ip != nil {
+				return ip
+			}
+			continue
+		}
+
+		chain := strings.Split(strings.Join(r.Header[http.CanonicalHeaderKey(h)], ","), ",")
+		client := peer
+		for i := len(chain) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(chain[i]))
+			if ip == nil {
+				break
+			}
+			client = ip
+			if !p.Trusts(ip) {
+				break
+			}
+		}
+		return client
+	}
+	return peer
+}
+
+// Host resolves the host of the tenant of the request. The host of the
+// X-Google-Apps-Metadata header is used when the request is coming from
+// a trusted proxy, otherwise the Host of the request is used.
+func (p *TrustedProxies) Host(r *http.Request) string {
+	if ip := remoteIP(r); ip != nil && p.Trusts(ip) {
+		if host := metadataHost(r.Header[HeaderAppsMetadata]); host != "" {
+			return host
+		}
+	}
+	return requestHost(r)
+}
+
+// RealIP is a middleware which resolves the IP of the client and the host of the
+// tenant through the trusted proxies and passes them to the next handlers.
+func RealIP(proxies *TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), server.ClientIPKey, proxies.ClientIP(r))
+			ctx = context.WithValue(ctx, server.HostKey, proxies.Host(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// metadataHost gets the host of the X-Google-Apps-Metadata values which are
+// having comma separated key=value pairs, e.g domain=example.com,host=epay.example.com.
+func metadataHost(values []string) string {
+	for _, v := range values {
+		for _, pair := range strings.Split(v, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "host") && kv[1] != "" {
+				return strings.ToLower(stripPort(kv[1]))
+			}
+		}
+	}
+	return ""
+}
+
+// requestHost gets the host of the request without the port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	return strings.ToLower(stripPort(host))
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
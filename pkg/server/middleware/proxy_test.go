@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := NewTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"direct client", "91.196.124.1:5000", nil, "91.196.124.1"},
+		{"headers of untrusted peer are ignored", "91.196.124.1:5000", map[string]string{"CF-Connecting-IP": "1.2.3.4"}, "91.196.124.1"},
+		{"cloudflare", "10.0.0.2:5000", map[string]string{"CF-Connecting-IP": "1.2.3.4", "X-Forwarded-For": "5.6.7.8"}, "1.2.3.4"},
+		{"trusted chain is skipped", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.5"}, "1.2.3.4"},
+		{"only trusted chain", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "10.0.0.5"}, "10.0.0.5"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/v1/pay/init", nil)
+		r.RemoteAddr = c.remote
+		for k, v := range c.headers {
+			r.Header.Set(k, v)
+		}
+		if got := proxies.ClientIP(r); got.String() != c.want {
+			t.Errorf("%s: expected client IP %s, but got: %s", c.name, c.want, got)
+		}
+	}
+}
+
+func TestHost(t *testing.T) {
+	proxies, err := NewTrustedProxies([]string{"10.0.0.0/8"}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "http://EPAY.isp1.example.com:8090/v1/pay/init", nil)
+	r.Header.Set(HeaderAppsMetadata, "domain=example.com, host=epay.isp2.example.com")
+
+	r.RemoteAddr = "91.196.124.1:5000"
+	if got := proxies.Host(r); got != "epay.isp1.example.com" {
+		t.Errorf("expected host of untrusted peer to be epay.isp1.example.com, but got: %s", got)
+	}
+
+	r.RemoteAddr = "10.0.0.2:5000"
+	if got := proxies.Host(r); got != "epay.isp2.example.com" {
+		t.Errorf("expected host of trusted peer to be epay.isp2.example.com, but got: %s", got)
+	}
+}
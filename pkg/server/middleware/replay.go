@@ -0,0 +1,201 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server"
+	"github.com/clouway/go-epay/pkg/server/api"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+const (
+	// DefaultReplayTTL is the time for which the handled requests are remembered.
+	DefaultReplayTTL = 30 * 24 * time.Hour
+
+	// DefaultReplayWindow is the allowed difference between the TIMESTAMP of
+	// the requests and the time of the server.
+	DefaultReplayWindow = 15 * time.Minute
+)
+
+// ReplayStore keeps the keys of the requests which were already handled.
+type ReplayStore interface {
+
+	// Reserve reserves the request with the provided key until the provided
+	// expiry time. It reports false when the request is already reserved and
+	// it's not expired yet. The reservation must be atomic, so concurrent
+	// requests with the same key could not be both reserved.
+	Reserve(ctx context.Context, key string, expiresAt time.Time) (bool, error)
+
+	// Release releases the reservation of the request with the provided key.
+	Release(ctx context.Context, key string) error
+}
+
+// ReplayConfig is the configuration of ReplayGuard.
+type ReplayConfig struct {
+	// TTL is the time for which the handled requests are remembered,
+	// DefaultReplayTTL is used when it's zero.
+	TTL time.Duration
+
+	// Window is the allowed difference between the TIMESTAMP of the
+	// requests and the time of the server, DefaultReplayWindow is used
+	// when it's zero.
+	Window time.Duration
+}
+
+// ReplayGuard is a middleware which answers the requests that were already handled
+// successfully with the duplicateStatus instead of handling them again. The requests
+// are identified by their path, MERCHANTID, TID, TYPE and CHECKSUM, so it must be
+// used after EpayAPIMiddleware. The requests are reserved before they are handled,
+// so concurrent duplicates are answered with the duplicateStatus as well, and the
+// reservation is released when the request is not handled successfully so ePay
+// could retry it. The requests which TIMESTAMP, in unix seconds, is out of the
+// configured window are rejected as a common error.
+func ReplayGuard(store ReplayStore, config ReplayConfig, duplicateStatus string) func(http.Handler) http.Handler {
+	if config.TTL == 0 {
+		config.TTL = DefaultReplayTTL
+	}
+	if config.Window == 0 {
+		config.Window = DefaultReplayWindow
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			contextLogger := log.WithContext(ctx)
+			q := r.URL.Query()
+
+			if v := q.Get("TIMESTAMP"); v != "" {
+				sec, err := strconv.ParseInt(v, 10, 64)
+				if err != nil || absDuration(time.Since(time.Unix(sec, 0))) > config.Window {
+					contextLogger.Warnf("rejecting request of TID %s with timestamp '%s' out of the window", q.Get("TID"), v)
+					httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusCommonError})
+					return
+				}
+			}
+
+			key := replayKey(r)
+			reserved, err := store.Reserve(ctx, key, time.Now().Add(config.TTL))
+			if err != nil {
+				contextLogger.Errorf("could not check for replay of TID %s due: %v", q.Get("TID"), err)
+				httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusTemporaryNotAvailable})
+				return
+			}
+			if !reserved {
+				contextLogger.Debugf("request of TID %s was already handled", q.Get("TID"))
+				httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: duplicateStatus})
+				return
+			}
+
+			handled := false
+			defer func() {
+				if handled {
+					return
+				}
+				// the request context could be already canceled
+				if err := store.Release(context.Background(), key); err != nil {
+					contextLogger.Errorf("could not release request of TID %s due: %v", q.Get("TID"), err)
+				}
+			}()
+
+			rw := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+
+			var res api.DutyResponse
+			handled = json.Unmarshal(rw.body.Bytes(), &res) == nil && res.Status == api.StatusSuccess
+		})
+	}
+}
+
+// replayKey builds the key of the request from its path, the merchant of the
+// environment, TID, TYPE and the verified CHECKSUM, so it's not changed by the
+// parameters which are not covered by the checksum.
+func replayKey(r *http.Request) string {
+	q := r.URL.Query()
+	var merchantID string
+	if env, ok := r.Context().Value(server.EnvironmentKey).(*epay.Environment); ok {
+		merchantID = env.MerchantID
+	}
+	checksum, _ := r.Context().Value(server.ChecksumKey).(string)
+
+	parts := []string{r.URL.Path, merchantID, q.Get("TID"), q.Get("TYPE"), checksum}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// statusRecorder keeps a copy of the response so its status could be checked
+// after it's sent.
+type statusRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// memorySweepInterval is the interval at which the expired requests are
+// removed from MemoryReplayStore.
+const memorySweepInterval = time.Minute
+
+// MemoryReplayStore is a ReplayStore which keeps the requests in the process.
+type MemoryReplayStore struct {
+	mu      sync.Mutex
+	keys    map[string]time.Time
+	sweptAt time.Time
+}
+
+// NewMemoryReplayStore creates a new in-memory replay store.
+func NewMemoryReplayStore() *MemoryReplayStore {
+	return &MemoryReplayStore{keys: make(map[string]time.Time)}
+}
+
+// Reserve reserves the request with the provided key. The expired requests
+// are removed at most once per memorySweepInterval.
+func (s *MemoryReplayStore) Reserve(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.sweptAt) >= memorySweepInterval {
+		for k, v := range s.keys {
+			if !now.Before(v) {
+				delete(s.keys, k)
+			}
+		}
+		s.sweptAt = now
+	}
+	if v, ok := s.keys[key]; ok && now.Before(v) {
+		return false, nil
+	}
+	s.keys[key] = expiresAt
+	return true, nil
+}
+
+// Release releases the reservation of the request with the provided key.
+func (s *MemoryReplayStore) Release(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.keys, key)
+	return nil
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server"
+	"github.com/clouway/go-epay/pkg/server/api"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+func TestReplayGuard(t *testing.T) {
+	calls := 0
+	status := api.StatusTemporaryNotAvailable
+	handler := ReplayGuard(NewMemoryReplayStore(), ReplayConfig{}, api.StatusAlreadyPaid)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		httputil.RespondWithJSON(r.Context(), w, &api.DutyResponse{Status: status})
+	}))
+
+	confirm := func(target string) string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		return strings.TrimSpace(rec.Body.String())
+	}
+
+	target := "/v1/pay/confirm?TYPE=BILLING&TID=1&MERCHANTID=M1&CHECKSUM=abc"
+
+	// failed requests could be retried
+	confirm(target)
+	status = api.StatusSuccess
+	if got := confirm(target); got != `{"STATUS":"00"}` {
+		t.Errorf("expected retried request to succeed, but got: %s", got)
+	}
+	if got := confirm(target); got != `{"STATUS":"94"}` {
+		t.Errorf("expected replayed request to be already paid, but got: %s", got)
+	}
+	if calls != 2 {
+		t.Errorf("expected handler to be called 2 times, but was called %d times", calls)
+	}
+
+	if got := confirm("/v1/pay/confirm?TYPE=BILLING&TID=2&MERCHANTID=M1&CHECKSUM=def"); got != `{"STATUS":"00"}` {
+		t.Errorf("expected other transaction to succeed, but got: %s", got)
+	}
+}
+
+func TestReplayGuardReservesConcurrentRequests(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	handler := ReplayGuard(NewMemoryReplayStore(), ReplayConfig{}, api.StatusAlreadyPaid)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		httputil.RespondWithJSON(r.Context(), w, &api.DutyResponse{Status: api.StatusSuccess})
+	}))
+
+	target := "/v1/pay/confirm?TYPE=BILLING&TID=1&MERCHANTID=M1&CHECKSUM=abc"
+
+	first := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(first, httptest.NewRequest("GET", target, nil))
+		close(done)
+	}()
+	<-started
+
+	second := httptest.NewRecorder()
+	handler.ServeHTTP(second, httptest.NewRequest("GET", target, nil))
+	close(finish)
+	<-done
+
+	if got := strings.TrimSpace(first.Body.String()); got != `{"STATUS":"00"}` {
+		t.Errorf("expected first request to succeed, but got: %s", got)
+	}
+	if got := strings.TrimSpace(second.Body.String()); got != `{"STATUS":"94"}` {
+		t.Errorf("expected concurrent request to be already paid, but got: %s", got)
+	}
+}
+
+func TestReplayKeyUsesVerifiedValues(t *testing.T) {
+	env := &epay.Environment{MerchantID: "M1"}
+	key := func(target, checksum string) string {
+		r := httptest.NewRequest("GET", target, nil)
+		ctx := context.WithValue(r.Context(), server.EnvironmentKey, env)
+		ctx = context.WithValue(ctx, server.ChecksumKey, checksum)
+		return replayKey(r.WithContext(ctx))
+	}
+
+	want := key("/v1/pay/confirm?TYPE=BILLING&TID=1", "abc")
+	if got := key("/v1/pay/confirm?TYPE=BILLING&TID=1&MERCHANTID=M2&TIMESTAMP=1", "abc"); got != want {
+		t.Error("expected key to not depend on the MERCHANTID and TIMESTAMP of the request")
+	}
+	if got := key("/v1/pay/confirm?TYPE=BILLING&TID=1", "def"); got == want {
+		t.Error("expected key to depend on the verified checksum")
+	}
+}
+
+func TestMemoryReplayStoreExpiresReservations(t *testing.T) {
+	store := NewMemoryReplayStore()
+	ctx := context.Background()
+
+	if reserved, _ := store.Reserve(ctx, "::key::", time.Now().Add(-time.Second)); !reserved {
+		t.Fatal("expected request to be reserved")
+	}
+	if reserved, _ := store.Reserve(ctx, "::key::", time.Now().Add(time.Hour)); !reserved {
+		t.Error("expected expired request to be reserved again")
+	}
+	if reserved, _ := store.Reserve(ctx, "::key::", time.Now().Add(time.Hour)); reserved {
+		t.Error("expected reserved request to be not reserved again")
+	}
+}
+
+func TestReplayGuardTimestampWindow(t *testing.T) {
+	handler := ReplayGuard(NewMemoryReplayStore(), ReplayConfig{Window: time.Minute}, api.StatusAlreadyPaid)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		httputil.RespondWithJSON(r.Context(), w, &api.DutyResponse{Status: api.StatusSuccess})
+	}))
+
+	cases := []struct {
+		timestamp string
+		want      string
+	}{
+		{strconv.FormatInt(time.Now().Unix(), 10), `{"STATUS":"00"}`},
+		{strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10), `{"STATUS":"96"}`},
+		{strconv.FormatInt(time.Now().Add(2*time.Minute).Unix(), 10), `{"STATUS":"96"}`},
+		{"::invalid::", `{"STATUS":"96"}`},
+	}
+	for i, c := range cases {
+		rec := httptest.NewRecorder()
+		target := fmt.Sprintf("/v1/pay/confirm?TYPE=BILLING&TID=%d&TIMESTAMP=%s", i, c.timestamp)
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if got := strings.TrimSpace(rec.Body.String()); got != c.want {
+			t.Errorf("timestamp %s: expected %s, but got: %s", c.timestamp, c.want, got)
+		}
+	}
+}
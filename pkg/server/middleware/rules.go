@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server"
+	"github.com/clouway/go-epay/pkg/server/api"
+	"github.com/clouway/go-epay/pkg/server/httputil"
+)
+
+// SubscriberRules is a middleware which answers the requests matched by the subscriber
+// rules of the environment with the status of the rule instead of processing them. It
+// must be used after EpayAPIMiddleware as the rules are read from the environment.
+func SubscriberRules(req epay.RuleRequest) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			contextLogger := log.WithContext(ctx)
+
+			env, ok := ctx.Value(server.EnvironmentKey).(*epay.Environment)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			idn := r.URL.Query().Get("IDN")
+			rule, err := epay.MatchRule(env.SubscriberRules, req, idn, time.Now())
+			if err != nil {
+				contextLogger.Errorf("could not match subscriber rules due: %v", err)
+				httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: api.StatusTemporaryNotAvailable})
+				return
+			}
+			if rule == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			contextLogger.Debugf("Skipping %s of '%s' due rule '%s'", req, idn, rule.Name)
+			httputil.RespondWithJSON(ctx, w, &api.DutyResponse{Status: rule.Status})
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// encryptedPrefix marks the values which are encrypted with AES-256-GCM.
+const encryptedPrefix = "enc:v1:"
+
+// SecretsKeySize is the size of the key used for encryption of the secrets.
+const SecretsKeySize = 32
+
+// ErrInvalidSecretsKey is returned when the key for encryption of the
+// secrets is not SecretsKeySize bytes long.
+var ErrInvalidSecretsKey = errors.New("secrets key must be 32 bytes")
+
+// secretBox encrypts and decrypts the secrets which are kept at rest.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+func newSecretBox(key []byte) (*secretBox, error) {
+	if len(key) != SecretsKeySize {
+		return nil, ErrInvalidSecretsKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+// seal encrypts the value. Empty values are kept empty.
+func (b *secretBox) seal(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts the value. Values which are not encrypted are returned as is,
+// so records written before the encryption are still readable.
+func (b *secretBox) open(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("could not decode secret due: %v", err)
+	}
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return "", fmt.Errorf("could not decrypt secret: value is too short")
+	}
+	plain, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt secret due: %v", err)
+	}
+	return string(plain), nil
+}
+
+// ParseSecretsKey decodes a base64 or hex encoded key for encryption of the secrets.
+func ParseSecretsKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil && len(key) == SecretsKeySize {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(s); err == nil && len(key) == SecretsKeySize {
+		return key, nil
+	}
+	return nil, ErrInvalidSecretsKey
+}
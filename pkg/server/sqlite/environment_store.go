@@ -0,0 +1,385 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server/env"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SecretMetadataKeys are the keys of the metadata which are encrypted at rest.
+var SecretMetadataKeys = []string{"apiKey", "splynxApiKey", "splynxApiSecret", "restAuthValue"}
+
+// EnvironmentStore implements epay.EnvironmentStore using SQLite. The secrets of
+// the environments are encrypted at rest with the key provided on creation.
+type EnvironmentStore struct {
+	db  *sql.DB
+	box *secretBox
+}
+
+// NewEnvironmentStore creates a new SQLite-backed environment store. The key
+// used for encryption of the secrets must be SecretsKeySize bytes long.
+func NewEnvironmentStore(dbPath string, secretsKey []byte) (*EnvironmentStore, error) {
+	box, err := newSecretBox(secretsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS environments (
+			name TEXT PRIMARY KEY,
+			merchant_id TEXT NOT NULL DEFAULT '',
+			epay_secret TEXT NOT NULL DEFAULT '',
+			billing_system TEXT NOT NULL DEFAULT '',
+			billing_url TEXT NOT NULL DEFAULT '',
+			billing_jwt_key TEXT NOT NULL DEFAULT '',
+			amount_policy TEXT NOT NULL DEFAULT '',
+			currency TEXT NOT NULL DEFAULT '',
+			metadata TEXT NOT NULL DEFAULT '{}'
+		);
+		CREATE TABLE IF NOT EXISTS environment_hosts (
+			host TEXT PRIMARY KEY,
+			name TEXT NOT NULL REFERENCES environments(name) ON DELETE CASCADE
+		);
+		CREATE TABLE IF NOT EXISTS environment_default (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			name TEXT NOT NULL
+		);
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	columns := []struct{ name, def string }{
+		{"billing_routes", "TEXT NOT NULL DEFAULT '[]'"},
+		{"checksum_algorithm", "TEXT NOT NULL DEFAULT ''"},
+		{"checksum_payload", "TEXT NOT NULL DEFAULT ''"},
+		{"sign_responses", "INTEGER NOT NULL DEFAULT 0"},
+		{"allowed_networks", "TEXT NOT NULL DEFAULT '[]'"},
+		{"subscriber_rules", "TEXT NOT NULL DEFAULT '[]'"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(db, "environments", c.name, c.def); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	// the environments are looked up by their merchant ID, so it must be unique
+	if _, err := db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS environments_merchant_id ON environments(merchant_id) WHERE merchant_id != ''`); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create unique index of the merchant IDs, the environments with the same merchant ID must be fixed first: %v", err)
+	}
+
+	return &EnvironmentStore{db: db, box: box}, nil
+}
+
+// Get gets the environment of the provided name, host or merchant ID. The
+// default environment is returned when the name is empty. The environment
+// is validated as it could be stored by a previous version.
+func (s *EnvironmentStore) Get(ctx context.Context, name string) (*epay.Environment, error) {
+	t, err := s.find(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid environment '%s': %v", t.Name, err)
+	}
+	e := t.Environment()
+	return &e, nil
+}
+
+// Default gets the name, host or merchant ID of the default environment. It
+// returns an empty string when the default environment is not set and the
+// environment with name "default" is used.
+func (s *EnvironmentStore) Default(ctx context.Context) (string, error) {
+	var name string
+	err := s.db.QueryRowContext(ctx, `SELECT name FROM environment_default WHERE id = 1`).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return name, err
+}
+
+// SetDefault sets the name, host or merchant ID of the default environment.
+func (s *EnvironmentStore) SetDefault(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT OR REPLACE INTO environment_default (id, name) VALUES (1, ?)`, strings.ToLower(name))
+	return err
+}
+
+// GetTenant gets the configuration of the environment with the provided name.
+func (s *EnvironmentStore) GetTenant(ctx context.Context, name string) (*env.Tenant, error) {
+	row := s.db.QueryRowContext(ctx, selectEnvironment+` WHERE name = ?`, name)
+	t, err := s.scan(row)
+	if err != nil {
+		return nil, err
+	}
+	if t.Hosts, err = s.hosts(ctx, t.Name); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// List lists the configuration of all environments ordered by name.
+func (s *EnvironmentStore) List(ctx context.Context) ([]*env.Tenant, error) {
+	rows, err := s.db.QueryContext(ctx, selectEnvironment+` ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []*env.Tenant
+	for rows.Next() {
+		t, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tenants {
+		if t.Hosts, err = s.hosts(ctx, t.Name); err != nil {
+			return nil, err
+		}
+	}
+	return tenants, nil
+}
+
+// Put validates and saves the configuration of the environment. The name of the
+// environment is used as the key.
+func (s *EnvironmentStore) Put(ctx context.Context, t *env.Tenant) error {
+	if err := t.Validate(); err != nil {
+		return fmt.Errorf("invalid environment '%s': %v", t.Name, err)
+	}
+
+	epaySecret, err := s.box.seal(t.EpaySecret)
+	if err != nil {
+		return err
+	}
+	jwtKey, err := s.box.seal(t.BillingJWTKey)
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string, len(t.Metadata))
+	for k, v := range t.Metadata {
+		metadata[k] = v
+	}
+	for _, k := range SecretMetadataKeys {
+		if metadata[k], err = s.box.seal(metadata[k]); err != nil {
+			return err
+		}
+		if metadata[k] == "" {
+			delete(metadata, k)
+		}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	routes := t.BillingRoutes
+	if routes == nil {
+		routes = []epay.BillingRoute{}
+	}
+	routesJSON, err := json.Marshal(routes)
+	if err != nil {
+		return err
+	}
+	rules := t.SubscriberRules
+	if rules == nil {
+		rules = []epay.SubscriberRule{}
+	}
+	rulesJSON, err := json.Marshal(rules)
+	if err != nil {
+		return err
+	}
+	networks := t.AllowedNetworks
+	if networks == nil {
+		networks = []string{}
+	}
+	networksJSON, err := json.Marshal(networks)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if t.MerchantID != "" {
+		var other string
+		err := tx.QueryRowContext(ctx, `SELECT name FROM environments WHERE merchant_id = ? AND name != ?`, t.MerchantID, t.Name).Scan(&other)
+		if err == nil {
+			return fmt.Errorf("merchant ID '%s' is used by environment '%s'", t.MerchantID, other)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT OR REPLACE INTO environments
+		(name, merchant_id, epay_secret, billing_system, billing_routes, billing_url, billing_jwt_key, amount_policy, currency, checksum_algorithm, checksum_payload, sign_responses, allowed_networks, subscriber_rules, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, t.Name, t.MerchantID, epaySecret, t.BillingSystem, string(routesJSON), t.BillingURL, jwtKey, t.AmountPolicy, t.Currency, t.ChecksumAlgorithm, t.ChecksumPayload, t.SignResponses, string(networksJSON), string(rulesJSON), string(metadataJSON))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM environment_hosts WHERE name = ?`, t.Name); err != nil {
+		return err
+	}
+	for _, host := range t.Hosts {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO environment_hosts (host, name) VALUES (?, ?)`, strings.ToLower(host), t.Name); err != nil {
+			return fmt.Errorf("could not add host '%s' due: %v", host, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Delete deletes the environment with the provided name.
+func (s *EnvironmentStore) Delete(ctx context.Context, name string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM environment_hosts WHERE name = ?`, name); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM environments WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%w: '%s'", epay.ErrEnvironmentNotFound, name)
+	}
+	return tx.Commit()
+}
+
+// Close closes the database connection.
+func (s *EnvironmentStore) Close() error {
+	return s.db.Close()
+}
+
+const selectEnvironment = `
+	SELECT name, merchant_id, epay_secret, billing_system, billing_routes, billing_url, billing_jwt_key, amount_policy, currency, checksum_algorithm, checksum_payload, sign_responses, allowed_networks, subscriber_rules, metadata
+	FROM environments`
+
+// find finds the environment by name, host or merchant ID.
+func (s *EnvironmentStore) find(ctx context.Context, name string) (*env.Tenant, error) {
+	if name == "" {
+		var err error
+		if name, err = s.Default(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if name == "" {
+		name = "default"
+	}
+
+	host := strings.ToLower(name)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	row := s.db.QueryRowContext(ctx, selectEnvironment+`
+		WHERE name = ?1
+		OR name = (SELECT name FROM environment_hosts WHERE host = ?2)
+		OR merchant_id = ?1
+		ORDER BY name = ?1 DESC
+		LIMIT 1
+	`, name, host)
+	t, err := s.scan(row)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the environment '%s' due: %w", name, err)
+	}
+	return t, nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (s *EnvironmentStore) scan(row scanner) (*env.Tenant, error) {
+	var t env.Tenant
+	var routesJSON, networksJSON, rulesJSON, metadataJSON string
+	err := row.Scan(&t.Name, &t.MerchantID, &t.EpaySecret, &t.BillingSystem, &routesJSON, &t.BillingURL, &t.BillingJWTKey, &t.AmountPolicy, &t.Currency, &t.ChecksumAlgorithm, &t.ChecksumPayload, &t.SignResponses, &networksJSON, &rulesJSON, &metadataJSON)
+	if err == sql.ErrNoRows {
+		return nil, epay.ErrEnvironmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if t.EpaySecret, err = s.box.open(t.EpaySecret); err != nil {
+		return nil, err
+	}
+	if t.BillingJWTKey, err = s.box.open(t.BillingJWTKey); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(routesJSON), &t.BillingRoutes); err != nil {
+		return nil, fmt.Errorf("could not parse billing routes due: %v", err)
+	}
+	if len(t.BillingRoutes) == 0 {
+		t.BillingRoutes = nil
+	}
+	if err := json.Unmarshal([]byte(networksJSON), &t.AllowedNetworks); err != nil {
+		return nil, fmt.Errorf("could not parse allowed networks due: %v", err)
+	}
+	if len(t.AllowedNetworks) == 0 {
+		t.AllowedNetworks = nil
+	}
+	if err := json.Unmarshal([]byte(rulesJSON), &t.SubscriberRules); err != nil {
+		return nil, fmt.Errorf("could not parse subscriber rules due: %v", err)
+	}
+	if len(t.SubscriberRules) == 0 {
+		t.SubscriberRules = nil
+	}
+	if err := json.Unmarshal([]byte(metadataJSON), &t.Metadata); err != nil {
+		return nil, fmt.Errorf("could not parse metadata due: %v", err)
+	}
+	for _, k := range SecretMetadataKeys {
+		if v, ok := t.Metadata[k]; ok {
+			if t.Metadata[k], err = s.box.open(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &t, nil
+}
+
+func (s *EnvironmentStore) hosts(ctx context.Context, name string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT host FROM environment_hosts WHERE name = ? ORDER BY host`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hosts []string
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, rows.Err()
+}
@@ -0,0 +1,250 @@
+package sqlite
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/clouway/go-epay/pkg/epay"
+	"github.com/clouway/go-epay/pkg/server/env"
+)
+
+var testSecretsKey = bytes.Repeat([]byte{7}, SecretsKeySize)
+
+const testJWTKey = `{"type": "service_account", "client_email": "epay@example.com", "private_key": "::jwt key::"}`
+
+func TestEnvironmentStore_PutAndGet(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_environments_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewEnvironmentStore(tmpFile.Name(), testSecretsKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	tenant := &env.Tenant{
+		Name:          "isp1",
+		Hosts:         []string{"epay.isp1.example.com"},
+		MerchantID:    "D000000001",
+		EpaySecret:    "::epay secret::",
+		BillingSystem: "ucrm",
+		Currency:      "EUR",
+		Metadata:      map[string]string{"billingUrl": "https://ucrm.example.com", "apiKey": "::api key::", "methodId": "::method::"},
+	}
+	if err := store.Put(ctx, tenant); err != nil {
+		t.Fatalf("Failed to put environment: %v", err)
+	}
+
+	for _, name := range []string{"isp1", "epay.isp1.example.com", "EPAY.isp1.example.com:443", "D000000001"} {
+		e, err := store.Get(ctx, name)
+		if err != nil {
+			t.Errorf("Failed to get environment by '%s': %v", name, err)
+			continue
+		}
+		if e.EpaySecret != "::epay secret::" || e.Metadata["apiKey"] != "::api key::" || e.Currency != "EUR" || e.BillingSystem != "ucrm" {
+			t.Errorf("Environment mismatch for '%s': %+v", name, e)
+		}
+	}
+
+	if _, err := store.Get(ctx, "unknown.example.com"); !errors.Is(err, epay.ErrEnvironmentNotFound) {
+		t.Errorf("Expected unknown environment to be not found, but got: %v", err)
+	}
+
+	got, err := store.GetTenant(ctx, "isp1")
+	if err != nil {
+		t.Fatalf("Failed to get tenant: %v", err)
+	}
+	if len(got.Hosts) != 1 || got.Hosts[0] != "epay.isp1.example.com" {
+		t.Errorf("Hosts mismatch: got %v", got.Hosts)
+	}
+
+	if err := store.Delete(ctx, "isp1"); err != nil {
+		t.Fatalf("Failed to delete environment: %v", err)
+	}
+	if _, err := store.Get(ctx, "epay.isp1.example.com"); err == nil {
+		t.Error("Expected deleted environment to be missing")
+	}
+}
+
+func TestEnvironmentStore_EncryptsSecrets(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_environments_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewEnvironmentStore(tmpFile.Name(), testSecretsKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	tenant := &env.Tenant{
+		Name:          "default",
+		EpaySecret:    "::epay secret::",
+		BillingSystem: "telcong",
+		BillingURL:    "https://billing.example.com",
+		BillingJWTKey: testJWTKey,
+	}
+	if err := store.Put(ctx, tenant); err != nil {
+		t.Fatalf("Failed to put environment: %v", err)
+	}
+
+	db, _ := sql.Open("sqlite3", tmpFile.Name())
+	defer db.Close()
+
+	var epaySecret, jwtKey string
+	db.QueryRow(`SELECT epay_secret, billing_jwt_key FROM environments WHERE name = 'default'`).Scan(&epaySecret, &jwtKey)
+	for _, v := range []string{epaySecret, jwtKey} {
+		if !strings.HasPrefix(v, encryptedPrefix) || strings.Contains(v, "::") {
+			t.Errorf("Expected secret to be encrypted, but got: %s", v)
+		}
+	}
+
+	// the environment is the default one
+	if e, err := store.Get(ctx, ""); err != nil || e.BillingJWTKey != testJWTKey {
+		t.Errorf("Failed to get default environment: %+v, %v", e, err)
+	}
+
+	otherKey := bytes.Repeat([]byte{8}, SecretsKeySize)
+	other, err := NewEnvironmentStore(tmpFile.Name(), otherKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer other.Close()
+	if _, err := other.Get(ctx, "default"); err == nil {
+		t.Error("Expected secrets not to be decrypted with another key")
+	}
+}
+
+func TestEnvironmentStore_RejectsDuplicatedMerchantID(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_environments_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewEnvironmentStore(tmpFile.Name(), testSecretsKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	tenant := func(name, merchantID string) *env.Tenant {
+		return &env.Tenant{
+			Name:          name,
+			MerchantID:    merchantID,
+			EpaySecret:    "::epay secret::",
+			BillingSystem: "ucrm",
+			Metadata:      map[string]string{"billingUrl": "https://ucrm.example.com", "apiKey": "::api key::", "methodId": "::method::"},
+		}
+	}
+
+	for _, name := range []string{"isp1", "isp2"} {
+		if err := store.Put(ctx, tenant(name, "")); err != nil {
+			t.Fatalf("Failed to put environment without merchant ID: %v", err)
+		}
+	}
+	if err := store.Put(ctx, tenant("isp1", "D000000001")); err != nil {
+		t.Fatalf("Failed to put environment: %v", err)
+	}
+	// the environment could be updated with its own merchant ID
+	if err := store.Put(ctx, tenant("isp1", "D000000001")); err != nil {
+		t.Fatalf("Failed to update environment: %v", err)
+	}
+
+	if err := store.Put(ctx, tenant("isp2", "D000000001")); err == nil {
+		t.Error("Expected environment with duplicated merchant ID to be rejected")
+	}
+	if _, err := store.db.Exec(`UPDATE environments SET merchant_id = 'D000000001' WHERE name = 'isp2'`); err == nil {
+		t.Error("Expected duplicated merchant ID to be rejected by the database")
+	}
+}
+
+func TestEnvironmentStore_RejectsInvalidEnvironment(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_environments_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	if _, err := NewEnvironmentStore(tmpFile.Name(), []byte("short")); err != ErrInvalidSecretsKey {
+		t.Errorf("Expected invalid key error, but got: %v", err)
+	}
+
+	store, err := NewEnvironmentStore(tmpFile.Name(), testSecretsKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.Put(context.Background(), &env.Tenant{Name: "isp1", EpaySecret: "s", BillingSystem: "ucrm"}); err == nil {
+		t.Error("Expected incomplete UCRM environment to be rejected")
+	}
+
+	// environments stored by previous versions are validated when they are used
+	if _, err := store.db.Exec(`INSERT INTO environments (name, epay_secret, billing_system) VALUES ('isp2', '', 'ucrm')`); err != nil {
+		t.Fatalf("Failed to insert environment: %v", err)
+	}
+	if _, err := store.Get(context.Background(), "isp2"); err == nil {
+		t.Error("Expected invalid stored environment to be rejected")
+	}
+}
+
+func TestEnvironmentStore_Default(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_environments_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewEnvironmentStore(tmpFile.Name(), testSecretsKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	for _, name := range []string{"default", "isp1"} {
+		tenant := &env.Tenant{
+			Name:          name,
+			EpaySecret:    "::epay secret::",
+			BillingSystem: "ucrm",
+			Metadata:      map[string]string{"billingUrl": "https://ucrm.example.com", "apiKey": "::api key::", "methodId": name},
+		}
+		if err := store.Put(ctx, tenant); err != nil {
+			t.Fatalf("Failed to put environment: %v", err)
+		}
+	}
+
+	if e, err := store.Get(ctx, ""); err != nil || e.Metadata["methodId"] != "default" {
+		t.Errorf("Expected environment named default to be used, but got: %+v, %v", e, err)
+	}
+
+	if err := store.SetDefault(ctx, "ISP1"); err != nil {
+		t.Fatalf("Failed to set default environment: %v", err)
+	}
+	if name, err := store.Default(ctx); name != "isp1" || err != nil {
+		t.Errorf("Expected default environment to be isp1, but got: '%s', %v", name, err)
+	}
+	if e, err := store.Get(ctx, ""); err != nil || e.Metadata["methodId"] != "isp1" {
+		t.Errorf("Expected default environment to be used, but got: %+v, %v", e, err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/clouway/go-epay/pkg/epay"
@@ -30,8 +31,11 @@ func NewPaymentOrderStore(dbPath string) (*PaymentOrderStore, error) {
 			customer_name TEXT NOT NULL,
 			client_id TEXT NOT NULL,
 			amount TEXT NOT NULL,
+			paid_amount TEXT NOT NULL DEFAULT '',
+			currency TEXT NOT NULL DEFAULT '',
 			created_at DATETIME NOT NULL,
 			processed_on DATETIME,
+			claimed_at INTEGER,
 			invoice_ids TEXT
 		)
 	`)
@@ -40,42 +44,136 @@ func NewPaymentOrderStore(dbPath string) (*PaymentOrderStore, error) {
 		return nil, err
 	}
 
+	// Columns added after the initial version of the table
+	if err := addColumnIfMissing(db, "payment_orders", "paid_amount", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addColumnIfMissing(db, "payment_orders", "claimed_at", "INTEGER"); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if err := addColumnIfMissing(db, "payment_orders", "currency", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		db.Close()
+		return nil, err
+	}
+
 	return &PaymentOrderStore{db: db}, nil
 }
 
+// addColumnIfMissing adds the column to the table when it's not present already.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			ctype     string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// Create saves a new payment order. ErrPaymentOrderAlreadyExists is returned
+// when the order exists already.
+func (s *PaymentOrderStore) Create(ctx context.Context, po *epay.PaymentOrderRecord) error {
+	args, err := paymentOrderArgs(po)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO payment_orders
+		(transaction_id, subscriber_id, customer_name, client_id, amount, paid_amount, currency, created_at, processed_on, claimed_at, invoice_ids)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return epay.ErrPaymentOrderAlreadyExists
+	}
+	return nil
+}
+
 // Put saves a payment order.
 func (s *PaymentOrderStore) Put(ctx context.Context, po *epay.PaymentOrderRecord) error {
-	invoiceIDsJSON, err := json.Marshal(po.InvoiceIDs)
+	args, err := paymentOrderArgs(po)
 	if err != nil {
 		return err
 	}
 
+	_, err = s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO payment_orders
+		(transaction_id, subscriber_id, customer_name, client_id, amount, paid_amount, currency, created_at, processed_on, claimed_at, invoice_ids)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, args...)
+	return err
+}
+
+// paymentOrderArgs returns the values of the columns of the payment order.
+func paymentOrderArgs(po *epay.PaymentOrderRecord) ([]interface{}, error) {
+	invoiceIDsJSON, err := json.Marshal(po.InvoiceIDs)
+	if err != nil {
+		return nil, err
+	}
+
 	var processedOn *time.Time
 	if !po.ProcessedOn.IsZero() {
 		processedOn = &po.ProcessedOn
 	}
 
-	_, err = s.db.ExecContext(ctx, `
-		INSERT OR REPLACE INTO payment_orders
-		(transaction_id, subscriber_id, customer_name, client_id, amount, created_at, processed_on, invoice_ids)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, po.TransactionID, po.SubscriberID, po.CustomerName, po.ClientID, po.Amount, po.CreatedAt, processedOn, string(invoiceIDsJSON))
+	// the paid amount is empty until the order is paid
+	paidAmount := ""
+	if po.PaidAmount != (epay.Money{}) {
+		paidAmount = po.PaidAmount.String()
+	}
 
-	return err
+	// claims are kept as unix nanoseconds as they are compared in queries
+	var claimedAt *int64
+	if !po.ClaimedAt.IsZero() {
+		v := po.ClaimedAt.UnixNano()
+		claimedAt = &v
+	}
+
+	return []interface{}{po.TransactionID, po.SubscriberID, po.CustomerName, po.ClientID, po.Amount.String(), paidAmount, po.Amount.Currency(), po.CreatedAt, processedOn, claimedAt, string(invoiceIDsJSON)}, nil
 }
 
 // Get retrieves a payment order by TransactionID.
 func (s *PaymentOrderStore) Get(ctx context.Context, transactionID string) (*epay.PaymentOrderRecord, error) {
 	row := s.db.QueryRowContext(ctx, `
-		SELECT transaction_id, subscriber_id, customer_name, client_id, amount, created_at, processed_on, invoice_ids
+		SELECT transaction_id, subscriber_id, customer_name, client_id, amount, paid_amount, currency, created_at, processed_on, claimed_at, invoice_ids
 		FROM payment_orders WHERE transaction_id = ?
 	`, transactionID)
 
 	var po epay.PaymentOrderRecord
+	var amount, paidAmount, currency string
 	var invoiceIDsJSON string
 	var processedOn sql.NullTime
+	var claimedAt sql.NullInt64
 
-	err := row.Scan(&po.TransactionID, &po.SubscriberID, &po.CustomerName, &po.ClientID, &po.Amount, &po.CreatedAt, &processedOn, &invoiceIDsJSON)
+	err := row.Scan(&po.TransactionID, &po.SubscriberID, &po.CustomerName, &po.ClientID, &amount, &paidAmount, &currency, &po.CreatedAt, &processedOn, &claimedAt, &invoiceIDsJSON)
 	if err == sql.ErrNoRows {
 		return nil, epay.ErrPaymentOrderNotFound
 	}
@@ -83,10 +181,23 @@ func (s *PaymentOrderStore) Get(ctx context.Context, transactionID string) (*epa
 		return nil, err
 	}
 
+	if po.Amount, err = epay.ParseMoney(amount, currency); err != nil {
+		return nil, fmt.Errorf("could not parse amount of payment order %s due: %w", transactionID, err)
+	}
+	if paidAmount != "" {
+		if po.PaidAmount, err = epay.ParseMoney(paidAmount, currency); err != nil {
+			return nil, fmt.Errorf("could not parse paid amount of payment order %s due: %w", transactionID, err)
+		}
+	}
+
 	if processedOn.Valid {
 		po.ProcessedOn = processedOn.Time
 	}
 
+	if claimedAt.Valid {
+		po.ClaimedAt = time.Unix(0, claimedAt.Int64)
+	}
+
 	if invoiceIDsJSON != "" {
 		json.Unmarshal([]byte(invoiceIDsJSON), &po.InvoiceIDs)
 	}
@@ -94,6 +205,53 @@ func (s *PaymentOrderStore) Get(ctx context.Context, transactionID string) (*epa
 	return &po, nil
 }
 
+// Claim atomically marks the payment order as being paid.
+func (s *PaymentOrderStore) Claim(ctx context.Context, transactionID string, lease time.Duration) error {
+	now := time.Now()
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE payment_orders SET claimed_at = ?
+		WHERE transaction_id = ? AND processed_on IS NULL AND (claimed_at IS NULL OR claimed_at < ?)
+	`, now.UnixNano(), transactionID, now.Add(-lease).UnixNano())
+	if err != nil {
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return nil
+	}
+
+	po, err := s.Get(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if !po.ProcessedOn.IsZero() {
+		return epay.ErrPaymentOrderAlreadyPaid
+	}
+	return epay.ErrPaymentOrderInProgress
+}
+
+// Release releases the claim of a payment order which was not processed.
+func (s *PaymentOrderStore) Release(ctx context.Context, transactionID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE payment_orders SET claimed_at = NULL
+		WHERE transaction_id = ? AND processed_on IS NULL
+	`, transactionID)
+	return err
+}
+
+// Delete deletes a payment order which was not claimed or processed.
+func (s *PaymentOrderStore) Delete(ctx context.Context, transactionID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM payment_orders
+		WHERE transaction_id = ? AND claimed_at IS NULL AND processed_on IS NULL
+	`, transactionID)
+	return err
+}
+
 // Close closes the database connection.
 func (s *PaymentOrderStore) Close() error {
 	return s.db.Close()
@@ -33,7 +33,8 @@ func TestPaymentOrderStore_PutAndGet(t *testing.T) {
 		SubscriberID:  "SUB456",
 		CustomerName:  "Test Customer",
 		ClientID:      "CLIENT789",
-		Amount:        "100.50",
+		Amount:        epay.NewMoney(10050, "BGN"),
+		PaidAmount:    epay.NewMoney(10000, "BGN"),
 		CreatedAt:     time.Now(),
 		InvoiceIDs:    []string{"INV1", "INV2"},
 	}
@@ -64,6 +65,9 @@ func TestPaymentOrderStore_PutAndGet(t *testing.T) {
 	if retrieved.Amount != po.Amount {
 		t.Errorf("Amount mismatch: got %s, want %s", retrieved.Amount, po.Amount)
 	}
+	if retrieved.PaidAmount != po.PaidAmount {
+		t.Errorf("PaidAmount mismatch: got %v, want %v", retrieved.PaidAmount, po.PaidAmount)
+	}
 	if len(retrieved.InvoiceIDs) != 2 {
 		t.Errorf("InvoiceIDs length mismatch: got %d, want 2", len(retrieved.InvoiceIDs))
 	}
@@ -115,7 +119,7 @@ func TestPaymentOrderStore_Update(t *testing.T) {
 		SubscriberID:  "SUB456",
 		CustomerName:  "Test Customer",
 		ClientID:      "CLIENT789",
-		Amount:        "100.50",
+		Amount:        epay.NewMoney(10050, "BGN"),
 		CreatedAt:     time.Now(),
 	}
 
@@ -143,3 +147,157 @@ func TestPaymentOrderStore_Update(t *testing.T) {
 
 	t.Logf("Successfully updated PaymentOrder with ProcessedOn: %v", retrieved.ProcessedOn)
 }
+
+func TestPaymentOrderStore_Claim(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_payment_orders_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewPaymentOrderStore(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if err := store.Claim(ctx, "NONEXISTENT", time.Minute); err != epay.ErrPaymentOrderNotFound {
+		t.Errorf("Expected ErrPaymentOrderNotFound, got: %v", err)
+	}
+
+	po := &epay.PaymentOrderRecord{
+		TransactionID: "TXN123",
+		SubscriberID:  "SUB456",
+		CustomerName:  "Test Customer",
+		ClientID:      "CLIENT789",
+		Amount:        epay.NewMoney(10050, "BGN"),
+		CreatedAt:     time.Now(),
+	}
+	if err := store.Put(ctx, po); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if err := store.Claim(ctx, "TXN123", time.Minute); err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+	if err := store.Claim(ctx, "TXN123", time.Minute); err != epay.ErrPaymentOrderInProgress {
+		t.Errorf("Expected ErrPaymentOrderInProgress, got: %v", err)
+	}
+
+	// expired claims are taken over
+	if err := store.Claim(ctx, "TXN123", 0); err != nil {
+		t.Errorf("Claim of expired claim failed: %v", err)
+	}
+
+	if err := store.Release(ctx, "TXN123"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := store.Claim(ctx, "TXN123", time.Minute); err != nil {
+		t.Errorf("Claim after release failed: %v", err)
+	}
+
+	po.ProcessedOn = time.Now()
+	if err := store.Put(ctx, po); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := store.Claim(ctx, "TXN123", time.Minute); err != epay.ErrPaymentOrderAlreadyPaid {
+		t.Errorf("Expected ErrPaymentOrderAlreadyPaid, got: %v", err)
+	}
+}
+
+func TestPaymentOrderStore_CreateDoesNotOverwrite(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_payment_orders_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewPaymentOrderStore(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	po := &epay.PaymentOrderRecord{
+		TransactionID: "TXN123",
+		SubscriberID:  "SUB456",
+		ClientID:      "CLIENT789",
+		Amount:        epay.NewMoney(10050, "BGN"),
+		CreatedAt:     time.Now(),
+	}
+	if err := store.Create(ctx, po); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	po.ProcessedOn = time.Now()
+	if err := store.Put(ctx, po); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	again := &epay.PaymentOrderRecord{
+		TransactionID: "TXN123",
+		SubscriberID:  "SUB456",
+		ClientID:      "CLIENT789",
+		Amount:        epay.NewMoney(20000, "BGN"),
+		CreatedAt:     time.Now(),
+	}
+	if err := store.Create(ctx, again); err != epay.ErrPaymentOrderAlreadyExists {
+		t.Fatalf("expected ErrPaymentOrderAlreadyExists but got: %v", err)
+	}
+
+	retrieved, err := store.Get(ctx, "TXN123")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if retrieved.ProcessedOn.IsZero() {
+		t.Error("ProcessedOn was overwritten by Create")
+	}
+	if retrieved.Amount.Units() != 10050 {
+		t.Errorf("Amount was overwritten by Create: %v", retrieved.Amount)
+	}
+}
+
+func TestPaymentOrderStore_DeleteOnlyUnclaimedOrders(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_payment_orders_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewPaymentOrderStore(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	for _, tid := range []string{"TXN1", "TXN2"} {
+		if err := store.Create(ctx, &epay.PaymentOrderRecord{TransactionID: tid, Amount: epay.NewMoney(10050, "BGN"), CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+	if err := store.Claim(ctx, "TXN2", time.Minute); err != nil {
+		t.Fatalf("Claim failed: %v", err)
+	}
+
+	for _, tid := range []string{"TXN1", "TXN2"} {
+		if err := store.Delete(ctx, tid); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+
+	if _, err := store.Get(ctx, "TXN1"); err == nil {
+		t.Error("expected unclaimed order to be deleted")
+	}
+	if _, err := store.Get(ctx, "TXN2"); err != nil {
+		t.Errorf("expected claimed order to be kept, but got: %v", err)
+	}
+}
@@ -0,0 +1,70 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ReplayStore keeps the keys of the handled ePay requests using SQLite.
+type ReplayStore struct {
+	db *sql.DB
+}
+
+// NewReplayStore creates a new SQLite-backed replay store.
+func NewReplayStore(dbPath string) (*ReplayStore, error) {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS replayed_requests (
+			key TEXT PRIMARY KEY,
+			expires_at INTEGER NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &ReplayStore{db: db}, nil
+}
+
+// Reserve reserves the request with the provided key until the provided expiry
+// time and removes the expired requests. It reports false when the request is
+// already reserved.
+func (s *ReplayStore) Reserve(ctx context.Context, key string, expiresAt time.Time) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM replayed_requests WHERE expires_at <= ?`, time.Now().Unix()); err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO replayed_requests (key, expires_at) VALUES (?, ?)
+	`, key, expiresAt.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, tx.Commit()
+}
+
+// Release releases the reservation of the request with the provided key.
+func (s *ReplayStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM replayed_requests WHERE key = ?`, key)
+	return err
+}
+
+// Close closes the database connection.
+func (s *ReplayStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestReplayStore_ReserveAndRelease(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_replay_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewReplayStore(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if reserved, err := store.Reserve(ctx, "::key::", time.Now().Add(time.Hour)); !reserved || err != nil {
+		t.Fatalf("expected request to be reserved, but got: %v, %v", reserved, err)
+	}
+	if reserved, err := store.Reserve(ctx, "::key::", time.Now().Add(time.Hour)); reserved || err != nil {
+		t.Errorf("expected reserved request to be not reserved again, but got: %v, %v", reserved, err)
+	}
+
+	if err := store.Release(ctx, "::key::"); err != nil {
+		t.Fatalf("Failed to release request: %v", err)
+	}
+	if reserved, err := store.Reserve(ctx, "::key::", time.Now().Add(time.Hour)); !reserved || err != nil {
+		t.Errorf("expected released request to be reserved again, but got: %v, %v", reserved, err)
+	}
+
+	if _, err := store.Reserve(ctx, "::expired key::", time.Now().Add(-time.Second)); err != nil {
+		t.Fatalf("Failed to reserve request: %v", err)
+	}
+	if reserved, err := store.Reserve(ctx, "::expired key::", time.Now().Add(time.Hour)); !reserved || err != nil {
+		t.Errorf("expected expired request to be reserved again, but got: %v, %v", reserved, err)
+	}
+}
@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// TokenStore keeps the OAuth2 tokens of the billing systems using SQLite. The
+// tokens are encrypted at rest with the key provided on creation.
+type TokenStore struct {
+	db  *sql.DB
+	box *secretBox
+}
+
+// NewTokenStore creates a new SQLite-backed token store. The key used for
+// encryption of the tokens must be SecretsKeySize bytes long.
+func NewTokenStore(dbPath string, secretsKey []byte) (*TokenStore, error) {
+	box, err := newSecretBox(secretsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS oauth_tokens (
+			key TEXT PRIMARY KEY,
+			token TEXT NOT NULL,
+			expiry DATETIME,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &TokenStore{db: db, box: box}, nil
+}
+
+// Get gets the token with the provided key. It returns nil when there is no
+// such token.
+func (s *TokenStore) Get(ctx context.Context, key string) (*oauth2.Token, error) {
+	var sealed string
+	err := s.db.QueryRowContext(ctx, `SELECT token FROM oauth_tokens WHERE key = ?`, key).Scan(&sealed)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := s.box.open(sealed)
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	if err := json.Unmarshal([]byte(value), &token); err != nil {
+		return nil, fmt.Errorf("could not decode token of '%s' due: %v", key, err)
+	}
+	return &token, nil
+}
+
+// Put saves the token with the provided key.
+func (s *TokenStore) Put(ctx context.Context, key string, token *oauth2.Token) error {
+	b, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.box.seal(string(b))
+	if err != nil {
+		return fmt.Errorf("could not encrypt token of '%s' due: %v", key, err)
+	}
+
+	var expiry sql.NullTime
+	if !token.Expiry.IsZero() {
+		expiry = sql.NullTime{Time: token.Expiry, Valid: true}
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO oauth_tokens (key, token, expiry, updated_at)
+		VALUES (?, ?, ?, ?)
+	`, key, sealed, expiry, time.Now())
+	return err
+}
+
+// Close closes the database connection.
+func (s *TokenStore) Close() error {
+	return s.db.Close()
+}
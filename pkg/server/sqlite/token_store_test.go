@@ -0,0 +1,63 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestTokenStore_PutAndGet(t *testing.T) {
+	tmpFile, err := os.CreateTemp("", "test_tokens_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	tmpFile.Close()
+
+	store, err := NewTokenStore(tmpFile.Name(), testSecretsKey)
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if token, err := store.Get(ctx, "telcong:epay@example.com"); token != nil || err != nil {
+		t.Fatalf("expected missing token, but got: %v, %v", token, err)
+	}
+
+	expiry := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	want := &oauth2.Token{AccessToken: "::access token::", TokenType: "Bearer", Expiry: expiry}
+	if err := store.Put(ctx, "telcong:epay@example.com", want); err != nil {
+		t.Fatalf("Failed to put token: %v", err)
+	}
+
+	got, err := store.Get(ctx, "telcong:epay@example.com")
+	if err != nil {
+		t.Fatalf("Failed to get token: %v", err)
+	}
+	if got.AccessToken != want.AccessToken || got.TokenType != want.TokenType || !got.Expiry.Equal(expiry) {
+		t.Errorf("expected: %+v", want)
+		t.Errorf("     got: %+v", got)
+	}
+
+	// the token is encrypted at rest
+	db, err := sql.Open("sqlite3", tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	var sealed string
+	if err := db.QueryRow(`SELECT token FROM oauth_tokens`).Scan(&sealed); err != nil {
+		t.Fatalf("Failed to read token: %v", err)
+	}
+	if !strings.HasPrefix(sealed, encryptedPrefix) || strings.Contains(sealed, "::access token::") {
+		t.Errorf("expected token to be encrypted, but got: %s", sealed)
+	}
+}